@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/beijian128/minisocks/core"
 	"github.com/sirupsen/logrus"
@@ -20,6 +21,240 @@ type Config struct {
 	ListenAddr string `json:"listen"`   // 本地监听地址
 	RemoteAddr string `json:"remote"`   // 远程服务地址
 	Password   string `json:"password"` // 连接使用的密码
+
+	Servers   []ServerConfig   `json:"servers,omitempty"`   // 具名远程服务器，供路由规则引用
+	Rules     []string         `json:"rules,omitempty"`     // 本地端路由规则，按顺序匹配，格式为 "类型:值 -> 动作"
+	GeoIP     string           `json:"geoip,omitempty"`     // GeoIP 数据库（MaxMind MMDB 格式）路径，供 geoip 规则使用
+	Geosite   string           `json:"geosite,omitempty"`   // geosite 域名列表所在目录，供 geosite 规则使用
+	PAC       *PACConfig       `json:"pac,omitempty"`       // PAC 文件生成与服务配置
+	DNS       *DNSConfig       `json:"dns,omitempty"`       // 本地 DNS 服务配置
+	TLS       *TLSConfig       `json:"tls,omitempty"`       // 本地端与服务端之间的 TLS 传输，两端需同时配置
+	WebSocket *WebSocketConfig `json:"websocket,omitempty"` // 本地端与服务端之间的 WebSocket 传输，两端需同时配置
+	HTTP2     *HTTP2Config     `json:"http2,omitempty"`     // 本地端与服务端之间的 HTTP/2 传输，两端需同时配置
+	Obfs      *ObfsConfig      `json:"obfs,omitempty"`      // 本地端与服务端之间的流量混淆，两端需同时配置
+	HTTPObfs  *HTTPObfsConfig  `json:"httpObfs,omitempty"`  // 首个数据包伪装为 HTTP 请求，两端需同时配置
+	Status    *StatusConfig    `json:"status,omitempty"`    // 本地端状态接口，查看正在转发的连接
+	Metrics   *MetricsConfig   `json:"metrics,omitempty"`   // Prometheus 指标服务，两端均可配置
+	Log       *LogConfig       `json:"log,omitempty"`       // 日志级别、格式与输出，两端均可配置
+
+	ForwardSecrecy bool `json:"forwardSecrecy,omitempty"` // 每条连接进行临时密钥交换，密码泄露后无法解密历史会话，两端需同时配置
+
+	Resolver   string            `json:"resolver,omitempty"`   // 服务端解析目标域名使用的解析器，例如 system、tls://1.1.1.1、https://1.1.1.1/dns-query
+	IPStrategy string            `json:"ipStrategy,omitempty"` // 服务端连接目标的地址族策略：ipv4-only、ipv6-only、prefer-v4、prefer-v6
+	Egress     *EgressConfig     `json:"egress,omitempty"`     // 服务端出站访问控制，未配置时禁止访问内网与保留地址段
+	Clients    *ClientsConfig    `json:"clients,omitempty"`    // 服务端来源 IP 过滤与握手失败封禁
+	Fallback   *FallbackConfig   `json:"fallback,omitempty"`   // 服务端握手失败时的处理方式，为空时直接关闭连接
+	Users      []UserConfig      `json:"users,omitempty"`      // 服务端用户列表，配置后每个用户使用自己的密码，password 不再使用
+	RateLimit  *RateLimitConfig  `json:"rateLimit,omitempty"`  // 服务端转发限速
+	Quota      *QuotaConfig      `json:"quota,omitempty"`      // 服务端每个客户端身份的流量配额
+	MaxConns   *MaxConnsConfig   `json:"maxConns,omitempty"`   // 服务端并发连接数上限
+	Accounting *AccountingConfig `json:"accounting,omitempty"` // 服务端按天统计每个客户端身份的流量
+	Admin      *AdminConfig      `json:"admin,omitempty"`      // 服务端 HTTP 管理接口
+}
+
+// StatusConfig 定义了本地端的状态接口
+type StatusConfig struct {
+	Listen string `json:"listen"` // 监听地址，只能是本机回环地址，例如 127.0.0.1:7449
+}
+
+// LogConfig 定义了日志的级别、格式与输出，命令行参数 -log-* 优先于这里的配置
+type LogConfig struct {
+	Level      string            `json:"level,omitempty"`      // 默认级别：trace、debug、info、warn、error，默认 info
+	Format     string            `json:"format,omitempty"`     // 格式：text 或 json，默认 text
+	File       string            `json:"file,omitempty"`       // 日志文件，为空时输出到标准错误
+	MaxSize    *int              `json:"maxSize,omitempty"`    // 日志文件超过该大小（MB）时轮转，未配置时为 100，为 0 时不轮转
+	MaxBackups int               `json:"maxBackups,omitempty"` // 保留的历史文件数，为 0 时不限制
+	MaxAge     int               `json:"maxAge,omitempty"`     // 历史文件保留的天数，为 0 时不限制
+	Components map[string]string `json:"components,omitempty"` // 按组件覆盖级别，例如 {"DNS": "debug"}
+}
+
+// MetricsConfig 定义了 Prometheus 指标服务
+type MetricsConfig struct {
+	Listen string `json:"listen"` // 监听地址，指标位于 /metrics 路径，例如 127.0.0.1:9448
+}
+
+// AdminConfig 定义了服务端的 HTTP 管理接口
+type AdminConfig struct {
+	Listen string `json:"listen"`          // 监听地址，例如 127.0.0.1:7450
+	Token  string `json:"token,omitempty"` // 请求需要携带的 Bearer token，监听非本机地址时必须配置
+}
+
+// AccountingConfig 定义了服务端流量统计的持久化方式
+type AccountingConfig struct {
+	File     string `json:"file,omitempty"`     // 统计文件（JSON Lines），默认 ./traffic.jsonl
+	Interval int    `json:"interval,omitempty"` // 写入文件的间隔秒数，默认 60
+}
+
+// MaxConnsConfig 定义了服务端的并发连接数上限，为 0 表示不限制
+type MaxConnsConfig struct {
+	Global  int `json:"global,omitempty"`  // 服务端同时处理的连接数
+	PerUser int `json:"perUser,omitempty"` // 每个用户同时建立的连接数，只在配置了 users 时生效
+	PerIP   int `json:"perIP,omitempty"`   // 每个来源 IP 同时建立的连接数
+}
+
+// RateLimitConfig 定义了服务端的令牌桶限速。客户端身份是用户名，未配置 users 时为来源 IP
+type RateLimitConfig struct {
+	Client *BandwidthConfig `json:"client,omitempty"` // 同一客户端身份的所有连接共享的速率
+	Conn   *BandwidthConfig `json:"conn,omitempty"`   // 每条连接各自的速率
+}
+
+// BandwidthConfig 定义了上下行速率，单位为字节每秒，为 0 表示不限制
+type BandwidthConfig struct {
+	Up    int64 `json:"up,omitempty"`    // 上行（本地端到目标）速率
+	Down  int64 `json:"down,omitempty"`  // 下行（目标到本地端）速率
+	Burst int64 `json:"burst,omitempty"` // 令牌桶容量（字节），默认等于一秒的速率
+}
+
+// QuotaConfig 定义了每个客户端身份的流量配额，上下行合计，单位为字节，为 0 表示不限制
+type QuotaConfig struct {
+	Daily   int64  `json:"daily,omitempty"`   // 每日配额，本地时区零点重置
+	Monthly int64  `json:"monthly,omitempty"` // 每月配额，每月 1 日零点重置
+	File    string `json:"file,omitempty"`    // 用量持久化文件，默认 ./quota.json
+}
+
+// UserConfig 定义了多用户服务端中的一个用户，本地端将 password 配置为该用户的密码即可
+type UserConfig struct {
+	Name     string `json:"name"`               // 用户名，出现在服务端日志中
+	Password string `json:"password"`           // 该用户的密码，格式与 password 相同
+	Disabled bool   `json:"disabled,omitempty"` // 停用的用户不能建立连接
+}
+
+// FallbackConfig 定义了服务端如何应对主动探测
+type FallbackConfig struct {
+	Addr    string `json:"addr,omitempty"`    // 诱饵服务地址，握手失败的连接原样转交给它，为空时随机保持连接
+	MaxHold int    `json:"maxHold,omitempty"` // 保持连接的最长秒数，默认 60
+}
+
+// ClientsConfig 定义了服务端接受哪些来源 IP 的连接
+type ClientsConfig struct {
+	Allow []string   `json:"allow,omitempty"` // 允许的来源网段或 IP，为空表示不限制
+	Deny  []string   `json:"deny,omitempty"`  // 拒绝的来源网段或 IP，优先于 allow
+	Ban   *BanConfig `json:"ban,omitempty"`   // 握手失败封禁策略，为空表示不启用
+}
+
+// BanConfig 定义了握手失败的封禁策略
+type BanConfig struct {
+	MaxFailures int    `json:"maxFailures,omitempty"` // 时间窗口内允许的失败次数，默认 5
+	Window      int    `json:"window,omitempty"`      // 统计失败次数的时间窗口秒数，默认 600
+	Duration    int    `json:"duration,omitempty"`    // 封禁秒数，默认 3600
+	File        string `json:"file,omitempty"`        // 封禁列表持久化文件，默认 ./bans.json
+}
+
+// EgressConfig 定义了服务端允许连接的目标
+type EgressConfig struct {
+	Allow        []string `json:"allow,omitempty"`        // 放行的网段或 IP，可以覆盖默认禁止的内网地址段
+	Deny         []string `json:"deny,omitempty"`         // 禁止的网段或 IP，优先于 allow
+	AllowPorts   []string `json:"allowPorts,omitempty"`   // 允许的目标端口或端口范围，为空表示不限制
+	DenyPorts    []string `json:"denyPorts,omitempty"`    // 禁止的目标端口或端口范围
+	BlockDomains []string `json:"blockDomains,omitempty"` // 禁止访问的域名，格式同 geosite 域名列表
+}
+
+// DNSConfig 定义了本地 DNS 服务，查询默认经加密隧道发往上游解析器
+type DNSConfig struct {
+	Listen      string   `json:"listen,omitempty"`      // UDP/TCP 监听地址，为空表示不启用
+	Upstream    string   `json:"upstream,omitempty"`    // 经隧道访问的上游 DNS，默认 8.8.8.8:53
+	Rules       []string `json:"rules,omitempty"`       // 分流规则，例如 "domain-suffix:lan -> 192.168.1.1:53"
+	CacheSize   int      `json:"cacheSize,omitempty"`   // 缓存条目上限
+	NegativeTTL int      `json:"negativeTTL,omitempty"` // 没有 SOA 记录时的负缓存秒数
+
+	FakeIP *FakeIPConfig `json:"fakeIP,omitempty"` // Fake-IP 模式配置，为空表示不启用
+}
+
+// FakeIPConfig 定义了 Fake-IP 模式使用的保留地址段
+type FakeIPConfig struct {
+	Range  string `json:"range,omitempty"`  // IPv4 假地址网段，默认 198.18.0.0/15
+	Range6 string `json:"range6,omitempty"` // IPv6 假地址网段，为空时 AAAA 查询返回空应答
+	Size   int    `json:"size,omitempty"`   // 每个网段保留的映射上限，超出后淘汰最久未使用的映射
+}
+
+// PACConfig 定义了代理自动配置（PAC）文件的生成方式
+type PACConfig struct {
+	Listen  string   `json:"listen,omitempty"`  // PAC 文件 HTTP 服务监听地址，为空表示不提供服务
+	Host    string   `json:"host,omitempty"`    // 写入 PAC 文件的代理主机，为空时根据本地监听地址推导
+	Direct  []string `json:"direct,omitempty"`  // 直连的域名后缀、IPv4 地址或网段
+	Proxy   []string `json:"proxy,omitempty"`   // 走代理的域名后缀、IPv4 地址或网段
+	Default string   `json:"default,omitempty"` // 列表均未命中时的动作，proxy 或 direct，默认 proxy
+}
+
+// ServerConfig 定义了一个具名远程服务器
+type ServerConfig struct {
+	Name      string           `json:"name"`                // 服务器名称，在路由规则中作为动作使用
+	Remote    string           `json:"remote"`              // 远程服务地址
+	Password  string           `json:"password"`            // 连接该服务器使用的密码
+	TLS       *TLSConfig       `json:"tls,omitempty"`       // 连接该服务器使用的 TLS 传输配置
+	WebSocket *WebSocketConfig `json:"websocket,omitempty"` // 连接该服务器使用的 WebSocket 传输配置
+	HTTP2     *HTTP2Config     `json:"http2,omitempty"`     // 连接该服务器使用的 HTTP/2 传输配置
+	Obfs      *ObfsConfig      `json:"obfs,omitempty"`      // 连接该服务器使用的流量混淆配置
+	HTTPObfs  *HTTPObfsConfig  `json:"httpObfs,omitempty"`  // 连接该服务器使用的 HTTP 伪装配置
+
+	ForwardSecrecy bool `json:"forwardSecrecy,omitempty"` // 连接该服务器时进行临时密钥交换
+}
+
+// WebSocketConfig 定义了 WebSocket 传输层。本地端使用 url 与 host，服务端使用 path 与 realIPHeader
+type WebSocketConfig struct {
+	URL          string `json:"url,omitempty"`          // 本地端握手地址，例如 wss://cdn.example.com/ws，wss 表示在 TLS 之上传输
+	Host         string `json:"host,omitempty"`         // 本地端握手请求的 Host 头，为空时使用 url 中的主机
+	Path         string `json:"path,omitempty"`         // 服务端接受 WebSocket 连接的 HTTP 路径，默认 "/"
+	RealIPHeader string `json:"realIPHeader,omitempty"` // 服务端从该请求头读取客户端真实 IP，例如 CF-Connecting-IP
+}
+
+// HTTP2Config 定义了 HTTP/2 传输层。本地端使用 url 与 host，服务端使用 path 与 realIPHeader
+type HTTP2Config struct {
+	URL          string `json:"url,omitempty"`          // 本地端请求地址，例如 https://cdn.example.com/h2，http:// 表示明文的 h2c
+	Host         string `json:"host,omitempty"`         // 本地端请求的 :authority，为空时使用 url 中的主机
+	Path         string `json:"path,omitempty"`         // 服务端接受隧道请求的 HTTP 路径，默认 "/"
+	RealIPHeader string `json:"realIPHeader,omitempty"` // 服务端从该请求头读取客户端真实 IP，例如 CF-Connecting-IP
+}
+
+// TLSConfig 定义了 TLS 传输层。服务端的 cert/key 是服务器证书，配置 ca 后要求客户端证书；
+// 本地端的 cert/key 是双向认证使用的客户端证书，ca 用于校验服务器证书
+type TLSConfig struct {
+	Cert       string   `json:"cert,omitempty"`       // 本端证书文件（PEM）
+	Key        string   `json:"key,omitempty"`        // 本端私钥文件（PEM）
+	CA         string   `json:"ca,omitempty"`         // 校验对端证书的 CA 文件（PEM）
+	ServerName string   `json:"serverName,omitempty"` // 本地端发送的 SNI
+	Pins       []string `json:"pins,omitempty"`       // 本地端固定的服务器公钥 SHA-256 指纹，配置后允许自签名证书
+}
+
+// Options 转换为 core.TLSOptions
+func (c *TLSConfig) Options() *core.TLSOptions {
+	return &core.TLSOptions{
+		CertFile:   c.Cert,
+		KeyFile:    c.Key,
+		CAFile:     c.CA,
+		ServerName: c.ServerName,
+		Pins:       c.Pins,
+	}
+}
+
+// HTTPObfsConfig 定义了 HTTP 伪装，服务端只需配置一个空对象 {}
+type HTTPObfsConfig struct {
+	Host string `json:"host,omitempty"` // 本地端伪装请求的 Host 头，为空时使用服务端地址
+	Path string `json:"path,omitempty"` // 本地端伪装请求的路径，默认 "/"
+}
+
+// ObfsConfig 定义了流量混淆，两端需同时配置，各项参数只影响本端发送的数据
+type ObfsConfig struct {
+	PaddingRecords int `json:"paddingRecords,omitempty"` // 添加随机填充与时间抖动的前 N 个记录
+	MaxPadding     int `json:"maxPadding,omitempty"`     // 每个记录最多填充的字节数，默认 256，上限 4096
+	MaxRecord      int `json:"maxRecord,omitempty"`      // 单个记录的最大载荷，更长的写入按随机长度拆分，默认 16384
+	MergeDelay     int `json:"mergeDelay,omitempty"`     // 小块写入合并发送的最长等待毫秒数，为 0 时不合并
+	Jitter         int `json:"jitter,omitempty"`         // 前 N 个记录发送前随机等待的最长毫秒数
+}
+
+// Options 转换为 core.ObfsOptions 并校验参数范围
+func (c *ObfsConfig) Options() (*core.ObfsOptions, error) {
+	opts := &core.ObfsOptions{
+		PaddingRecords: c.PaddingRecords,
+		MaxPadding:     c.MaxPadding,
+		MaxRecord:      c.MaxRecord,
+		MergeDelay:     time.Duration(c.MergeDelay) * time.Millisecond,
+		Jitter:         time.Duration(c.Jitter) * time.Millisecond,
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	return opts, nil
 }
 
 var (
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// DisplayWidth 返回字符串在终端中的显示宽度，非 ASCII 字符按两列计算
+func DisplayWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		if r < utf8.RuneSelf {
+			width++
+		} else {
+			width += 2
+		}
+	}
+	return width
+}
+
+// PadRight 在 s 右侧补齐空格，使显示宽度达到 width
+func PadRight(s string, width int) string {
+	return s + strings.Repeat(" ", max(0, width-DisplayWidth(s)))
+}
+
+// PadLeft 在 s 左侧补齐空格，使显示宽度达到 width
+func PadLeft(s string, width int) string {
+	return strings.Repeat(" ", max(0, width-DisplayWidth(s))) + s
+}
+
+// FormatBytes 以二进制单位格式化字节数
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value, exp := float64(n)/unit, 0
+	for value >= unit && exp < 4 {
+		value /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGTP"[exp])
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/beijian128/minisocks/logging"
+)
+
+// defaultLogMaxSize 是未配置 maxSize 时日志文件轮转的大小（MB），配置为 0 时不轮转
+const defaultLogMaxSize = 100
+
+// LogFlags 是两端共用的日志命令行参数，不为空时覆盖配置文件中的对应项
+type LogFlags struct {
+	Level      string
+	Format     string
+	File       string
+	Components string
+}
+
+// AddLogFlags 在 fs 上注册日志参数
+func AddLogFlags(fs *flag.FlagSet) *LogFlags {
+	f := &LogFlags{}
+	fs.StringVar(&f.Level, "log-level", "", "日志级别：trace、debug、info、warn、error")
+	fs.StringVar(&f.Format, "log-format", "", "日志格式：text 或 json")
+	fs.StringVar(&f.File, "log-file", "", "日志文件，按 log.maxSize 轮转")
+	fs.StringVar(&f.Components, "log-components", "", "按组件覆盖级别，例如 DNS=debug,LsServer=warn")
+	return f
+}
+
+// Options 合并配置文件中的日志配置与命令行参数，config 可以为 nil
+func (f *LogFlags) Options(config *LogConfig) (logging.Options, error) {
+	if config == nil {
+		config = &LogConfig{}
+	}
+	opts := logging.Options{
+		Level:      config.Level,
+		Format:     config.Format,
+		File:       config.File,
+		MaxSize:    defaultLogMaxSize << 20,
+		MaxBackups: config.MaxBackups,
+		MaxAge:     config.MaxAge,
+		Components: maps.Clone(config.Components),
+	}
+	if config.MaxSize != nil {
+		opts.MaxSize = int64(max(*config.MaxSize, 0)) << 20
+	}
+	if f.Level != "" {
+		opts.Level = f.Level
+	}
+	if f.Format != "" {
+		opts.Format = f.Format
+	}
+	if f.File != "" {
+		opts.File = f.File
+	}
+	if f.Components != "" {
+		if opts.Components == nil {
+			opts.Components = make(map[string]string)
+		}
+		for _, item := range strings.Split(f.Components, ",") {
+			name, level, ok := strings.Cut(strings.TrimSpace(item), "=")
+			if !ok || name == "" {
+				return opts, fmt.Errorf("无效的组件日志级别 %q，格式为 组件=级别", item)
+			}
+			opts.Components[name] = level
+		}
+	}
+	return opts, nil
+}
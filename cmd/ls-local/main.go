@@ -1,10 +1,23 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
+	"fmt"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/beijian128/minisocks/cmd"
+	"github.com/beijian128/minisocks/core"
+	"github.com/beijian128/minisocks/dns"
 	"github.com/beijian128/minisocks/local"
+	"github.com/beijian128/minisocks/logging"
+	"github.com/beijian128/minisocks/pac"
+	"github.com/beijian128/minisocks/route"
 	"github.com/sirupsen/logrus"
 )
 
@@ -15,20 +28,224 @@ var (
 	logger  = logrus.WithField("component", "minisocks-client")
 )
 
+// defaultDNSUpstream 是未配置上游时经隧道访问的 DNS 服务器
+const defaultDNSUpstream = "8.8.8.8:53"
+
 func init() {
-	// 配置日志格式
+	// 启动阶段的默认日志格式，加载配置后由 logging.Setup 按配置覆盖
 	logrus.SetFormatter(&logrus.TextFormatter{
 		FullTimestamp: true,
 	})
 }
 
+// reloadOnSignal 收到 SIGHUP 信号时重新读取配置文件中的路由规则、PAC 列表与 DNS 分流规则
+func reloadOnSignal(lsLocal *local.LsLocal, pacServer *pac.Server, dnsServer *dns.Server) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+	for range sigCh {
+		logger.Info("收到 SIGHUP，重新加载路由规则")
+		config, err := cmd.LoadConfig()
+		if err != nil {
+			logger.WithError(err).Error("重新加载配置失败")
+			continue
+		}
+		geo, err := route.LoadGeo(config.GeoIP, config.Geosite)
+		if err != nil {
+			logger.WithError(err).Error("重新加载地理数据失败")
+			continue
+		}
+		if err := lsLocal.SetRules(config.Rules, geo); err != nil {
+			logger.WithError(err).Error("重新加载路由规则失败，继续使用原有规则")
+		}
+		if dnsServer != nil && config.DNS != nil {
+			if err := dnsServer.SetRules(config.DNS.Rules, geo); err != nil {
+				logger.WithError(err).Error("重新加载 DNS 分流规则失败，继续使用原有规则")
+			}
+		}
+		if pacServer != nil {
+			content, err := generatePAC(config)
+			if err != nil {
+				logger.WithError(err).Error("重新生成 PAC 文件失败，继续使用原有内容")
+				continue
+			}
+			pacServer.SetContent(content)
+		}
+	}
+}
+
+// runMatch 实现 match 子命令：查看目标地址会命中哪条路由规则
+func runMatch(args []string) {
+	fs := flag.NewFlagSet("match", flag.ExitOnError)
+	port := fs.Int("port", 443, "目标端口")
+	src := fs.String("src", "", "模拟的客户端来源 IP")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: minisocks-local match [-port 端口] [-src 来源IP] <域名或IP>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	config, err := cmd.LoadConfig()
+	if err != nil {
+		logger.WithError(err).Fatal("加载配置失败")
+	}
+	geo, err := route.LoadGeo(config.GeoIP, config.Geosite)
+	if err != nil {
+		logger.WithError(err).Fatal("加载地理数据失败")
+	}
+	router, err := route.New(config.Rules, geo)
+	if err != nil {
+		logger.WithError(err).Fatal("加载路由规则失败")
+	}
+
+	meta := &route.Metadata{DstPort: *port, SrcIP: net.ParseIP(*src)}
+	if ip := net.ParseIP(fs.Arg(0)); ip != nil {
+		meta.DstIP = ip
+	} else {
+		meta.Host = fs.Arg(0)
+	}
+
+	fmt.Printf("目标: %s\n", meta.Target())
+	if meta.DstIP != nil && geo.IPDB != nil {
+		country, err := geo.IPDB.Country(meta.DstIP)
+		if err != nil {
+			fmt.Printf("国家: 查询失败 (%v)\n", err)
+		} else {
+			fmt.Printf("国家: %s\n", country)
+		}
+	}
+	rule, action := router.Match(meta)
+	if rule != nil {
+		fmt.Printf("规则: %s\n", rule.Raw)
+	} else {
+		fmt.Println("规则: 无规则命中，使用默认动作")
+	}
+	fmt.Printf("动作: %s\n", action)
+}
+
+// generatePAC 根据配置生成 PAC 文件内容
+func generatePAC(config *cmd.Config) ([]byte, error) {
+	pacConfig := config.PAC
+	if pacConfig == nil {
+		pacConfig = &cmd.PACConfig{}
+	}
+	proxyAddr, err := pac.ProxyAddr(config.ListenAddr, pacConfig.Host)
+	if err != nil {
+		return nil, err
+	}
+	return pac.Generate(pac.Options{
+		ProxyAddr: proxyAddr,
+		Direct:    pacConfig.Direct,
+		Proxy:     pacConfig.Proxy,
+		Default:   pacConfig.Default,
+	})
+}
+
+// runPAC 实现 pac 子命令：将 PAC 文件写入磁盘
+func runPAC(args []string) {
+	fs := flag.NewFlagSet("pac", flag.ExitOnError)
+	output := fs.String("o", "proxy.pac", "PAC 文件输出路径，\"-\" 表示输出到标准输出")
+	fs.Parse(args)
+
+	config, err := cmd.LoadConfig()
+	if err != nil {
+		logger.WithError(err).Fatal("加载配置失败")
+	}
+	content, err := generatePAC(config)
+	if err != nil {
+		logger.WithError(err).Fatal("生成 PAC 文件失败")
+	}
+
+	if *output == "-" {
+		os.Stdout.Write(content)
+		return
+	}
+	if err := os.WriteFile(*output, content, 0644); err != nil {
+		logger.WithError(err).Fatal("写入 PAC 文件失败")
+	}
+	logger.WithField("path", *output).Info("PAC 文件已生成")
+}
+
+// runStatus 实现 status 子命令：通过状态接口查看正在转发的连接
+func runStatus(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	addr := fs.String("addr", "", "状态接口地址，默认使用配置文件中的 status.listen")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: minisocks-local status [-addr 地址]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *addr == "" {
+		config, err := cmd.LoadConfig()
+		if err != nil {
+			logger.WithError(err).Fatal("加载配置失败")
+		}
+		if config.Status == nil || config.Status.Listen == "" {
+			logger.Fatal("配置文件中没有配置 status.listen")
+		}
+		*addr = config.Status.Listen
+	}
+
+	client := &http.Client{Timeout: core.TIMEOUT}
+	resp, err := client.Get("http://" + *addr + "/api/conns")
+	if err != nil {
+		logger.WithError(err).Fatal("访问状态接口失败，客户端是否正在运行？")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		logger.WithField("status", resp.Status).Fatal("状态接口返回错误")
+	}
+	var conns []core.ConnInfo
+	if err := json.NewDecoder(resp.Body).Decode(&conns); err != nil {
+		logger.WithError(err).Fatal("解析状态接口响应失败")
+	}
+
+	if len(conns) == 0 {
+		fmt.Println("没有正在转发的连接")
+		return
+	}
+	sourceWidth, targetWidth, routeWidth := cmd.DisplayWidth("来源"), cmd.DisplayWidth("目标"), cmd.DisplayWidth("路由")
+	for _, c := range conns {
+		sourceWidth = max(sourceWidth, len(c.Source))
+		targetWidth = max(targetWidth, len(c.Target))
+		routeWidth = max(routeWidth, len(c.Route))
+	}
+	fmt.Printf("%s  %s  %s  %s  %s  %s  %s\n", cmd.PadRight("连接", 8), cmd.PadRight("来源", sourceWidth),
+		cmd.PadRight("目标", targetWidth), cmd.PadRight("路由", routeWidth),
+		cmd.PadLeft("上行", 10), cmd.PadLeft("下行", 10), cmd.PadLeft("时长", 8))
+	var up, down int64
+	for _, c := range conns {
+		duration := time.Duration(c.Duration * float64(time.Second)).Round(time.Second)
+		fmt.Printf("%-8.8s  %-*s  %-*s  %-*s  %10s  %10s  %8s\n", c.ID, sourceWidth, c.Source, targetWidth, c.Target,
+			routeWidth, c.Route, cmd.FormatBytes(c.Up), cmd.FormatBytes(c.Down), duration)
+		up += c.Up
+		down += c.Down
+	}
+	fmt.Printf("共 %d 条连接，上行 %s，下行 %s\n", len(conns), cmd.FormatBytes(up), cmd.FormatBytes(down))
+}
+
 func main() {
-	// 打印版本信息
-	logger.WithFields(logrus.Fields{
-		"version": version,
-		"commit":  commit,
-		"date":    date,
-	}).Info("启动 minisocks 客户端")
+	// 子命令
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "match":
+			runMatch(os.Args[2:])
+			return
+		case "pac":
+			runPAC(os.Args[2:])
+			return
+		case "status":
+			runStatus(os.Args[2:])
+			return
+		}
+	}
+
+	logFlags := cmd.AddLogFlags(flag.CommandLine)
+	flag.Parse()
 
 	// 加载配置
 	config, err := cmd.LoadConfig()
@@ -36,6 +253,22 @@ func main() {
 		logger.WithError(err).Fatal("加载配置失败")
 	}
 
+	// 按配置与命令行参数设置日志，此前的日志使用 init 中的默认设置
+	logOptions, err := logFlags.Options(config.Log)
+	if err != nil {
+		logger.WithError(err).Fatal("解析日志参数失败")
+	}
+	if err := logging.Setup(logOptions); err != nil {
+		logger.WithError(err).Fatal("设置日志失败")
+	}
+
+	// 打印版本信息
+	logger.WithFields(logrus.Fields{
+		"version": version,
+		"commit":  commit,
+		"date":    date,
+	}).Info("启动 minisocks 客户端")
+
 	// 解析本地监听地址
 	localAddr, err := net.ResolveTCPAddr("tcp", config.ListenAddr)
 	if err != nil {
@@ -56,6 +289,147 @@ func main() {
 
 	// 创建本地代理实例
 	lsLocal := local.New(config.Password, localAddr, serverAddr)
+
+	if config.TLS != nil {
+		if lsLocal.TLSConfig, err = config.TLS.Options().ClientConfig(); err != nil {
+			logger.WithError(err).Fatal("加载 TLS 配置失败")
+		}
+	}
+	if ws := config.WebSocket; ws != nil {
+		lsLocal.WebSocket = &core.WebSocketOptions{URL: ws.URL, Host: ws.Host}
+		if _, err := lsLocal.WebSocket.Location(); err != nil {
+			logger.WithError(err).Fatal("解析 WebSocket 配置失败")
+		}
+	}
+	if h2 := config.HTTP2; h2 != nil {
+		lsLocal.HTTP2 = &core.HTTP2Options{URL: h2.URL, Host: h2.Host}
+		if _, err := lsLocal.HTTP2.Location(); err != nil {
+			logger.WithError(err).Fatal("解析 HTTP/2 配置失败")
+		}
+	}
+	if config.Obfs != nil {
+		if lsLocal.Obfs, err = config.Obfs.Options(); err != nil {
+			logger.WithError(err).Fatal("解析混淆配置失败")
+		}
+	}
+	if ho := config.HTTPObfs; ho != nil {
+		lsLocal.HTTPObfs = &core.HTTPObfsOptions{Host: ho.Host, Path: ho.Path}
+	}
+	if config.ForwardSecrecy {
+		lsLocal.KeyExchange = core.NewKeyExchange(config.Password)
+	}
+
+	// 注册具名服务器并加载路由规则
+	for _, sc := range config.Servers {
+		addr, err := net.ResolveTCPAddr("tcp", sc.Remote)
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"name":   sc.Name,
+				"remote": sc.Remote,
+				"error":  err,
+			}).Fatal("解析具名服务器地址失败")
+		}
+		ss := lsLocal.AddServer(sc.Name, sc.Password, addr)
+		if sc.TLS != nil {
+			if ss.TLSConfig, err = sc.TLS.Options().ClientConfig(); err != nil {
+				logger.WithError(err).WithField("name", sc.Name).Fatal("加载具名服务器 TLS 配置失败")
+			}
+		}
+		if sc.WebSocket != nil {
+			ss.WebSocket = &core.WebSocketOptions{URL: sc.WebSocket.URL, Host: sc.WebSocket.Host}
+			if _, err := ss.WebSocket.Location(); err != nil {
+				logger.WithError(err).WithField("name", sc.Name).Fatal("解析具名服务器 WebSocket 配置失败")
+			}
+		}
+		if sc.HTTP2 != nil {
+			ss.HTTP2 = &core.HTTP2Options{URL: sc.HTTP2.URL, Host: sc.HTTP2.Host}
+			if _, err := ss.HTTP2.Location(); err != nil {
+				logger.WithError(err).WithField("name", sc.Name).Fatal("解析具名服务器 HTTP/2 配置失败")
+			}
+		}
+		if sc.Obfs != nil {
+			if ss.Obfs, err = sc.Obfs.Options(); err != nil {
+				logger.WithError(err).WithField("name", sc.Name).Fatal("解析具名服务器混淆配置失败")
+			}
+		}
+		if ho := sc.HTTPObfs; ho != nil {
+			ss.HTTPObfs = &core.HTTPObfsOptions{Host: ho.Host, Path: ho.Path}
+		}
+		if sc.ForwardSecrecy {
+			ss.KeyExchange = core.NewKeyExchange(sc.Password)
+		}
+	}
+	geo, err := route.LoadGeo(config.GeoIP, config.Geosite)
+	if err != nil {
+		logger.WithError(err).Fatal("加载地理数据失败")
+	}
+	if err := lsLocal.SetRules(config.Rules, geo); err != nil {
+		logger.WithError(err).Fatal("加载路由规则失败")
+	}
+
+	// 启动 PAC 文件服务
+	var pacServer *pac.Server
+	if config.PAC != nil && config.PAC.Listen != "" {
+		content, err := generatePAC(config)
+		if err != nil {
+			logger.WithError(err).Fatal("生成 PAC 文件失败")
+		}
+		pacServer = pac.NewServer(content)
+		go func() {
+			if err := pacServer.ListenAndServe(config.PAC.Listen); err != nil {
+				logger.WithError(err).Error("PAC 服务运行失败")
+			}
+		}()
+	}
+
+	// 启动本地 DNS 服务
+	var dnsServer *dns.Server
+	if config.DNS != nil && config.DNS.Listen != "" {
+		upstream := config.DNS.Upstream
+		if upstream == "" {
+			upstream = defaultDNSUpstream
+		}
+		cache := dns.NewCache(config.DNS.CacheSize, time.Duration(config.DNS.NegativeTTL)*time.Second)
+		dnsServer = dns.NewServer(config.DNS.Listen, &dns.TCPUpstream{Addr: upstream, Dial: lsLocal.DialProxy}, cache)
+		dnsServer.OnExchange = lsLocal.ObserveDNS
+		if err := dnsServer.SetRules(config.DNS.Rules, geo); err != nil {
+			logger.WithError(err).Fatal("加载 DNS 分流规则失败")
+		}
+		if fc := config.DNS.FakeIP; fc != nil {
+			fakeIP, err := dns.NewFakeIP(fc.Range, fc.Range6, fc.Size)
+			if err != nil {
+				logger.WithError(err).Fatal("创建 Fake-IP 地址池失败")
+			}
+			dnsServer.FakeIP = fakeIP
+			lsLocal.FakeIP = fakeIP
+		}
+		go func() {
+			if err := dnsServer.ListenAndServe(); err != nil {
+				logger.WithError(err).Error("DNS 服务运行失败")
+			}
+		}()
+	}
+	go reloadOnSignal(lsLocal, pacServer, dnsServer)
+
+	// 启动状态接口
+	if config.Status != nil && config.Status.Listen != "" {
+		status := local.NewStatus(lsLocal)
+		go func() {
+			if err := status.ListenAndServe(config.Status.Listen); err != nil {
+				logger.WithError(err).Fatal("状态接口运行失败")
+			}
+		}()
+	}
+
+	// 启动指标服务
+	if config.Metrics != nil && config.Metrics.Listen != "" {
+		go func() {
+			if err := lsLocal.Metrics().ListenAndServe(config.Metrics.Listen); err != nil {
+				logger.WithError(err).Fatal("指标服务运行失败")
+			}
+		}()
+	}
+
 	lsLocal.AfterListen = func(listenAddr net.Addr) {
 		logger.WithFields(logrus.Fields{
 			"listenAddr": listenAddr.String(),
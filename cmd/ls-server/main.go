@@ -1,9 +1,21 @@
 package main
 
 import (
+	"encoding/csv"
+	"flag"
+	"fmt"
 	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/beijian128/minisocks/cmd"
+	"github.com/beijian128/minisocks/core"
+	"github.com/beijian128/minisocks/dns"
+	"github.com/beijian128/minisocks/logging"
 	"github.com/beijian128/minisocks/server"
 	"github.com/sirupsen/logrus"
 )
@@ -16,19 +28,316 @@ var (
 )
 
 func init() {
-	// 设置日志格式
+	// 启动阶段的默认日志格式，加载配置后由 logging.Setup 按配置覆盖
 	logrus.SetFormatter(&logrus.TextFormatter{
 		FullTimestamp: true,
 	})
 }
 
+// defaultBanFile 是封禁列表的默认持久化文件
+const defaultBanFile = "./bans.json"
+
+// banOptions 将配置转换为封禁策略
+func banOptions(ban *cmd.BanConfig) server.BanOptions {
+	file := ban.File
+	if file == "" {
+		file = defaultBanFile
+	}
+	return server.BanOptions{
+		MaxFailures: ban.MaxFailures,
+		Window:      time.Duration(ban.Window) * time.Second,
+		Duration:    time.Duration(ban.Duration) * time.Second,
+		Path:        file,
+	}
+}
+
+// defaultQuotaFile 是流量用量的默认持久化文件
+const defaultQuotaFile = "./quota.json"
+
+// quotaSaveInterval 是保存流量用量的间隔
+const quotaSaveInterval = time.Minute
+
+// bandwidth 将配置转换为限速参数，未配置时不限速
+func bandwidth(c *cmd.BandwidthConfig) server.Bandwidth {
+	if c == nil {
+		return server.Bandwidth{}
+	}
+	return server.Bandwidth{Up: c.Up, Down: c.Down, Burst: c.Burst}
+}
+
+// saveQuotas 定期保存流量用量
+func saveQuotas(quotas *server.Quotas) {
+	for range time.Tick(quotaSaveInterval) {
+		if err := quotas.Save(); err != nil {
+			logger.WithError(err).Error("保存流量用量失败")
+		}
+	}
+}
+
+// defaultTrafficFile 是流量统计的默认持久化文件
+const defaultTrafficFile = "./traffic.jsonl"
+
+// trafficFile 返回配置的流量统计文件
+func trafficFile(config *cmd.Config) string {
+	if config.Accounting != nil && config.Accounting.File != "" {
+		return config.Accounting.File
+	}
+	return defaultTrafficFile
+}
+
+// flushAccounting 定期将流量统计写入文件
+func flushAccounting(accounting *server.Accounting, interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := accounting.Flush(); err != nil {
+			logger.WithError(err).Error("写入流量统计失败")
+		}
+	}
+}
+
+// reloadOnSignal 收到 SIGHUP 信号时重新读取封禁列表
+func reloadOnSignal(bans *server.BanList) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+	for range sigCh {
+		logger.Info("收到 SIGHUP，重新加载封禁列表")
+		if err := bans.Reload(); err != nil {
+			logger.WithError(err).Error("重新加载封禁列表失败")
+		}
+	}
+}
+
+// saveOnExit 收到 SIGINT 或 SIGTERM 时写入尚未保存的流量统计与配额用量后退出，避免重启丢失最近一个周期的数据
+func saveOnExit(lsServer *server.LsServer) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-sigCh
+	logger.WithField("signal", sig).Info("收到退出信号，保存数据后退出")
+	if lsServer.Accounting != nil {
+		if err := lsServer.Accounting.Flush(); err != nil {
+			logger.WithError(err).Error("写入流量统计失败")
+		}
+	}
+	if lsServer.Quotas != nil {
+		if err := lsServer.Quotas.Save(); err != nil {
+			logger.WithError(err).Error("保存流量用量失败")
+		}
+	}
+	os.Exit(0)
+}
+
+// runBans 实现 bans 子命令：查看或解除封禁，修改后向运行中的服务端发送 SIGHUP 生效
+func runBans(args []string) {
+	fs := flag.NewFlagSet("bans", flag.ExitOnError)
+	unban := fs.String("unban", "", "解除封禁的 IP")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: minisocks-server bans [-unban IP]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	config, err := cmd.LoadConfig()
+	if err != nil {
+		logger.WithError(err).Fatal("加载配置失败")
+	}
+	banConfig := &cmd.BanConfig{}
+	if config.Clients != nil && config.Clients.Ban != nil {
+		banConfig = config.Clients.Ban
+	}
+	bans, err := server.NewBanList(banOptions(banConfig))
+	if err != nil {
+		logger.WithError(err).Fatal("加载封禁列表失败")
+	}
+
+	if *unban != "" {
+		ok, err := bans.Unban(*unban)
+		if err != nil {
+			logger.WithError(err).Fatal("解除封禁失败")
+		}
+		if !ok {
+			fmt.Printf("%s 不在封禁列表中\n", *unban)
+			os.Exit(1)
+		}
+		fmt.Printf("已解除对 %s 的封禁，向服务端发送 SIGHUP 后生效\n", *unban)
+		return
+	}
+
+	list := bans.List()
+	if len(list) == 0 {
+		fmt.Println("没有被封禁的 IP")
+		return
+	}
+	for _, ban := range list {
+		fmt.Printf("%-40s 封禁至 %s\n", ban.IP, ban.Until.Local().Format(time.DateTime))
+	}
+}
+
+// newUsers 根据配置创建用户表
+func newUsers(configs []cmd.UserConfig) (*server.Users, error) {
+	users, err := server.NewUsers()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range configs {
+		user, err := server.NewUser(c.Name, c.Password)
+		if err != nil {
+			return nil, err
+		}
+		user.SetDisabled(c.Disabled)
+		if err := users.Add(user); err != nil {
+			return nil, err
+		}
+	}
+	return users, nil
+}
+
+// userConfigs 将用户表转换为配置
+func userConfigs(users []*server.User) []cmd.UserConfig {
+	configs := make([]cmd.UserConfig, len(users))
+	for i, user := range users {
+		configs[i] = cmd.UserConfig{Name: user.Name, Password: user.Password(), Disabled: user.Disabled()}
+	}
+	return configs
+}
+
+// runUsers 实现 users 子命令：列出用户，或生成新用户的密码并写入配置文件，重启服务端后生效
+func runUsers(args []string) {
+	fs := flag.NewFlagSet("users", flag.ExitOnError)
+	add := fs.String("add", "", "添加的用户名，自动生成密码")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: minisocks-server users [-add NAME]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	config, err := cmd.LoadConfig()
+	if err != nil {
+		logger.WithError(err).Fatal("加载配置失败")
+	}
+
+	if *add != "" {
+		configs := append(config.Users, cmd.UserConfig{Name: *add, Password: core.GenerateCipherTable()})
+		if _, err := newUsers(configs); err != nil {
+			logger.WithError(err).Fatal("添加用户失败")
+		}
+		config.Users = configs
+		if err := config.Save(); err != nil {
+			logger.WithError(err).Fatal("保存配置失败")
+		}
+		fmt.Printf("已添加用户 %s，重启服务端后生效。该用户的本地端密码:\n%s\n", *add, configs[len(configs)-1].Password)
+		return
+	}
+
+	if len(config.Users) == 0 {
+		fmt.Println("没有配置用户，所有连接使用 password")
+		return
+	}
+	for _, user := range config.Users {
+		fmt.Println(user.Name)
+	}
+}
+
+// runTraffic 实现 traffic 子命令：按日期范围与客户端身份查询流量统计
+func runTraffic(args []string) {
+	fs := flag.NewFlagSet("traffic", flag.ExitOnError)
+	from := fs.String("from", "", "起始日期（含），例如 2024-05-01")
+	to := fs.String("to", "", "结束日期（含），例如 2024-05-31")
+	identity := fs.String("identity", "", "只查询该客户端身份（用户名或来源 IP）")
+	total := fs.Bool("total", false, "按客户端身份汇总日期范围内的流量")
+	asCSV := fs.Bool("csv", false, "以 CSV 格式输出，字节数不做单位换算")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: minisocks-server traffic [-from DATE] [-to DATE] [-identity NAME] [-total] [-csv]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	for _, date := range []string{*from, *to} {
+		if _, err := time.Parse(time.DateOnly, date); date != "" && err != nil {
+			logger.WithField("date", date).Fatal("日期格式应为 YYYY-MM-DD")
+		}
+	}
+	config, err := cmd.LoadConfig()
+	if err != nil {
+		logger.WithError(err).Fatal("加载配置失败")
+	}
+	records, err := server.QueryTraffic(trafficFile(config), server.TrafficFilter{From: *from, To: *to, Identity: *identity})
+	if err != nil {
+		logger.WithError(err).Fatal("查询流量统计失败")
+	}
+	if *total {
+		records = sumTraffic(records)
+	}
+
+	if *asCSV {
+		w := csv.NewWriter(os.Stdout)
+		w.Write([]string{"date", "identity", "up", "down", "conns"})
+		for _, r := range records {
+			w.Write([]string{r.Date, r.Identity, strconv.FormatInt(r.Up, 10), strconv.FormatInt(r.Down, 10), strconv.FormatInt(r.Conns, 10)})
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			logger.WithError(err).Fatal("输出 CSV 失败")
+		}
+		return
+	}
+
+	if len(records) == 0 {
+		fmt.Println("没有流量记录")
+		return
+	}
+	dateWidth := 0
+	for _, r := range records {
+		dateWidth = max(dateWidth, len(r.Date))
+	}
+	fmt.Printf("%s  %s  %s  %s  %s\n", cmd.PadRight("日期", dateWidth), cmd.PadRight("客户端", 40),
+		cmd.PadLeft("上行", 12), cmd.PadLeft("下行", 12), cmd.PadLeft("连接数", 8))
+	for _, r := range records {
+		fmt.Printf("%-*s  %-40s  %12s  %12s  %8d\n", dateWidth, r.Date, r.Identity, cmd.FormatBytes(r.Up), cmd.FormatBytes(r.Down), r.Conns)
+	}
+}
+
+// sumTraffic 按客户端身份汇总记录，日期一列填写记录的日期范围
+func sumTraffic(records []server.TrafficRecord) []server.TrafficRecord {
+	var sums []server.TrafficRecord
+	index := make(map[string]int)
+	first, last := make(map[string]string), make(map[string]string)
+	for _, r := range records {
+		i, ok := index[r.Identity]
+		if !ok {
+			i = len(sums)
+			index[r.Identity] = i
+			sums = append(sums, server.TrafficRecord{Identity: r.Identity})
+			first[r.Identity] = r.Date
+		}
+		last[r.Identity] = r.Date
+		sums[i].Up += r.Up
+		sums[i].Down += r.Down
+		sums[i].Conns += r.Conns
+	}
+	for i := range sums {
+		sums[i].Date = first[sums[i].Identity] + "~" + last[sums[i].Identity]
+	}
+	sort.Slice(sums, func(i, j int) bool { return sums[i].Identity < sums[j].Identity })
+	return sums
+}
+
 func main() {
-	// 打印版本信息
-	logger.WithFields(logrus.Fields{
-		"version": version,
-		"commit":  commit,
-		"date":    date,
-	}).Info("启动 minisocks 服务端")
+	// 子命令
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "bans":
+			runBans(os.Args[2:])
+			return
+		case "users":
+			runUsers(os.Args[2:])
+			return
+		case "traffic":
+			runTraffic(os.Args[2:])
+			return
+		}
+	}
+
+	logFlags := cmd.AddLogFlags(flag.CommandLine)
+	flag.Parse()
 
 	// 加载配置
 	config, err := cmd.LoadConfig()
@@ -36,6 +345,22 @@ func main() {
 		logger.WithError(err).Fatal("加载配置失败")
 	}
 
+	// 按配置与命令行参数设置日志，此前的日志使用 init 中的默认设置
+	logOptions, err := logFlags.Options(config.Log)
+	if err != nil {
+		logger.WithError(err).Fatal("解析日志参数失败")
+	}
+	if err := logging.Setup(logOptions); err != nil {
+		logger.WithError(err).Fatal("设置日志失败")
+	}
+
+	// 打印版本信息
+	logger.WithFields(logrus.Fields{
+		"version": version,
+		"commit":  commit,
+		"date":    date,
+	}).Info("启动 minisocks 服务端")
+
 	// 解析监听地址
 	localAddr, err := net.ResolveTCPAddr("tcp", config.ListenAddr)
 	if err != nil {
@@ -47,12 +372,152 @@ func main() {
 
 	// 创建服务器实例
 	lsServer := server.New(config.Password, localAddr)
-	lsServer.AfterListen = func(listenAddr net.Addr) {
+	resolver, err := dns.NewResolver(config.Resolver)
+	if err != nil {
 		logger.WithFields(logrus.Fields{
-			"listenAddr": listenAddr.String(),
-			"password":   config.Password,
-		}).Info("服务启动成功")
+			"resolver": config.Resolver,
+			"error":    err,
+		}).Fatal("创建域名解析器失败")
+	}
+	lsServer.Resolver = resolver
+	if lsServer.IPStrategy, err = server.ParseIPStrategy(config.IPStrategy); err != nil {
+		logger.WithError(err).Fatal("解析地址族策略失败")
+	}
+	if egress := config.Egress; egress != nil {
+		lsServer.Egress, err = server.NewEgressPolicy(server.EgressOptions{
+			Allow:        egress.Allow,
+			Deny:         egress.Deny,
+			AllowPorts:   egress.AllowPorts,
+			DenyPorts:    egress.DenyPorts,
+			BlockDomains: egress.BlockDomains,
+		})
+		if err != nil {
+			logger.WithError(err).Fatal("解析出站策略失败")
+		}
+	}
+	if clients := config.Clients; clients != nil {
+		if lsServer.Clients, err = server.NewClientACL(clients.Allow, clients.Deny); err != nil {
+			logger.WithError(err).Fatal("解析来源 IP 过滤列表失败")
+		}
+		if clients.Ban != nil {
+			if lsServer.Bans, err = server.NewBanList(banOptions(clients.Ban)); err != nil {
+				logger.WithError(err).Fatal("加载封禁列表失败")
+			}
+			go reloadOnSignal(lsServer.Bans)
+		}
+	}
+	if fallback := config.Fallback; fallback != nil {
+		lsServer.Fallback = &server.Fallback{
+			Addr:    fallback.Addr,
+			MaxHold: time.Duration(fallback.MaxHold) * time.Second,
+		}
+	}
+	if config.TLS != nil {
+		if lsServer.TLSConfig, err = config.TLS.Options().ServerConfig(); err != nil {
+			logger.WithError(err).Fatal("加载 TLS 配置失败")
+		}
+		pins, err := core.CertificatePins(lsServer.TLSConfig)
+		if err != nil {
+			logger.WithError(err).Fatal("计算证书公钥指纹失败")
+		}
+		logger.WithField("pins", pins).Info("已启用 TLS 传输，本地端可使用 pins 固定服务器公钥")
 	}
+	if ws := config.WebSocket; ws != nil {
+		lsServer.WebSocket = &server.WebSocketOptions{Path: ws.Path, RealIPHeader: ws.RealIPHeader}
+	}
+	if h2 := config.HTTP2; h2 != nil {
+		lsServer.HTTP2 = &server.HTTP2Options{Path: h2.Path, RealIPHeader: h2.RealIPHeader}
+	}
+	if config.Obfs != nil {
+		if lsServer.Obfs, err = config.Obfs.Options(); err != nil {
+			logger.WithError(err).Fatal("解析混淆配置失败")
+		}
+	}
+	if config.HTTPObfs != nil {
+		if lsServer.WebSocket != nil || lsServer.HTTP2 != nil {
+			logger.Fatal("HTTP 伪装不能与 WebSocket 或 HTTP/2 传输同时使用")
+		}
+		lsServer.HTTPObfs = &core.HTTPObfsOptions{}
+	}
+	if config.ForwardSecrecy {
+		lsServer.KeyExchange = core.NewKeyExchange(config.Password)
+	}
+	if len(config.Users) > 0 {
+		if lsServer.Users, err = newUsers(config.Users); err != nil {
+			logger.WithError(err).Fatal("加载用户列表失败")
+		}
+	}
+	if limit := config.RateLimit; limit != nil {
+		lsServer.RateLimits = server.NewRateLimits(server.RateLimitOptions{
+			Client: bandwidth(limit.Client),
+			Conn:   bandwidth(limit.Conn),
+		})
+	}
+	if quota := config.Quota; quota != nil {
+		file := quota.File
+		if file == "" {
+			file = defaultQuotaFile
+		}
+		lsServer.Quotas, err = server.NewQuotas(server.QuotaOptions{
+			Daily:   quota.Daily,
+			Monthly: quota.Monthly,
+			Path:    file,
+		})
+		if err != nil {
+			logger.WithError(err).Fatal("加载流量用量失败")
+		}
+		go saveQuotas(lsServer.Quotas)
+	}
+	if accounting := config.Accounting; accounting != nil {
+		if lsServer.Accounting, err = server.NewAccounting(trafficFile(config)); err != nil {
+			logger.WithError(err).Fatal("加载流量统计失败")
+		}
+		interval := time.Duration(accounting.Interval) * time.Second
+		if interval <= 0 {
+			interval = time.Minute
+		}
+		go flushAccounting(lsServer.Accounting, interval)
+	}
+	if maxConns := config.MaxConns; maxConns != nil {
+		lsServer.ConnLimits = server.NewConnLimits(server.ConnLimitOptions{
+			Global:  maxConns.Global,
+			PerUser: maxConns.PerUser,
+			PerIP:   maxConns.PerIP,
+		})
+	}
+	if adminConfig := config.Admin; adminConfig != nil {
+		admin := server.NewAdmin(lsServer, server.AdminOptions{
+			Token: adminConfig.Token,
+			// 通过管理接口修改的用户写回配置文件，重启后继续生效
+			OnUsersChange: func(users []*server.User) error {
+				config.Users = userConfigs(users)
+				return config.Save()
+			},
+		})
+		go func() {
+			if err := admin.ListenAndServe(adminConfig.Listen); err != nil {
+				logger.WithError(err).Fatal("管理接口运行失败")
+			}
+		}()
+	}
+	if config.Metrics != nil && config.Metrics.Listen != "" {
+		go func() {
+			if err := lsServer.Metrics().ListenAndServe(config.Metrics.Listen); err != nil {
+				logger.WithError(err).Fatal("指标服务运行失败")
+			}
+		}()
+	}
+	lsServer.AfterListen = func(listenAddr net.Addr) {
+		fields := logrus.Fields{"listenAddr": listenAddr.String()}
+		if lsServer.Users != nil {
+			fields["users"] = lsServer.Users.Len()
+		} else {
+			fields["password"] = config.Password
+		}
+		logger.WithFields(fields).Info("服务启动成功")
+	}
+
+	go saveOnExit(lsServer)
 
 	// 启动服务器
 	if err := lsServer.Listen(); err != nil {
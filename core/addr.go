@@ -0,0 +1,16 @@
+package core
+
+import "net"
+
+// IsLoopbackAddr 判断监听地址 addr 是否只接受本机连接
+func IsLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,16 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsLoopbackAddr(t *testing.T) {
+	assert.True(t, IsLoopbackAddr("127.0.0.1:7450"))
+	assert.True(t, IsLoopbackAddr("[::1]:7450"))
+	assert.True(t, IsLoopbackAddr("localhost:7450"))
+	assert.False(t, IsLoopbackAddr(":7450"))
+	assert.False(t, IsLoopbackAddr("0.0.0.0:7450"))
+	assert.False(t, IsLoopbackAddr("127.0.0.1"))
+}
@@ -4,3 +4,17 @@ type Cipher interface {
 	Encrypt([]byte) ([]byte, error)
 	Decrypt([]byte) ([]byte, error)
 }
+
+// CipherError 表示数据加密或解密失败，例如 AES-GCM 认证失败，可以用 errors.As 识别
+type CipherError struct {
+	Op  string // 失败的操作，例如 "加密"、"解密握手数据"
+	Err error
+}
+
+func (e *CipherError) Error() string {
+	return e.Op + "失败: " + e.Err.Error()
+}
+
+func (e *CipherError) Unwrap() error {
+	return e.Err
+}
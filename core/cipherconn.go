@@ -0,0 +1,41 @@
+package core
+
+import (
+	"net"
+)
+
+// CipherConn 在 net.Conn 之上透明地加解密数据，读取时解密，写入时加密
+//
+// 仅适用于 SimpleCi 这类逐字节、不改变长度的编解码器
+type CipherConn struct {
+	net.Conn
+	cipher Cipher
+}
+
+// NewCipherConn 使用 cipher 包装连接
+func NewCipherConn(conn net.Conn, cipher Cipher) *CipherConn {
+	return &CipherConn{Conn: conn, cipher: cipher}
+}
+
+// Read 读取并解密数据
+func (c *CipherConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		if _, derr := c.cipher.Decrypt(b[:n]); derr != nil {
+			return 0, &CipherError{Op: "解密", Err: derr}
+		}
+	}
+	return n, err
+}
+
+// Write 加密并写入数据，不会修改调用方传入的 b
+func (c *CipherConn) Write(b []byte) (int, error) {
+	data, err := c.cipher.Encrypt(append([]byte(nil), b...))
+	if err != nil {
+		return 0, &CipherError{Op: "加密", Err: err}
+	}
+	if _, err := c.Conn.Write(data); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,163 @@
+package core
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConnInfo 描述一条正在转发的连接
+type ConnInfo struct {
+	ID       string    `json:"id"`
+	User     string    `json:"user,omitempty"`  // 服务端识别出的用户
+	Route    string    `json:"route,omitempty"` // 本地端选择的路由，direct 或服务器名称
+	Source   string    `json:"source"`          // 发起连接的客户端地址
+	Target   string    `json:"target"`          // 请求的目标地址
+	Up       int64     `json:"up"`              // 已转发的上行字节数
+	Down     int64     `json:"down"`            // 已转发的下行字节数
+	Start    time.Time `json:"start"`
+	Duration float64   `json:"duration"` // 已持续的秒数
+}
+
+// ConnTable 记录正在转发的连接，可以按 ID 中断连接，同时累计所有连接的总量
+type ConnTable struct {
+	mu    sync.Mutex
+	conns map[string]*TrackedConn
+
+	total      atomic.Int64 // 登记过的连接数
+	closedUp   atomic.Int64 // 已结束连接的上行字节数
+	closedDown atomic.Int64 // 已结束连接的下行字节数
+	startedAt  time.Time
+}
+
+// NewConnTable 创建连接表
+func NewConnTable() *ConnTable {
+	return &ConnTable{conns: make(map[string]*TrackedConn), startedAt: time.Now()}
+}
+
+// TrackedConn 是连接表中的一条连接
+type TrackedConn struct {
+	info     ConnInfo
+	up, down atomic.Int64
+	close    func()
+	table    *ConnTable
+}
+
+// Add 登记一条连接，close 用于中断连接。连接结束时需要调用 Remove
+func (t *ConnTable) Add(info ConnInfo, close func()) *TrackedConn {
+	if info.Start.IsZero() {
+		info.Start = time.Now()
+	}
+	c := &TrackedConn{info: info, close: close, table: t}
+	t.mu.Lock()
+	t.conns[info.ID] = c
+	t.mu.Unlock()
+	t.total.Add(1)
+	return c
+}
+
+// Up 返回累计上行字节数的 Throttle
+func (c *TrackedConn) Up() Throttle {
+	return byteCounter{&c.up}
+}
+
+// Down 返回累计下行字节数的 Throttle
+func (c *TrackedConn) Down() Throttle {
+	return byteCounter{&c.down}
+}
+
+// Remove 从连接表中移除连接，字节数计入总量
+func (c *TrackedConn) Remove() {
+	t := c.table
+	t.mu.Lock()
+	delete(t.conns, c.info.ID)
+	t.mu.Unlock()
+	t.closedUp.Add(c.up.Load())
+	t.closedDown.Add(c.down.Load())
+}
+
+func (c *TrackedConn) snapshot(now time.Time) ConnInfo {
+	info := c.info
+	info.Up, info.Down = c.up.Load(), c.down.Load()
+	info.Duration = now.Sub(info.Start).Seconds()
+	return info
+}
+
+// List 返回正在转发的连接，按开始时间排序
+func (t *ConnTable) List() []ConnInfo {
+	now := time.Now()
+	t.mu.Lock()
+	list := make([]ConnInfo, 0, len(t.conns))
+	for _, c := range t.conns {
+		list = append(list, c.snapshot(now))
+	}
+	t.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Start.Before(list[j].Start) })
+	return list
+}
+
+// Close 中断 ID 为 id 的连接，连接不存在时返回 false
+func (t *ConnTable) Close(id string) bool {
+	t.mu.Lock()
+	c, ok := t.conns[id]
+	t.mu.Unlock()
+	if ok {
+		c.close()
+	}
+	return ok
+}
+
+// CloseWhere 中断所有满足 match 的连接，返回中断的连接数
+func (t *ConnTable) CloseWhere(match func(ConnInfo) bool) int {
+	now := time.Now()
+	var matched []*TrackedConn
+	t.mu.Lock()
+	for _, c := range t.conns {
+		if match(c.snapshot(now)) {
+			matched = append(matched, c)
+		}
+	}
+	t.mu.Unlock()
+	for _, c := range matched {
+		c.close()
+	}
+	return len(matched)
+}
+
+// ConnStats 是连接表的汇总数据
+type ConnStats struct {
+	Uptime      float64 `json:"uptime"`      // 连接表创建以来的秒数
+	ActiveConns int     `json:"activeConns"` // 正在转发的连接数
+	TotalConns  int64   `json:"totalConns"`  // 登记过的连接数
+	Up          int64   `json:"up"`          // 所有连接的上行字节数
+	Down        int64   `json:"down"`        // 所有连接的下行字节数
+}
+
+// Stats 返回汇总数据，字节数包括已经结束与正在转发的连接
+func (t *ConnTable) Stats() ConnStats {
+	stats := ConnStats{
+		Uptime:     time.Since(t.startedAt).Seconds(),
+		TotalConns: t.total.Load(),
+		Up:         t.closedUp.Load(),
+		Down:       t.closedDown.Load(),
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stats.ActiveConns = len(t.conns)
+	for _, c := range t.conns {
+		stats.Up += c.up.Load()
+		stats.Down += c.down.Load()
+	}
+	return stats
+}
+
+// byteCounter 是只累计字节数的 Throttle
+type byteCounter struct {
+	n *atomic.Int64
+}
+
+func (c byteCounter) Wait(n int) error {
+	c.n.Add(int64(n))
+	return nil
+}
@@ -0,0 +1,42 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnTable(t *testing.T) {
+	table := NewConnTable()
+	closed := make(map[string]bool)
+	a := table.Add(ConnInfo{ID: "a", User: "alice", Target: "example.com:80"}, func() { closed["a"] = true })
+	b := table.Add(ConnInfo{ID: "b", User: "bob", Target: "example.com:443"}, func() { closed["b"] = true })
+	require.NoError(t, a.Up().Wait(10))
+	require.NoError(t, a.Down().Wait(100))
+	require.NoError(t, b.Up().Wait(1))
+
+	list := table.List()
+	require.Len(t, list, 2)
+	assert.Equal(t, "a", list[0].ID)
+	assert.Equal(t, int64(10), list[0].Up)
+	assert.Equal(t, int64(100), list[0].Down)
+	assert.False(t, list[0].Start.IsZero())
+
+	assert.False(t, table.Close("missing"))
+	assert.True(t, table.Close("b"))
+	assert.True(t, closed["b"])
+	assert.Equal(t, 1, table.CloseWhere(func(info ConnInfo) bool { return info.User == "alice" }))
+	assert.True(t, closed["a"])
+
+	b.Remove()
+	stats := table.Stats()
+	assert.Equal(t, 1, stats.ActiveConns)
+	assert.Equal(t, int64(2), stats.TotalConns)
+	assert.Equal(t, int64(11), stats.Up, "已结束连接的字节数计入总量")
+	assert.Equal(t, int64(100), stats.Down)
+
+	a.Remove()
+	assert.Empty(t, table.List())
+	assert.Equal(t, int64(11), table.Stats().Up)
+}
@@ -0,0 +1,164 @@
+package core
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// HTTP2Options 定义了本地端使用的 HTTP/2 传输，每条代理连接是共享 HTTP/2 连接上的一个 POST 流，
+// 请求体承载发往服务端的数据，响应体承载服务端返回的数据
+type HTTP2Options struct {
+	URL  string // 请求地址，例如 https://example.com/tunnel，http:// 表示明文的 h2c
+	Host string // 请求的 :authority 与 TLS 的 SNI，为空时使用 URL 中的主机
+}
+
+// Location 解析请求地址，返回替换了 Host 之后的 URL
+func (o *HTTP2Options) Location() (*url.URL, error) {
+	return parseLocation("HTTP/2", o.URL, o.Host, "http", "https")
+}
+
+// Secure 判断是否在 TLS 之上传输
+func (o *HTTP2Options) Secure() bool {
+	u, err := o.Location()
+	return err == nil && u.Scheme == "https"
+}
+
+// http2Transport 返回该服务器共享的 HTTP/2 Transport，所有流复用同一条连接，
+// 连接失效后由 Transport 重新拨号
+func (s *SecureSocket) http2Transport() *http2.Transport {
+	s.h2Once.Do(func() {
+		s.h2 = &http2.Transport{
+			// 明文 h2c 需要 AllowHTTP，拨号时是否使用 TLS 由 dialTLS 决定
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, _, _ string, _ *tls.Config) (net.Conn, error) {
+				return s.dialTLS(ctx)
+			},
+			// 连接空闲时发送 PING，及时发现失效的共享连接
+			ReadIdleTimeout: TIMEOUT,
+			PingTimeout:     TIMEOUT / 2,
+		}
+	})
+	return s.h2
+}
+
+// dialHTTP2 在共享的 HTTP/2 连接上打开一个新的流
+func (s *SecureSocket) dialHTTP2() (net.Conn, error) {
+	location, err := s.HTTP2.Location()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pr, pw := io.Pipe()
+	conn := &http2Conn{pw: pw, cancel: cancel, localAddr: s.LocalAddr, remoteAddr: s.ServerAddr}
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			conn.localAddr, conn.remoteAddr = info.Conn.LocalAddr(), info.Conn.RemoteAddr()
+		},
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, location.String(), pr)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("创建 HTTP/2 请求失败: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	// RoundTrip 在收到响应头后返回，此后请求体与响应体双向流式传输
+	timer := time.AfterFunc(TIMEOUT, cancel)
+	resp, err := s.http2Transport().RoundTrip(req)
+	timer.Stop()
+	if err != nil {
+		cancel()
+		pw.Close()
+		return nil, fmt.Errorf("打开 HTTP/2 流失败: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		cancel()
+		pw.Close()
+		return nil, fmt.Errorf("服务端拒绝 HTTP/2 流: %s", resp.Status)
+	}
+	conn.body = resp.Body
+	return conn, nil
+}
+
+// http2Conn 把一个 HTTP/2 流包装为 net.Conn
+type http2Conn struct {
+	body       io.ReadCloser  // 响应体，读取服务端返回的数据
+	pw         *io.PipeWriter // 请求体，写入发往服务端的数据
+	cancel     context.CancelFunc
+	localAddr  net.Addr
+	remoteAddr net.Addr
+
+	mu        sync.Mutex
+	deadline  *time.Timer
+	closeOnce sync.Once
+}
+
+func (c *http2Conn) Read(b []byte) (int, error) {
+	return c.body.Read(b)
+}
+
+func (c *http2Conn) Write(b []byte) (int, error) {
+	return c.pw.Write(b)
+}
+
+// Close 结束请求体并重置流，不影响共享连接上的其他流
+func (c *http2Conn) Close() error {
+	c.closeOnce.Do(func() {
+		c.pw.Close()
+		c.body.Close()
+		c.cancel()
+		c.mu.Lock()
+		if c.deadline != nil {
+			c.deadline.Stop()
+		}
+		c.mu.Unlock()
+	})
+	return nil
+}
+
+func (c *http2Conn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+func (c *http2Conn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// SetDeadline 到期后关闭整个流，HTTP/2 流无法单独中断一次读写
+func (c *http2Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.deadline != nil {
+		c.deadline.Stop()
+		c.deadline = nil
+	}
+	if t.IsZero() {
+		return nil
+	}
+	c.deadline = time.AfterFunc(time.Until(t), func() {
+		c.pw.CloseWithError(os.ErrDeadlineExceeded)
+		c.Close()
+	})
+	return nil
+}
+
+func (c *http2Conn) SetReadDeadline(t time.Time) error {
+	return c.SetDeadline(t)
+}
+
+func (c *http2Conn) SetWriteDeadline(t time.Time) error {
+	return c.SetDeadline(t)
+}
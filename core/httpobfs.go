@@ -0,0 +1,160 @@
+package core
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxHTTPObfsBody 是 HTTP 伪装请求中首个数据包的长度上限
+const maxHTTPObfsBody = 64 * 1024
+
+// websocketGUID 用于计算 Sec-WebSocket-Accept，见 RFC 6455
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// HTTPObfsOptions 定义了 simple-obfs 风格的 HTTP 伪装：本地端发送的首个数据包作为一个
+// WebSocket 升级请求的请求体，服务端回复 101 响应后，双方直接传输原始数据
+type HTTPObfsOptions struct {
+	Host string // 伪装请求的 Host 头，为空时使用服务端地址
+	Path string // 伪装请求的路径，默认 "/"
+}
+
+// httpObfsClient 在首次写入前附加 HTTP 升级请求，首次读取时剥离服务端的 101 响应
+type httpObfsClient struct {
+	net.Conn
+	opts   *HTTPObfsOptions
+	reader *bufio.Reader
+	sent   bool
+	recv   bool
+}
+
+// NewHTTPObfsClient 使用 HTTP 伪装包装本地端连接
+func NewHTTPObfsClient(conn net.Conn, opts *HTTPObfsOptions) net.Conn {
+	return &httpObfsClient{Conn: conn, opts: opts, reader: bufio.NewReader(conn)}
+}
+
+func (c *httpObfsClient) Write(b []byte) (int, error) {
+	if c.sent {
+		return c.Conn.Write(b)
+	}
+	c.sent = true
+
+	host, path := c.opts.Host, c.opts.Path
+	if host == "" {
+		host, _, _ = net.SplitHostPort(c.RemoteAddr().String())
+	}
+	if path == "" {
+		path = "/"
+	}
+	key := make([]byte, 16)
+	rand.Read(key)
+	var req strings.Builder
+	fmt.Fprintf(&req, "GET %s HTTP/1.1\r\n", path)
+	fmt.Fprintf(&req, "Host: %s\r\n", host)
+	req.WriteString("User-Agent: curl/8.5.0\r\n")
+	req.WriteString("Upgrade: websocket\r\n")
+	req.WriteString("Connection: Upgrade\r\n")
+	fmt.Fprintf(&req, "Sec-WebSocket-Key: %s\r\n", base64.StdEncoding.EncodeToString(key))
+	fmt.Fprintf(&req, "Content-Length: %d\r\n\r\n", len(b))
+
+	if _, err := c.Conn.Write(append([]byte(req.String()), b...)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *httpObfsClient) Read(b []byte) (int, error) {
+	if !c.recv {
+		c.recv = true
+		resp, err := http.ReadResponse(c.reader, nil)
+		if err != nil {
+			return 0, fmt.Errorf("读取 HTTP 伪装响应失败: %w", err)
+		}
+		if resp.StatusCode != http.StatusSwitchingProtocols {
+			return 0, fmt.Errorf("HTTP 伪装响应状态异常: %s", resp.Status)
+		}
+	}
+	return c.reader.Read(b)
+}
+
+// httpObfsServer 首次读取时解析并剥离 HTTP 升级请求，首次写入前附加 101 响应
+type httpObfsServer struct {
+	net.Conn
+	reader  *bufio.Reader
+	pending []byte // 请求体中尚未读取的数据
+	accept  string
+	recv    bool
+	sent    bool
+}
+
+// NewHTTPObfsServer 使用 HTTP 伪装包装服务端连接
+func NewHTTPObfsServer(conn net.Conn) net.Conn {
+	return &httpObfsServer{Conn: conn, reader: bufio.NewReader(conn)}
+}
+
+func (c *httpObfsServer) Read(b []byte) (int, error) {
+	if !c.recv {
+		c.recv = true
+		if err := c.readRequest(); err != nil {
+			return 0, err
+		}
+	}
+	if len(c.pending) > 0 {
+		n := copy(b, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	return c.reader.Read(b)
+}
+
+// readRequest 解析伪装请求，请求体即本地端的首个数据包。没有请求体的请求不是本地端发出的
+func (c *httpObfsServer) readRequest() error {
+	req, err := http.ReadRequest(c.reader)
+	if err != nil {
+		return fmt.Errorf("读取 HTTP 伪装请求失败: %w", err)
+	}
+	if req.Method != http.MethodGet || !strings.EqualFold(req.Header.Get("Upgrade"), "websocket") ||
+		req.ContentLength <= 0 || req.ContentLength > maxHTTPObfsBody {
+		return errors.New("不是有效的 HTTP 伪装请求")
+	}
+	c.pending = make([]byte, req.ContentLength)
+	if _, err := io.ReadFull(req.Body, c.pending); err != nil {
+		return fmt.Errorf("读取 HTTP 伪装请求体失败: %w", err)
+	}
+	c.accept = websocketAccept(req.Header.Get("Sec-WebSocket-Key"))
+	return nil
+}
+
+func (c *httpObfsServer) Write(b []byte) (int, error) {
+	if c.sent {
+		return c.Conn.Write(b)
+	}
+	c.sent = true
+
+	var resp strings.Builder
+	resp.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	resp.WriteString("Server: nginx/1.24.0\r\n")
+	fmt.Fprintf(&resp, "Date: %s\r\n", time.Now().UTC().Format(http.TimeFormat))
+	resp.WriteString("Upgrade: websocket\r\n")
+	resp.WriteString("Connection: Upgrade\r\n")
+	fmt.Fprintf(&resp, "Sec-WebSocket-Accept: %s\r\n\r\n", c.accept)
+
+	if _, err := c.Conn.Write(append([]byte(resp.String()), b...)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// websocketAccept 根据 Sec-WebSocket-Key 计算 Sec-WebSocket-Accept
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,66 @@
+package core
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPObfs(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	client := NewHTTPObfsClient(a, &HTTPObfsOptions{Host: "www.example.com", Path: "/chat"})
+
+	go client.Write([]byte("hello"))
+	reader := bufio.NewReader(b)
+	req, err := http.ReadRequest(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "www.example.com", req.Host)
+	assert.Equal(t, "/chat", req.URL.Path)
+	assert.Equal(t, "websocket", req.Header.Get("Upgrade"))
+	body, err := io.ReadAll(req.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+
+	// 服务端剥离请求并回复 101，之后双方直接传输原始数据
+	c, d := net.Pipe()
+	defer c.Close()
+	defer d.Close()
+	client = NewHTTPObfsClient(c, &HTTPObfsOptions{Host: "www.example.com"})
+	server := NewHTTPObfsServer(d)
+	go func() {
+		client.Write([]byte("ping"))
+		client.Write([]byte("more"))
+	}()
+	buf := make([]byte, 16)
+	n, err := server.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buf[:n]))
+	n, err = server.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "more", string(buf[:n]))
+
+	go server.Write([]byte("pong"))
+	n, err = client.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "pong", string(buf[:n]))
+}
+
+func TestHTTPObfs_Probe(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	go io.WriteString(a, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	_, err := NewHTTPObfsServer(b).Read(make([]byte, 16))
+	assert.ErrorContains(t, err, "不是有效的 HTTP 伪装请求", "没有请求体的普通请求不是本地端发出的")
+}
+
+func TestWebSocketAccept(t *testing.T) {
+	// RFC 6455 第 1.3 节的示例
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", websocketAccept("dGhlIHNhbXBsZSBub25jZQ=="))
+}
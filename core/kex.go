@@ -0,0 +1,333 @@
+package core
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxClockSkew 是服务端接受的客户端 Hello 时间戳与本机时间的最大偏差，也是防重放的窗口
+	MaxClockSkew = 2 * time.Minute
+	// HelloFollowUp 是收到握手消息的首批数据后等待其余部分的最长时间。真实客户端一次写出完整的消息，
+	// 主动探测发送的短数据不会有后续，尽快结束读取使其及时交给诱饵服务，避免长时间无响应暴露特征
+	HelloFollowUp = 500 * time.Millisecond
+
+	kexKeySize         = 32
+	kexMACSize         = sha256.Size
+	clientHelloSize    = kexKeySize + 8 + kexMACSize
+	serverHelloSize    = kexKeySize + kexMACSize
+	sessionMaxPayload  = 16*1024 - 1
+	sessionLengthSize  = 2
+	clientHelloLabel   = "minisocks client hello"
+	serverHelloLabel   = "minisocks server hello"
+	sessionKeysLabel   = "minisocks session keys"
+	sessionKeyMaterial = 2 * 32
+)
+
+var (
+	// ErrKexAuth 表示对端的 Hello 没有通过 HMAC 认证，通常是密码不一致
+	ErrKexAuth = errors.New("密钥交换认证失败")
+	// ErrKexReplay 表示客户端 Hello 的时间戳超出窗口或已经出现过
+	ErrKexReplay = errors.New("密钥交换消息过期或被重放")
+)
+
+// KeyExchange 在每条连接开始时进行一次临时 X25519 密钥交换，双方的公钥以预共享密钥的 HMAC 认证，
+// 会话密钥由 HKDF 从共享密钥派生，每个方向使用独立的 AES-256-GCM 密钥。
+// 密码泄露后无法解密此前记录的会话
+//
+// 客户端 Hello：公钥(32) | Unix 时间戳(8) | HMAC(psk, label | 公钥 | 时间戳)(32)
+// 服务端 Hello：公钥(32) | HMAC(psk, label | 客户端 Hello | 公钥)(32)
+type KeyExchange struct {
+	psk    []byte
+	now    func() time.Time
+	replay *replayFilter
+}
+
+// NewKeyExchange 从密码派生预共享密钥，两端的密码必须一致
+func NewKeyExchange(password string) *KeyExchange {
+	psk := sha256.Sum256([]byte(password))
+	return &KeyExchange{psk: psk[:], now: time.Now, replay: newReplayFilter()}
+}
+
+// Client 作为本地端完成密钥交换，返回以会话密钥加密收发数据的连接
+func (k *KeyExchange) Client(conn net.Conn) (net.Conn, error) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("生成临时密钥失败: %w", err)
+	}
+	conn.SetDeadline(time.Now().Add(TIMEOUT))
+	defer conn.SetDeadline(time.Time{})
+
+	hello := k.clientHello(priv.PublicKey().Bytes(), k.now())
+	if _, err := conn.Write(hello); err != nil {
+		return nil, fmt.Errorf("发送密钥交换消息失败: %w", err)
+	}
+	reply := make([]byte, serverHelloSize)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return nil, fmt.Errorf("读取密钥交换消息失败: %w", err)
+	}
+	if !hmac.Equal(reply[kexKeySize:], k.mac(serverHelloLabel, hello, reply[:kexKeySize])) {
+		return nil, ErrKexAuth
+	}
+
+	keys, err := k.sessionKeys(priv, reply[:kexKeySize], hello, reply)
+	if err != nil {
+		return nil, err
+	}
+	return newSessionConn(conn, keys[:32], keys[32:])
+}
+
+// Server 作为服务端完成密钥交换，返回以会话密钥加密收发数据的连接
+func (k *KeyExchange) Server(conn net.Conn) (net.Conn, error) {
+	session, _, err := AcceptKeyExchange(conn, []*KeyExchange{k})
+	return session, err
+}
+
+// AcceptKeyExchange 作为服务端完成密钥交换，依次使用 keys 认证客户端 Hello，
+// 返回会话连接与认证通过的密钥在 keys 中的下标。多用户服务端以此识别用户
+func AcceptKeyExchange(conn net.Conn, keys []*KeyExchange) (net.Conn, int, error) {
+	conn.SetDeadline(time.Now().Add(TIMEOUT))
+	defer conn.SetDeadline(time.Time{})
+
+	hello := make([]byte, clientHelloSize)
+	if err := ReadHello(conn, hello); err != nil {
+		return nil, -1, fmt.Errorf("读取密钥交换消息失败: %w", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(TIMEOUT))
+	for i, k := range keys {
+		if err := k.verifyClientHello(hello); err == ErrKexAuth {
+			continue
+		} else if err != nil {
+			return nil, i, err
+		}
+		session, err := k.respond(conn, hello)
+		return session, i, err
+	}
+	return nil, -1, ErrKexAuth
+}
+
+// ReadHello 读取固定长度的握手消息：等待首批数据最长 TIMEOUT，其余部分需要在 HelloFollowUp 内到达。
+// 返回后连接的读超时保持为设置过的值，由调用方重新设置
+func ReadHello(conn net.Conn, buf []byte) error {
+	conn.SetReadDeadline(time.Now().Add(TIMEOUT))
+	n, err := io.ReadAtLeast(conn, buf, 1)
+	if err == nil && n < len(buf) {
+		conn.SetReadDeadline(time.Now().Add(HelloFollowUp))
+		_, err = io.ReadFull(conn, buf[n:])
+	}
+	return err
+}
+
+// respond 回复服务端 Hello 并派生会话密钥
+func (k *KeyExchange) respond(conn net.Conn, hello []byte) (net.Conn, error) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("生成临时密钥失败: %w", err)
+	}
+	pub := priv.PublicKey().Bytes()
+	reply := append(pub, k.mac(serverHelloLabel, hello, pub)...)
+
+	keys, err := k.sessionKeys(priv, hello[:kexKeySize], hello, reply)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(reply); err != nil {
+		return nil, fmt.Errorf("发送密钥交换消息失败: %w", err)
+	}
+	return newSessionConn(conn, keys[32:], keys[:32])
+}
+
+func (k *KeyExchange) clientHello(pub []byte, now time.Time) []byte {
+	hello := make([]byte, 0, clientHelloSize)
+	hello = append(hello, pub...)
+	hello = binary.BigEndian.AppendUint64(hello, uint64(now.Unix()))
+	return append(hello, k.mac(clientHelloLabel, hello)...)
+}
+
+// verifyClientHello 校验客户端 Hello 的 HMAC、时间戳与是否重放
+func (k *KeyExchange) verifyClientHello(hello []byte) error {
+	signed, mac := hello[:kexKeySize+8], hello[kexKeySize+8:]
+	if !hmac.Equal(mac, k.mac(clientHelloLabel, signed)) {
+		return ErrKexAuth
+	}
+	now := k.now()
+	sent := time.Unix(int64(binary.BigEndian.Uint64(hello[kexKeySize:])), 0)
+	if sent.Before(now.Add(-MaxClockSkew)) || sent.After(now.Add(MaxClockSkew)) {
+		return ErrKexReplay
+	}
+	if !k.replay.add(mac, now) {
+		return ErrKexReplay
+	}
+	return nil
+}
+
+func (k *KeyExchange) mac(label string, parts ...[]byte) []byte {
+	h := hmac.New(sha256.New, k.psk)
+	h.Write([]byte(label))
+	for _, part := range parts {
+		h.Write(part)
+	}
+	return h.Sum(nil)
+}
+
+// sessionKeys 计算共享密钥并派生会话密钥，前 32 字节用于本地端到服务端，后 32 字节用于服务端到本地端
+func (k *KeyExchange) sessionKeys(priv *ecdh.PrivateKey, peer, clientHello, serverHello []byte) ([]byte, error) {
+	peerKey, err := ecdh.X25519().NewPublicKey(peer)
+	if err != nil {
+		return nil, fmt.Errorf("无效的对端公钥: %w", err)
+	}
+	shared, err := priv.ECDH(peerKey)
+	if err != nil {
+		return nil, fmt.Errorf("计算共享密钥失败: %w", err)
+	}
+	return deriveSessionKeys(shared, k.psk, clientHello, serverHello)
+}
+
+// deriveSessionKeys 以 psk 为盐、双方 Hello 为上下文，从共享密钥派生会话密钥
+func deriveSessionKeys(shared, psk, clientHello, serverHello []byte) ([]byte, error) {
+	info := sessionKeysLabel + string(clientHello) + string(serverHello)
+	keys, err := hkdf.Key(sha256.New, shared, psk, info, sessionKeyMaterial)
+	if err != nil {
+		return nil, fmt.Errorf("派生会话密钥失败: %w", err)
+	}
+	return keys, nil
+}
+
+// replayFilter 记录窗口内出现过的客户端 Hello
+type replayFilter struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func newReplayFilter() *replayFilter {
+	return &replayFilter{seen: make(map[string]time.Time)}
+}
+
+// add 记录 mac，已经出现过时返回 false
+func (f *replayFilter) add(mac []byte, now time.Time) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for key, at := range f.seen {
+		if now.Sub(at) > 2*MaxClockSkew {
+			delete(f.seen, key)
+		}
+	}
+	if _, ok := f.seen[string(mac)]; ok {
+		return false
+	}
+	f.seen[string(mac)] = now
+	return true
+}
+
+// sessionConn 以会话密钥加密收发数据。每个帧由加密的 2 字节长度与加密的载荷组成，
+// nonce 为各方向独立递增的计数器
+type sessionConn struct {
+	net.Conn
+	send, recv           cipher.AEAD
+	sendNonce, recvNonce []byte
+	pending              []byte // 已解密但尚未读取的数据
+	wmu                  sync.Mutex
+}
+
+func newSessionConn(conn net.Conn, sendKey, recvKey []byte) (*sessionConn, error) {
+	send, err := newGCM(sendKey)
+	if err != nil {
+		return nil, err
+	}
+	recv, err := newGCM(recvKey)
+	if err != nil {
+		return nil, err
+	}
+	return &sessionConn{
+		Conn:      conn,
+		send:      send,
+		recv:      recv,
+		sendNonce: make([]byte, send.NonceSize()),
+		recvNonce: make([]byte, recv.NonceSize()),
+	}, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Read 每次最多返回一个帧的数据
+func (c *sessionConn) Read(b []byte) (int, error) {
+	if len(c.pending) == 0 {
+		header := make([]byte, sessionLengthSize+c.recv.Overhead())
+		if _, err := io.ReadFull(c.Conn, header); err != nil {
+			return 0, err
+		}
+		length, err := c.open(header)
+		if err != nil {
+			return 0, err
+		}
+		frame := make([]byte, int(binary.BigEndian.Uint16(length))+c.recv.Overhead())
+		if _, err := io.ReadFull(c.Conn, frame); err != nil {
+			return 0, err
+		}
+		if c.pending, err = c.open(frame); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *sessionConn) open(data []byte) ([]byte, error) {
+	plain, err := c.recv.Open(data[:0], c.recvNonce, data, nil)
+	if err != nil {
+		return nil, &CipherError{Op: "解密", Err: err}
+	}
+	increment(c.recvNonce)
+	return plain, nil
+}
+
+func (c *sessionConn) Write(b []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	for written := 0; written < len(b); {
+		chunk := b[written:min(len(b), written+sessionMaxPayload)]
+		frame := make([]byte, 0, sessionLengthSize+len(chunk)+2*c.send.Overhead())
+		frame = c.seal(frame, binary.BigEndian.AppendUint16(nil, uint16(len(chunk))))
+		frame = c.seal(frame, chunk)
+		if _, err := c.Conn.Write(frame); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+	}
+	return len(b), nil
+}
+
+func (c *sessionConn) seal(dst, plain []byte) []byte {
+	dst = c.send.Seal(dst, c.sendNonce, plain, nil)
+	increment(c.sendNonce)
+	return dst
+}
+
+// increment 将小端序的 nonce 加一
+func increment(nonce []byte) {
+	for i := range nonce {
+		nonce[i]++
+		if nonce[i] != 0 {
+			return
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package core
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"encoding/hex"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
+
+// 测试向量：双方私钥取自 RFC 7748 第 6.1 节，密码为 "minisocks"，时间戳为 1700000000
+func TestKeyExchange_Vectors(t *testing.T) {
+	alice, err := ecdh.X25519().NewPrivateKey(mustHex(t, "77076d0a7318a57d3c16c17251b26645df4c2f87ebc0992ab177fba51db92c2a"))
+	require.NoError(t, err)
+	bob, err := ecdh.X25519().NewPrivateKey(mustHex(t, "5dab087e624a8a4b79e17f8b83800ee66f3bb1292618b6fd1c2f8b27ff88e0eb"))
+	require.NoError(t, err)
+	assert.Equal(t, "8520f0098930a754748b7ddcb43ef75a0dbf3a0d26381af4eba4a98eaa9b4e6a", hex.EncodeToString(alice.PublicKey().Bytes()))
+	assert.Equal(t, "de9edb7d7b7dc1b4d35b61c2ece435373f8343c85b78674dadfc7e146f882b4f", hex.EncodeToString(bob.PublicKey().Bytes()))
+
+	k := NewKeyExchange("minisocks")
+	clientHello := k.clientHello(alice.PublicKey().Bytes(), time.Unix(1700000000, 0))
+	assert.Equal(t, "8520f0098930a754748b7ddcb43ef75a0dbf3a0d26381af4eba4a98eaa9b4e6a000000006553f100"+
+		"c6c9b390a6fb8e4ec8220a9337a72b08c76ec05d4226c58a61010e56e8d366fc", hex.EncodeToString(clientHello))
+
+	bobPub := bob.PublicKey().Bytes()
+	serverHello := append(bobPub, k.mac(serverHelloLabel, clientHello, bobPub)...)
+	assert.Equal(t, "de9edb7d7b7dc1b4d35b61c2ece435373f8343c85b78674dadfc7e146f882b4f"+
+		"3fc08ea13e2a104176d6d269a06d89587432bc8b59f222239452c10c79f9ea39", hex.EncodeToString(serverHello))
+
+	clientKeys, err := k.sessionKeys(alice, bobPub, clientHello, serverHello)
+	require.NoError(t, err)
+	serverKeys, err := k.sessionKeys(bob, alice.PublicKey().Bytes(), clientHello, serverHello)
+	require.NoError(t, err)
+	assert.Equal(t, clientKeys, serverKeys)
+	assert.Equal(t, "c9058d04d18f1264a8f93493c1876da26b1d88c0a8f31d6f4740151521b44eb3", hex.EncodeToString(clientKeys[:32]))
+	assert.Equal(t, "f83a5d228b8b227d16e6575b134e2c171f5d7d9b768dd3a34e842fb2d4e9500f", hex.EncodeToString(clientKeys[32:]))
+}
+
+// kexPair 在内存管道上完成密钥交换，返回双方的连接与错误
+func kexPair(t *testing.T, client, server *KeyExchange) (net.Conn, net.Conn, error, error) {
+	t.Helper()
+	a, b := net.Pipe()
+	t.Cleanup(func() { a.Close(); b.Close() })
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		conn, err := server.Server(b)
+		if err != nil {
+			b.Close()
+		}
+		ch <- result{conn, err}
+	}()
+	clientConn, clientErr := client.Client(a)
+	if clientErr != nil {
+		a.Close()
+	}
+	r := <-ch
+	return clientConn, r.conn, clientErr, r.err
+}
+
+func TestKeyExchange_Session(t *testing.T) {
+	k := NewKeyExchange("minisocks")
+	client, server, err, serr := kexPair(t, k, k)
+	require.NoError(t, err)
+	require.NoError(t, serr)
+
+	data := bytes.Repeat([]byte("0123456789"), 5000)
+	go func() {
+		client.Write(data)
+		client.Write([]byte("tail"))
+	}()
+	got := make([]byte, len(data)+4)
+	_, err = io.ReadFull(server, got)
+	require.NoError(t, err)
+	assert.Equal(t, append(data, "tail"...), got)
+
+	go server.Write([]byte("pong"))
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(client, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "pong", string(buf))
+}
+
+func TestKeyExchange_WrongPassword(t *testing.T) {
+	_, _, err, serr := kexPair(t, NewKeyExchange("a"), NewKeyExchange("b"))
+	assert.Error(t, err)
+	assert.ErrorIs(t, serr, ErrKexAuth)
+}
+
+func TestAcceptKeyExchange(t *testing.T) {
+	keys := []*KeyExchange{NewKeyExchange("alice"), NewKeyExchange("bob")}
+	a, b := net.Pipe()
+	t.Cleanup(func() { a.Close(); b.Close() })
+	go NewKeyExchange("bob").Client(a)
+	_, i, err := AcceptKeyExchange(b, keys)
+	require.NoError(t, err)
+	assert.Equal(t, 1, i, "识别出认证通过的密钥")
+
+	a, b = net.Pipe()
+	t.Cleanup(func() { a.Close(); b.Close() })
+	go NewKeyExchange("carol").Client(a)
+	_, _, err = AcceptKeyExchange(b, keys)
+	assert.ErrorIs(t, err, ErrKexAuth)
+}
+
+func TestKeyExchange_Replay(t *testing.T) {
+	k := NewKeyExchange("minisocks")
+	pub := bytes.Repeat([]byte{9}, kexKeySize)
+	hello := k.clientHello(pub, time.Now())
+	assert.NoError(t, k.verifyClientHello(hello))
+	assert.ErrorIs(t, k.verifyClientHello(hello), ErrKexReplay, "重放的 Hello 被拒绝")
+
+	stale := k.clientHello(pub, time.Now().Add(-2*MaxClockSkew))
+	assert.ErrorIs(t, k.verifyClientHello(stale), ErrKexReplay, "过期的 Hello 被拒绝")
+
+	hello[0] ^= 1
+	assert.ErrorIs(t, k.verifyClientHello(hello), ErrKexAuth)
+}
+
+func TestSessionConn_Tamper(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	key := bytes.Repeat([]byte{1}, 32)
+	sender, err := newSessionConn(a, key, key)
+	require.NoError(t, err)
+	receiver, err := newSessionConn(&tamperConn{Conn: b}, key, key)
+	require.NoError(t, err)
+
+	go sender.Write([]byte("secret"))
+	_, err = receiver.Read(make([]byte, 16))
+	var cipherErr *CipherError
+	assert.ErrorAs(t, err, &cipherErr)
+}
+
+// tamperConn 翻转读取到的第一个字节
+type tamperConn struct {
+	net.Conn
+	done bool
+}
+
+func (c *tamperConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 && !c.done {
+		b[0] ^= 0xff
+		c.done = true
+	}
+	return n, err
+}
@@ -0,0 +1,225 @@
+package core
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxRecordSize 是混淆记录载荷的上限
+	MaxRecordSize = 16 * 1024
+	// MaxPaddingSize 是单个混淆记录填充的上限
+	MaxPaddingSize = 4096
+	// DefaultMaxPadding 是未配置时单个记录最多填充的字节数
+	DefaultMaxPadding = 256
+
+	obfsHeaderSize = 4
+)
+
+// ObfsOptions 定义了流量混淆。启用后连接上的数据以记录为单位传输，每个记录由 2 字节载荷长度、
+// 2 字节填充长度、载荷与随机填充组成，记录头与数据一同加密。
+// 长度写在记录头中，因此两端只需同时启用，各项参数只影响发送方
+type ObfsOptions struct {
+	// PaddingRecords 是添加随机填充与时间抖动的前 N 个记录，握手与请求的长度特征集中在连接开始阶段
+	PaddingRecords int
+	// MaxPadding 是每个记录最多填充的字节数，为 0 时使用 DefaultMaxPadding
+	MaxPadding int
+	// MaxRecord 是单个记录的最大载荷，更长的写入被拆分为随机长度的多个记录，为 0 时使用 MaxRecordSize
+	MaxRecord int
+	// MergeDelay 大于 0 时小块写入先缓存，最多等待该时长后合并为一个记录发送
+	MergeDelay time.Duration
+	// Jitter 大于 0 时前 N 个记录发送前随机等待 (0, Jitter]
+	Jitter time.Duration
+}
+
+// Validate 校验参数范围
+func (o *ObfsOptions) Validate() error {
+	if o.PaddingRecords < 0 {
+		return fmt.Errorf("填充记录数 %d 不能为负数", o.PaddingRecords)
+	}
+	if o.MaxPadding < 0 || o.MaxPadding > MaxPaddingSize {
+		return fmt.Errorf("最大填充 %d 超出范围 [0, %d]", o.MaxPadding, MaxPaddingSize)
+	}
+	if o.MaxRecord < 0 || o.MaxRecord > MaxRecordSize {
+		return fmt.Errorf("最大记录长度 %d 超出范围 [0, %d]", o.MaxRecord, MaxRecordSize)
+	}
+	if o.MergeDelay < 0 || o.Jitter < 0 {
+		return fmt.Errorf("合并等待与时间抖动不能为负数")
+	}
+	return nil
+}
+
+// ObfsConn 在 net.Conn 之上按 ObfsOptions 收发混淆记录，Read 每次最多返回一个记录的载荷
+type ObfsConn struct {
+	net.Conn
+	opts       ObfsOptions
+	maxPadding int
+	maxRecord  int
+
+	// 读取状态，只在读协程中使用
+	header    [obfsHeaderSize]byte
+	remaining int // 当前记录未读取的载荷长度
+	padding   int // 当前记录载荷之后的填充长度
+
+	mu      sync.Mutex
+	sent    int    // 已发送的记录数
+	pending []byte // 等待合并的数据
+	timer   *time.Timer
+	werr    error // 合并定时器发送失败的错误，在下次写入时返回
+}
+
+// NewObfsConn 使用 opts 包装连接。conn 应当是加密后的连接，否则记录头以明文传输
+func NewObfsConn(conn net.Conn, opts *ObfsOptions) *ObfsConn {
+	c := &ObfsConn{
+		Conn:       conn,
+		opts:       *opts,
+		maxPadding: min(opts.MaxPadding, MaxPaddingSize),
+		maxRecord:  min(opts.MaxRecord, MaxRecordSize),
+	}
+	if c.maxPadding <= 0 {
+		c.maxPadding = DefaultMaxPadding
+	}
+	if c.maxRecord <= 0 {
+		c.maxRecord = MaxRecordSize
+	}
+	return c
+}
+
+// Read 读取下一个记录的载荷，丢弃填充
+func (c *ObfsConn) Read(b []byte) (int, error) {
+	for c.remaining == 0 {
+		if _, err := io.ReadFull(c.Conn, c.header[:]); err != nil {
+			return 0, err
+		}
+		c.remaining = int(binary.BigEndian.Uint16(c.header[0:2]))
+		c.padding = int(binary.BigEndian.Uint16(c.header[2:4]))
+		if c.remaining > MaxRecordSize || c.padding > MaxPaddingSize {
+			return 0, fmt.Errorf("无效的混淆记录头: 载荷 %d 字节，填充 %d 字节", c.remaining, c.padding)
+		}
+		if c.remaining == 0 {
+			if err := c.discard(); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	n, err := c.Conn.Read(b[:min(len(b), c.remaining)])
+	c.remaining -= n
+	if c.remaining == 0 && err == nil {
+		err = c.discard()
+	}
+	return n, err
+}
+
+// discard 丢弃当前记录的填充
+func (c *ObfsConn) discard() error {
+	n := c.padding
+	c.padding = 0
+	if n == 0 {
+		return nil
+	}
+	if _, err := io.CopyN(io.Discard, c.Conn, int64(n)); err != nil {
+		return fmt.Errorf("读取混淆填充失败: %w", err)
+	}
+	return nil
+}
+
+// Write 将数据拆分为记录发送，配置了 MergeDelay 时先缓存，返回时数据可能尚未发出
+func (c *ObfsConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.werr != nil {
+		return 0, c.werr
+	}
+
+	if c.opts.MergeDelay <= 0 {
+		if err := c.writeRecords(b); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	c.pending = append(c.pending, b...)
+	if len(c.pending) >= c.maxRecord {
+		if err := c.flush(); err != nil {
+			return 0, err
+		}
+	} else if c.timer == nil {
+		c.timer = time.AfterFunc(c.opts.MergeDelay, func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.timer = nil
+			if c.werr == nil {
+				c.werr = c.flush()
+			}
+		})
+	}
+	return len(b), nil
+}
+
+// Close 发送缓存的数据后关闭连接
+func (c *ObfsConn) Close() error {
+	c.mu.Lock()
+	if c.werr == nil {
+		c.werr = c.flush()
+	}
+	c.mu.Unlock()
+	return c.Conn.Close()
+}
+
+// flush 发送缓存的数据，调用时需持有 mu
+func (c *ObfsConn) flush() error {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	if len(c.pending) == 0 {
+		return nil
+	}
+	err := c.writeRecords(c.pending)
+	c.pending = c.pending[:0]
+	return err
+}
+
+// writeRecords 将 b 拆分为记录依次发送，超过 maxRecord 的部分按随机长度拆分
+func (c *ObfsConn) writeRecords(b []byte) error {
+	for len(b) > 0 {
+		size := len(b)
+		if size > c.maxRecord {
+			size = c.maxRecord/2 + rand.N(c.maxRecord-c.maxRecord/2) + 1
+		}
+		if err := c.writeRecord(b[:size]); err != nil {
+			return err
+		}
+		b = b[size:]
+	}
+	return nil
+}
+
+func (c *ObfsConn) writeRecord(payload []byte) error {
+	padding := 0
+	if c.sent < c.opts.PaddingRecords {
+		padding = rand.N(c.maxPadding + 1)
+		if c.opts.Jitter > 0 {
+			time.Sleep(time.Duration(rand.Int64N(int64(c.opts.Jitter))) + 1)
+		}
+	}
+	c.sent++
+
+	record := make([]byte, obfsHeaderSize+len(payload)+padding)
+	binary.BigEndian.PutUint16(record[0:2], uint16(len(payload)))
+	binary.BigEndian.PutUint16(record[2:4], uint16(padding))
+	copy(record[obfsHeaderSize:], payload)
+	for i := obfsHeaderSize + len(payload); i < len(record); i++ {
+		record[i] = byte(rand.Uint32())
+	}
+	if _, err := c.Conn.Write(record); err != nil {
+		return fmt.Errorf("写入混淆记录失败: %w", err)
+	}
+	return nil
+}
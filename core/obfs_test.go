@@ -0,0 +1,100 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/rand/v2"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestObfsConn_RoundTrip(t *testing.T) {
+	for name, opts := range map[string]*ObfsOptions{
+		"默认":    {},
+		"填充与拆分": {PaddingRecords: 8, MaxPadding: 1000, MaxRecord: 1500, Jitter: time.Millisecond},
+		"合并":    {PaddingRecords: 2, MergeDelay: 5 * time.Millisecond},
+	} {
+		t.Run(name, func(t *testing.T) {
+			a, b := net.Pipe()
+			writer, reader := NewObfsConn(a, opts), NewObfsConn(b, opts)
+
+			data := make([]byte, 50000)
+			for i := range data {
+				data[i] = byte(rand.Uint32())
+			}
+			go func() {
+				for rest := data; len(rest) > 0; {
+					n := min(len(rest), 1+rand.N(3000))
+					writer.Write(rest[:n])
+					rest = rest[n:]
+				}
+				writer.Close()
+			}()
+
+			got, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			assert.True(t, bytes.Equal(data, got))
+		})
+	}
+}
+
+func TestObfsConn_Records(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	writer := NewObfsConn(a, &ObfsOptions{PaddingRecords: 2, MaxPadding: 100, MaxRecord: 1000})
+	go func() {
+		writer.Write(make([]byte, 10))
+		writer.Write(make([]byte, 10))
+		writer.Write(make([]byte, 10))
+		writer.Write(make([]byte, 3000))
+		writer.Close()
+	}()
+
+	var sizes, paddings []int
+	header := make([]byte, obfsHeaderSize)
+	for {
+		if _, err := io.ReadFull(b, header); err != nil {
+			break
+		}
+		size, padding := int(binary.BigEndian.Uint16(header[0:2])), int(binary.BigEndian.Uint16(header[2:4]))
+		_, err := io.CopyN(io.Discard, b, int64(size+padding))
+		require.NoError(t, err)
+		sizes, paddings = append(sizes, size), append(paddings, padding)
+	}
+
+	require.GreaterOrEqual(t, len(sizes), 6, "3000 字节至少拆分为 3 个记录")
+	assert.Equal(t, []int{10, 10, 10}, sizes[:3])
+	total := 0
+	for i, size := range sizes {
+		assert.LessOrEqual(t, paddings[i], 100)
+		if i >= 2 {
+			assert.Zero(t, paddings[i], "前 2 个记录之后不再填充")
+		}
+		if i >= 3 {
+			assert.LessOrEqual(t, size, 1000)
+			total += size
+		}
+	}
+	assert.Equal(t, 3000, total)
+}
+
+func TestObfsConn_InvalidHeader(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	go b.Write([]byte{0xff, 0xff, 0x00, 0x00})
+	_, err := NewObfsConn(a, &ObfsOptions{}).Read(make([]byte, 16))
+	assert.ErrorContains(t, err, "无效的混淆记录头")
+}
+
+func TestObfsOptions_Validate(t *testing.T) {
+	assert.NoError(t, (&ObfsOptions{PaddingRecords: 4, MaxPadding: MaxPaddingSize}).Validate())
+	assert.Error(t, (&ObfsOptions{MaxPadding: MaxPaddingSize + 1}).Validate())
+	assert.Error(t, (&ObfsOptions{MaxRecord: MaxRecordSize + 1}).Validate())
+	assert.Error(t, (&ObfsOptions{PaddingRecords: -1}).Validate())
+	assert.Error(t, (&ObfsOptions{Jitter: -time.Second}).Validate())
+}
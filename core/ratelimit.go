@@ -0,0 +1,68 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// Throttle 在 EncodeCopy 与 DecodeCopy 转发每块数据前被调用，用于限速与流量统计
+type Throttle interface {
+	// Wait 在转发 n 字节前调用，可以阻塞以限速，返回错误时停止转发
+	Wait(n int) error
+}
+
+// ThrottleFunc 将普通函数用作 Throttle
+type ThrottleFunc func(n int) error
+
+// Wait 调用 f(n)
+func (f ThrottleFunc) Wait(n int) error {
+	return f(n)
+}
+
+// Limiter 是令牌桶限速器，可以被多条连接共享。令牌不足时允许透支，
+// 由本次调用等待透支部分按速率补足所需的时间
+type Limiter struct {
+	rate  float64 // 每秒补充的字节数
+	burst float64 // 令牌桶容量
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// NewLimiter 创建每秒 rate 字节、容量 burst 字节的限速器，burst 不大于 0 时等于 rate
+func NewLimiter(rate, burst int64) *Limiter {
+	if burst <= 0 {
+		burst = rate
+	}
+	return &Limiter{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+		now:    time.Now,
+	}
+}
+
+// Wait 取走 n 个令牌，令牌不足时等待
+func (l *Limiter) Wait(n int) error {
+	if d := l.reserve(n); d > 0 {
+		time.Sleep(d)
+	}
+	return nil
+}
+
+// reserve 取走 n 个令牌，返回需要等待的时长
+func (l *Limiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
@@ -0,0 +1,25 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	l := NewLimiter(1000, 500)
+	l.now = func() time.Time { return now }
+	l.last = now
+
+	assert.Zero(t, l.reserve(500), "令牌桶初始是满的")
+	assert.Equal(t, 200*time.Millisecond, l.reserve(200), "透支的部分按速率等待")
+
+	now = now.Add(time.Second)
+	assert.Zero(t, l.reserve(300), "经过一秒补充 1000 个令牌")
+	assert.Zero(t, l.reserve(200))
+
+	now = now.Add(time.Hour)
+	assert.Equal(t, 500*time.Millisecond, l.reserve(1000), "补充的令牌不超过容量")
+}
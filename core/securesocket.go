@@ -1,12 +1,18 @@
 package core
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"net/url"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"golang.org/x/net/http2"
 )
 
 // BufSize 定义读写操作时缓冲区的大小
@@ -17,14 +23,24 @@ const TIMEOUT = 30 * time.Second
 
 // SecureSocket 结构体表示一个安全的网络套接字，用于加密传输数据
 type SecureSocket struct {
-	Cipher     Cipher       // 编解码器实例，用于数据的加密和解密
-	LocalAddr  *net.TCPAddr // 本地 TCP 地址
-	ServerAddr *net.TCPAddr // 远程服务器 TCP 地址
-	logger     *logrus.Entry
+	Cipher      Cipher            // 编解码器实例，用于数据的加密和解密
+	LocalAddr   net.Addr          // 本地监听地址
+	ServerAddr  net.Addr          // 远程服务器地址，配置了 Transport 时可以为空
+	TLSConfig   *tls.Config       // 不为空时本地端与服务端之间的连接使用 TLS 传输
+	WebSocket   *WebSocketOptions // 不为空时在 TCP 或 TLS 连接之上使用 WebSocket 传输
+	HTTP2       *HTTP2Options     // 不为空时每条代理连接是共享 HTTP/2 连接上的一个流
+	Obfs        *ObfsOptions      // 不为空时连接上的数据以混淆记录传输，两端需同时启用
+	HTTPObfs    *HTTPObfsOptions  // 不为空时首个数据包伪装为 HTTP 升级请求，两端需同时启用
+	KeyExchange *KeyExchange      // 不为空时每条连接先进行临时密钥交换，之后的数据以会话密钥加密，两端需同时启用
+	Transport   Transport         // 底层传输层，为空时本地端连接 ServerAddr，服务端在 LocalAddr 上监听
+	logger      *logrus.Entry
+
+	h2Once sync.Once
+	h2     *http2.Transport
 }
 
 // NewSecureSocket 创建新的 SecureSocket 实例
-func NewSecureSocket(cipher Cipher, localAddr, serverAddr *net.TCPAddr) *SecureSocket {
+func NewSecureSocket(cipher Cipher, localAddr, serverAddr net.Addr) *SecureSocket {
 	return &SecureSocket{
 		Cipher:     cipher,
 		LocalAddr:  localAddr,
@@ -37,8 +53,8 @@ func NewSecureSocket(cipher Cipher, localAddr, serverAddr *net.TCPAddr) *SecureS
 	}
 }
 
-// EncodeCopy 从源 TCP 连接中持续读取原始数据，加密后写入目标 TCP 连接
-func (s *SecureSocket) EncodeCopy(dst *net.TCPConn, src *net.TCPConn) error {
+// EncodeCopy 从源连接中持续读取原始数据，加密后写入目标连接，每块数据写入前依次经过 throttles
+func (s *SecureSocket) EncodeCopy(dst net.Conn, src net.Conn, throttles ...Throttle) error {
 	s.logger.WithFields(logrus.Fields{
 		"src": src.RemoteAddr(),
 		"dst": dst.RemoteAddr(),
@@ -50,10 +66,14 @@ func (s *SecureSocket) EncodeCopy(dst *net.TCPConn, src *net.TCPConn) error {
 		if nr > 0 {
 			s.logger.WithField("bytes", nr).Debug("读取原始数据")
 
+			if err := wait(throttles, nr); err != nil {
+				return err
+			}
+
 			data, err := s.Cipher.Encrypt(buf[:nr])
 			if err != nil {
 				s.logger.WithError(err).Error("加密数据失败")
-				return fmt.Errorf("加密失败: %w", err)
+				return &CipherError{Op: "加密", Err: err}
 			}
 
 			if _, ew := dst.Write(data); ew != nil {
@@ -73,8 +93,8 @@ func (s *SecureSocket) EncodeCopy(dst *net.TCPConn, src *net.TCPConn) error {
 	}
 }
 
-// DecodeCopy 从源 TCP 连接中持续读取加密数据，解密后写入目标 TCP 连接
-func (s *SecureSocket) DecodeCopy(dst *net.TCPConn, src *net.TCPConn) error {
+// DecodeCopy 从源连接中持续读取加密数据，解密后写入目标连接，每块数据写入前依次经过 throttles
+func (s *SecureSocket) DecodeCopy(dst net.Conn, src net.Conn, throttles ...Throttle) error {
 	s.logger.WithFields(logrus.Fields{
 		"src": src.RemoteAddr(),
 		"dst": dst.RemoteAddr(),
@@ -84,10 +104,14 @@ func (s *SecureSocket) DecodeCopy(dst *net.TCPConn, src *net.TCPConn) error {
 	for {
 		nr, er := src.Read(buf)
 		if nr > 0 {
+			if err := wait(throttles, nr); err != nil {
+				return err
+			}
+
 			data, err := s.Cipher.Decrypt(buf[:nr])
 			if err != nil {
 				s.logger.WithError(err).Error("解密数据失败")
-				return fmt.Errorf("解密失败: %w", err)
+				return &CipherError{Op: "解密", Err: err}
 			}
 
 			if _, ew := dst.Write(data); ew != nil {
@@ -107,16 +131,155 @@ func (s *SecureSocket) DecodeCopy(dst *net.TCPConn, src *net.TCPConn) error {
 	}
 }
 
-// DialServer 与远程服务器建立 TCP 连接
-func (s *SecureSocket) DialServer() (*net.TCPConn, error) {
+// wait 依次调用 throttles，跳过为 nil 的项
+func wait(throttles []Throttle, n int) error {
+	for _, t := range throttles {
+		if t == nil {
+			continue
+		}
+		if err := t.Wait(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DialServer 与远程服务器建立连接，配置了 TLSConfig 时在 TCP 连接之上完成 TLS 握手，
+// 配置了 WebSocket 时再完成 WebSocket 握手，配置了 HTTP2 时在共享连接上打开一个新的流，
+// 配置了 HTTPObfs、KeyExchange 与 Obfs 时在最终的连接上依次叠加 HTTP 伪装、会话加密与混淆记录
+func (s *SecureSocket) DialServer() (net.Conn, error) {
 	s.logger.Info("尝试连接远程服务器")
 
-	remoteConn, err := net.DialTCP("tcp", nil, s.ServerAddr)
+	conn, err := s.dialConn()
 	if err != nil {
-		s.logger.WithError(err).Error("连接远程服务器失败")
-		return nil, fmt.Errorf("连接 %s 失败: %w", s.ServerAddr, err)
+		return nil, err
+	}
+	if s.HTTPObfs != nil {
+		conn = NewHTTPObfsClient(conn, s.HTTPObfs)
+	}
+	if s.KeyExchange != nil {
+		session, err := s.KeyExchange.Client(conn)
+		if err != nil {
+			conn.Close()
+			s.logger.WithError(err).Error("密钥交换失败")
+			return nil, err
+		}
+		conn = session
+	}
+	if s.Obfs != nil {
+		conn = NewObfsConn(NewCipherConn(conn, s.Cipher), s.Obfs)
 	}
 
 	s.logger.Info("成功连接到远程服务器")
-	return remoteConn, nil
+	return conn, nil
+}
+
+// dialConn 建立承载加密数据的连接
+func (s *SecureSocket) dialConn() (net.Conn, error) {
+	if s.HTTP2 != nil {
+		conn, err := s.dialHTTP2()
+		if err != nil {
+			s.logger.WithError(err).Error("打开 HTTP/2 流失败")
+			return nil, err
+		}
+		return conn, nil
+	}
+
+	conn, err := s.dialTLS(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	if s.WebSocket != nil {
+		conn.SetDeadline(time.Now().Add(TIMEOUT))
+		wsConn, err := DialWebSocket(conn, s.WebSocket)
+		if err != nil {
+			conn.Close()
+			s.logger.WithError(err).Error("WebSocket 握手失败")
+			return nil, err
+		}
+		conn.SetDeadline(time.Time{})
+		conn = wsConn
+	}
+	return conn, nil
+}
+
+// dialTLS 通过传输层建立到远程服务器的连接，需要时在其上完成 TLS 握手
+func (s *SecureSocket) dialTLS(ctx context.Context) (net.Conn, error) {
+	transport := s.Transport
+	if transport == nil {
+		if s.ServerAddr == nil {
+			return nil, errors.New("未配置远程服务器地址")
+		}
+		transport = NewTCPTransport(s.ServerAddr)
+	}
+	remoteConn, err := transport.Dial(ctx)
+	if err != nil {
+		s.logger.WithError(err).Error("连接远程服务器失败")
+		return nil, fmt.Errorf("连接远程服务器失败: %w", err)
+	}
+
+	config := s.tlsConfig()
+	if config == nil {
+		return remoteConn, nil
+	}
+	tlsConn := tls.Client(remoteConn, config)
+	tlsConn.SetDeadline(time.Now().Add(TIMEOUT))
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		remoteConn.Close()
+		s.logger.WithError(err).Error("TLS 握手失败")
+		return nil, fmt.Errorf("与 %s 进行 TLS 握手失败: %w", remoteConn.RemoteAddr(), err)
+	}
+	tlsConn.SetDeadline(time.Time{})
+	if s.HTTP2 != nil && tlsConn.ConnectionState().NegotiatedProtocol != http2.NextProtoTLS {
+		remoteConn.Close()
+		return nil, fmt.Errorf("%s 不支持 HTTP/2", remoteConn.RemoteAddr())
+	}
+	return tlsConn, nil
+}
+
+// tlsConfig 返回与服务端握手使用的 TLS 配置，不使用 TLS 时返回 nil
+func (s *SecureSocket) tlsConfig() *tls.Config {
+	config := s.TLSConfig
+	if config == nil && (s.WebSocket != nil && s.WebSocket.Secure() || s.HTTP2 != nil && s.HTTP2.Secure()) {
+		config = &tls.Config{}
+	}
+	if config == nil {
+		return nil
+	}
+	if config.ServerName == "" && !config.InsecureSkipVerify {
+		config = config.Clone()
+		config.ServerName = s.serverName()
+	}
+	if s.HTTP2 != nil {
+		config = config.Clone()
+		config.NextProtos = []string{http2.NextProtoTLS}
+	}
+	return config
+}
+
+// serverName 返回校验服务器证书使用的主机名，使用 WebSocket 或 HTTP/2 时为请求地址中的主机
+func (s *SecureSocket) serverName() string {
+	var location *url.URL
+	var err error
+	switch {
+	case s.WebSocket != nil:
+		location, err = s.WebSocket.Location()
+	case s.HTTP2 != nil:
+		location, err = s.HTTP2.Location()
+	}
+	if location != nil && err == nil {
+		return location.Hostname()
+	}
+	if s.ServerAddr == nil {
+		return ""
+	}
+	if tcpAddr, ok := s.ServerAddr.(*net.TCPAddr); ok {
+		return tcpAddr.IP.String()
+	}
+	host, _, err := net.SplitHostPort(s.ServerAddr.String())
+	if err != nil {
+		return ""
+	}
+	return host
 }
@@ -0,0 +1,135 @@
+package core
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TLSOptions 定义了本地端与服务端之间 TLS 传输层的证书配置，两端使用同一结构：
+// 服务端的 CertFile/KeyFile 是服务器证书，配置 CAFile 时要求客户端出示由该 CA 签发的证书（双向认证）；
+// 客户端的 CertFile/KeyFile 是双向认证使用的客户端证书，CAFile 用于校验服务器证书
+type TLSOptions struct {
+	CertFile   string   // 本端证书文件（PEM）
+	KeyFile    string   // 本端私钥文件（PEM）
+	CAFile     string   // 校验对端证书的 CA 文件（PEM）
+	ServerName string   // 客户端发送的 SNI，同时用于校验服务器证书
+	Pins       []string // 客户端固定的服务器公钥 SHA-256（base64 或 hex，可带 "sha256/" 前缀），配置后不再校验证书链
+}
+
+// ServerConfig 生成服务端使用的 TLS 配置
+func (o *TLSOptions) ServerConfig() (*tls.Config, error) {
+	if o.CertFile == "" || o.KeyFile == "" {
+		return nil, errors.New("服务端启用 TLS 需要配置证书与私钥")
+	}
+	cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("加载证书失败: %w", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if o.CAFile != "" {
+		if config.ClientCAs, err = loadCertPool(o.CAFile); err != nil {
+			return nil, err
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// ClientConfig 生成本地端使用的 TLS 配置
+func (o *TLSOptions) ClientConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName: o.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if o.CertFile != "" || o.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("加载客户端证书失败: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if o.CAFile != "" {
+		var err error
+		if config.RootCAs, err = loadCertPool(o.CAFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(o.Pins) > 0 {
+		pins := make([][]byte, 0, len(o.Pins))
+		for _, pin := range o.Pins {
+			hash, err := parsePin(pin)
+			if err != nil {
+				return nil, err
+			}
+			pins = append(pins, hash)
+		}
+		// 固定公钥后由 VerifyConnection 校验，允许使用自签名证书
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			for _, cert := range cs.PeerCertificates {
+				hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+				for _, pin := range pins {
+					if subtle.ConstantTimeCompare(hash[:], pin) == 1 {
+						return nil
+					}
+				}
+			}
+			return errors.New("服务器证书公钥与固定的指纹不匹配")
+		}
+	}
+	return config, nil
+}
+
+// SPKIHash 计算证书公钥的 SHA-256 指纹，格式为 base64，可直接用作 TLSOptions.Pins
+func SPKIHash(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// CertificatePins 返回 TLS 配置中各证书的公钥指纹
+func CertificatePins(config *tls.Config) ([]string, error) {
+	pins := make([]string, 0, len(config.Certificates))
+	for _, cert := range config.Certificates {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return nil, fmt.Errorf("解析证书失败: %w", err)
+		}
+		pins = append(pins, SPKIHash(leaf))
+	}
+	return pins, nil
+}
+
+func parsePin(pin string) ([]byte, error) {
+	s := strings.TrimPrefix(strings.TrimSpace(pin), "sha256/")
+	if hash, err := hex.DecodeString(strings.ReplaceAll(s, ":", "")); err == nil && len(hash) == sha256.Size {
+		return hash, nil
+	}
+	if hash, err := base64.StdEncoding.DecodeString(s); err == nil && len(hash) == sha256.Size {
+		return hash, nil
+	}
+	return nil, fmt.Errorf("无效的公钥指纹 %q，应为 SHA-256 的 base64 或 hex 编码", pin)
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取 CA 文件失败: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("CA 文件 %s 中没有有效的证书", path)
+	}
+	return pool, nil
+}
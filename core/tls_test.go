@@ -0,0 +1,171 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testPKI 是测试中生成的 CA、服务器证书与客户端证书
+type testPKI struct {
+	dir        string
+	ca         string
+	serverCert string
+	serverKey  string
+	serverPin  string
+	clientCert string
+	clientKey  string
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	p := &testPKI{dir: t.TempDir()}
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "minisocks test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	caCert, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+	p.ca = p.writePEM(t, "ca.pem", "CERTIFICATE", caDER)
+
+	issue := func(name string, serial int64, usage x509.ExtKeyUsage) (string, string, *x509.Certificate) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: name},
+			DNSNames:     []string{name},
+			IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+		require.NoError(t, err)
+		keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+		require.NoError(t, err)
+		cert, err := x509.ParseCertificate(der)
+		require.NoError(t, err)
+		return p.writePEM(t, name+".pem", "CERTIFICATE", der), p.writePEM(t, name+".key", "PRIVATE KEY", keyDER), cert
+	}
+
+	var serverCert *x509.Certificate
+	p.serverCert, p.serverKey, serverCert = issue("example.com", 2, x509.ExtKeyUsageServerAuth)
+	p.serverPin = SPKIHash(serverCert)
+	p.clientCert, p.clientKey, _ = issue("client", 3, x509.ExtKeyUsageClientAuth)
+	return p
+}
+
+func (p *testPKI) writePEM(t *testing.T, name, typ string, der []byte) string {
+	path := filepath.Join(p.dir, name)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600))
+	return path
+}
+
+// tlsHandshake 在本地 TCP 连接上完成一次 TLS 握手，返回客户端或服务端的错误
+func tlsHandshake(t *testing.T, server, client *TLSOptions) error {
+	t.Helper()
+	serverConfig, err := server.ServerConfig()
+	require.NoError(t, err)
+	clientConfig, err := client.ClientConfig()
+	require.NoError(t, err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	serverErr := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		defer conn.Close()
+		tlsConn := tls.Server(conn, serverConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			serverErr <- err
+			return
+		}
+		// 读取客户端发送的数据，TLS 1.3 下客户端证书的校验结果在此之后才确定
+		_, err = tlsConn.Read(make([]byte, 1))
+		serverErr <- err
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	tlsConn := tls.Client(conn, clientConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+	if _, err := tlsConn.Write([]byte{1}); err != nil {
+		return err
+	}
+	return <-serverErr
+}
+
+func TestTLSOptions_CA(t *testing.T) {
+	pki := newTestPKI(t)
+	server := &TLSOptions{CertFile: pki.serverCert, KeyFile: pki.serverKey}
+
+	assert.NoError(t, tlsHandshake(t, server, &TLSOptions{CAFile: pki.ca, ServerName: "example.com"}))
+	assert.Error(t, tlsHandshake(t, server, &TLSOptions{CAFile: pki.ca, ServerName: "example.org"}), "SNI 与证书不符")
+	assert.Error(t, tlsHandshake(t, server, &TLSOptions{ServerName: "example.com"}), "系统 CA 不信任自签名证书")
+}
+
+func TestTLSOptions_Pins(t *testing.T) {
+	pki := newTestPKI(t)
+	server := &TLSOptions{CertFile: pki.serverCert, KeyFile: pki.serverKey}
+
+	assert.NoError(t, tlsHandshake(t, server, &TLSOptions{Pins: []string{pki.serverPin}}))
+	assert.NoError(t, tlsHandshake(t, server, &TLSOptions{Pins: []string{"sha256/" + pki.serverPin}, ServerName: "cdn.example.net"}),
+		"固定公钥后不校验证书链与主机名")
+
+	other := sha256.Sum256([]byte("other"))
+	err := tlsHandshake(t, server, &TLSOptions{Pins: []string{hex.EncodeToString(other[:])}})
+	assert.ErrorContains(t, err, "指纹不匹配")
+
+	_, err = (&TLSOptions{Pins: []string{"not-a-pin"}}).ClientConfig()
+	assert.Error(t, err)
+}
+
+func TestTLSOptions_MutualTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	server := &TLSOptions{CertFile: pki.serverCert, KeyFile: pki.serverKey, CAFile: pki.ca}
+
+	assert.Error(t, tlsHandshake(t, server, &TLSOptions{Pins: []string{pki.serverPin}}), "未出示客户端证书")
+	assert.NoError(t, tlsHandshake(t, server, &TLSOptions{
+		CertFile: pki.clientCert,
+		KeyFile:  pki.clientKey,
+		Pins:     []string{pki.serverPin},
+	}))
+
+	_, err := (&TLSOptions{CertFile: pki.serverCert}).ServerConfig()
+	assert.Error(t, err)
+}
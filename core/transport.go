@@ -0,0 +1,107 @@
+package core
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// Transport 抽象了本地端与服务端之间的底层连接，TLS、WebSocket 与 HTTP/2 都建立在其之上
+type Transport interface {
+	// Dial 由本地端调用，建立到服务端的连接
+	Dial(ctx context.Context) (net.Conn, error)
+	// Listen 由服务端调用，监听来自本地端的连接
+	Listen() (net.Listener, error)
+}
+
+// TCPTransport 是默认的传输层，本地端连接 Addr，服务端监听 Addr
+type TCPTransport struct {
+	Network string // 网络类型，为空时为 tcp，也可以是 unix 等 net.Dial 支持的流式网络
+	Addr    string
+}
+
+// NewTCPTransport 创建连接或监听 addr 的传输层，网络类型取自 addr.Network()
+func NewTCPTransport(addr net.Addr) *TCPTransport {
+	return &TCPTransport{Network: addr.Network(), Addr: addr.String()}
+}
+
+func (t *TCPTransport) network() string {
+	if t.Network == "" {
+		return "tcp"
+	}
+	return t.Network
+}
+
+// Dial 建立到 Addr 的连接
+func (t *TCPTransport) Dial(ctx context.Context) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, t.network(), t.Addr)
+}
+
+// Listen 在 Addr 上监听连接
+func (t *TCPTransport) Listen() (net.Listener, error) {
+	return net.Listen(t.network(), t.Addr)
+}
+
+// PipeTransport 通过内存管道连接同一进程内的本地端与服务端，不占用端口，适用于测试。
+// 监听器关闭后该传输层不能再使用
+type PipeTransport struct {
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewPipeTransport 创建一个内存管道传输层
+func NewPipeTransport() *PipeTransport {
+	return &PipeTransport{
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+// Dial 创建一对管道，另一端交给监听器，在监听器接受连接前阻塞
+func (t *PipeTransport) Dial(ctx context.Context) (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case t.conns <- server:
+		return client, nil
+	case <-t.closed:
+		client.Close()
+		server.Close()
+		return nil, &net.OpError{Op: "dial", Net: "pipe", Err: net.ErrClosed}
+	case <-ctx.Done():
+		client.Close()
+		server.Close()
+		return nil, ctx.Err()
+	}
+}
+
+// Listen 返回接受管道连接的监听器
+func (t *PipeTransport) Listen() (net.Listener, error) {
+	return (*pipeListener)(t), nil
+}
+
+type pipeListener PipeTransport
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, &net.OpError{Op: "accept", Net: "pipe", Err: net.ErrClosed}
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
@@ -0,0 +1,75 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPipeTransport(t *testing.T) {
+	transport := NewPipeTransport()
+	ln, err := transport.Listen()
+	require.NoError(t, err)
+	assert.Equal(t, "pipe", ln.Addr().String())
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+
+	conn, err := transport.Dial(context.Background())
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+	conn.Close()
+
+	// 没有监听器接受连接时 Dial 随 ctx 超时
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = transport.Dial(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// 关闭监听器后 Accept 与 Dial 都返回 net.ErrClosed
+	require.NoError(t, ln.Close())
+	_, err = ln.Accept()
+	assert.True(t, errors.Is(err, net.ErrClosed))
+	_, err = transport.Dial(context.Background())
+	assert.True(t, errors.Is(err, net.ErrClosed))
+}
+
+func TestTCPTransport_Unix(t *testing.T) {
+	addr := &net.UnixAddr{Name: filepath.Join(t.TempDir(), "minisocks.sock"), Net: "unix"}
+	transport := NewTCPTransport(addr)
+	ln, err := transport.Listen()
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			conn.Write([]byte("pong"))
+			conn.Close()
+		}
+	}()
+
+	conn, err := transport.Dial(context.Background())
+	require.NoError(t, err)
+	defer conn.Close()
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "pong", string(data))
+}
@@ -0,0 +1,71 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+
+	"golang.org/x/net/websocket"
+)
+
+// WebSocketOptions 定义了本地端使用的 WebSocket 传输，加密数据以二进制帧承载，
+// 服务端可以部署在 nginx、CDN 等只转发 HTTP 流量的反向代理之后
+type WebSocketOptions struct {
+	URL  string // 握手地址，例如 ws://example.com/ws 或 wss://example.com/ws，wss 表示在 TLS 之上传输
+	Host string // 握手请求的 Host 头与 TLS 的 SNI，为空时使用 URL 中的主机
+}
+
+// Location 解析握手地址，返回替换了 Host 之后的 URL
+func (o *WebSocketOptions) Location() (*url.URL, error) {
+	return parseLocation("WebSocket", o.URL, o.Host, "ws", "wss")
+}
+
+// Secure 判断是否使用 wss
+func (o *WebSocketOptions) Secure() bool {
+	u, err := o.Location()
+	return err == nil && u.Scheme == "wss"
+}
+
+// DialWebSocket 在已建立的连接（TCP 或 TLS）上完成 WebSocket 握手，返回以二进制帧收发数据的连接
+func DialWebSocket(conn net.Conn, opts *WebSocketOptions) (net.Conn, error) {
+	location, err := opts.Location()
+	if err != nil {
+		return nil, err
+	}
+	origin := &url.URL{Scheme: "http", Host: location.Host}
+	if location.Scheme == "wss" {
+		origin.Scheme = "https"
+	}
+	config := &websocket.Config{
+		Location: location,
+		Origin:   origin,
+		Version:  websocket.ProtocolVersionHybi13,
+	}
+	ws, err := websocket.NewClient(config, conn)
+	if err != nil {
+		return nil, fmt.Errorf("WebSocket 握手失败: %w", err)
+	}
+	ws.PayloadType = websocket.BinaryFrame
+	return ws, nil
+}
+
+// parseLocation 解析 HTTP 类传输的地址，scheme 只能是 plain 或 secure，host 不为空时替换 URL 中的主机
+func parseLocation(kind, raw, host, plain, secure string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("无效的 %s 地址 %q: %w", kind, raw, err)
+	}
+	if u.Scheme != plain && u.Scheme != secure {
+		return nil, fmt.Errorf("%s 地址 %q 的协议应为 %s 或 %s", kind, raw, plain, secure)
+	}
+	if host != "" {
+		u.Host = host
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("%s 地址 %q 缺少主机名", kind, raw)
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return u, nil
+}
@@ -0,0 +1,66 @@
+package core
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+func TestDialWebSocket(t *testing.T) {
+	hosts := make(chan string, 1)
+	srv := httptest.NewServer(websocket.Server{
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(conn *websocket.Conn) {
+			hosts <- conn.Request().Host + conn.Request().URL.Path
+			io.Copy(conn, conn)
+		},
+	})
+	defer srv.Close()
+
+	raw, err := net.Dial("tcp", srv.Listener.Addr().String())
+	require.NoError(t, err)
+	conn, err := DialWebSocket(raw, &WebSocketOptions{URL: "ws://origin.example.com/tunnel", Host: "cdn.example.com"})
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, "cdn.example.com/tunnel", <-hosts)
+	assert.Equal(t, byte(websocket.BinaryFrame), conn.(*websocket.Conn).PayloadType)
+
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+}
+
+func TestWebSocketOptions_Location(t *testing.T) {
+	u, err := (&WebSocketOptions{URL: "wss://example.com"}).Location()
+	require.NoError(t, err)
+	assert.Equal(t, "wss://example.com/", u.String())
+	assert.True(t, (&WebSocketOptions{URL: "wss://example.com/ws"}).Secure())
+	assert.False(t, (&WebSocketOptions{URL: "ws://example.com/ws"}).Secure())
+
+	for _, raw := range []string{"http://example.com/ws", "ws:///ws", "ws://%zz"} {
+		_, err := (&WebSocketOptions{URL: raw}).Location()
+		assert.Error(t, err, raw)
+	}
+	_, err = DialWebSocket(nil, &WebSocketOptions{URL: "example.com/ws"})
+	assert.Error(t, err)
+}
+
+func TestHTTP2Options_Location(t *testing.T) {
+	u, err := (&HTTP2Options{URL: "https://origin.example.com", Host: "cdn.example.com"}).Location()
+	require.NoError(t, err)
+	assert.Equal(t, "https://cdn.example.com/", u.String())
+	assert.True(t, (&HTTP2Options{URL: "https://example.com/h2"}).Secure())
+	assert.False(t, (&HTTP2Options{URL: "http://example.com/h2"}).Secure())
+
+	_, err = (&HTTP2Options{URL: "wss://example.com/h2"}).Location()
+	assert.Error(t, err)
+}
@@ -0,0 +1,191 @@
+package dns
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// 缓存默认参数
+const (
+	DefaultCacheSize   = 4096
+	DefaultNegativeTTL = 60 * time.Second
+	maxCacheTTL        = 24 * time.Hour
+)
+
+// cacheKey 唯一标识一个 DNS 问题
+type cacheKey struct {
+	name  string
+	typ   dnsmessage.Type
+	class dnsmessage.Class
+}
+
+func newCacheKey(q dnsmessage.Question) cacheKey {
+	return cacheKey{
+		name:  strings.ToLower(q.Name.String()),
+		typ:   q.Type,
+		class: q.Class,
+	}
+}
+
+type cacheEntry struct {
+	key      cacheKey
+	msg      dnsmessage.Message
+	storedAt time.Time
+	expireAt time.Time
+}
+
+// Cache 是一个遵守 TTL 的 DNS 响应缓存，支持 NXDOMAIN 与空应答的负缓存，超过容量时淘汰最久未使用的条目
+type Cache struct {
+	mu          sync.Mutex
+	size        int
+	negativeTTL time.Duration
+	ll          *list.List
+	items       map[cacheKey]*list.Element
+	now         func() time.Time
+}
+
+// NewCache 创建 DNS 缓存，size 与 negativeTTL 不大于 0 时使用默认值
+func NewCache(size int, negativeTTL time.Duration) *Cache {
+	if size <= 0 {
+		size = DefaultCacheSize
+	}
+	if negativeTTL <= 0 {
+		negativeTTL = DefaultNegativeTTL
+	}
+	return &Cache{
+		size:        size,
+		negativeTTL: negativeTTL,
+		ll:          list.New(),
+		items:       make(map[cacheKey]*list.Element),
+		now:         time.Now,
+	}
+}
+
+// Get 查询缓存，命中时返回 TTL 已按经过时间递减的响应副本
+func (c *Cache) Get(q dnsmessage.Question) (*dnsmessage.Message, bool) {
+	key := newCacheKey(q)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	now := c.now()
+	if !now.Before(entry.expireAt) {
+		c.ll.Remove(elem)
+		delete(c.items, key)
+		return nil, false
+	}
+	c.ll.MoveToFront(elem)
+
+	elapsed := uint32(now.Sub(entry.storedAt) / time.Second)
+	msg := copyMessage(&entry.msg)
+	for _, section := range [][]dnsmessage.Resource{msg.Answers, msg.Authorities, msg.Additionals} {
+		for i := range section {
+			if section[i].Header.Type == dnsmessage.TypeOPT {
+				continue
+			}
+			if section[i].Header.TTL > elapsed {
+				section[i].Header.TTL -= elapsed
+			} else {
+				section[i].Header.TTL = 0
+			}
+		}
+	}
+	return msg, true
+}
+
+// Set 缓存一条响应，根据响应内容计算有效期，不可缓存的响应会被忽略
+func (c *Cache) Set(q dnsmessage.Question, msg *dnsmessage.Message) {
+	ttl, ok := c.ttl(msg)
+	if !ok || ttl <= 0 {
+		return
+	}
+	if ttl > maxCacheTTL {
+		ttl = maxCacheTTL
+	}
+
+	key := newCacheKey(q)
+	now := c.now()
+	entry := &cacheEntry{
+		key:      key,
+		msg:      *copyMessage(msg),
+		storedAt: now,
+		expireAt: now.Add(ttl),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		elem.Value = entry
+		c.ll.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.ll.PushFront(entry)
+	for c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// Clear 清空缓存
+func (c *Cache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	clear(c.items)
+}
+
+// Len 返回缓存中的条目数
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+// ttl 计算响应的缓存时长：正常应答取所有记录的最小 TTL，
+// NXDOMAIN 与空应答按 RFC 2308 取 SOA 的 TTL 与 MINIMUM 中较小者，没有 SOA 时使用默认负缓存时长
+func (c *Cache) ttl(msg *dnsmessage.Message) (time.Duration, bool) {
+	if msg.Truncated {
+		return 0, false
+	}
+	switch msg.RCode {
+	case dnsmessage.RCodeSuccess:
+		if len(msg.Answers) > 0 {
+			minTTL := msg.Answers[0].Header.TTL
+			for _, rr := range msg.Answers[1:] {
+				minTTL = min(minTTL, rr.Header.TTL)
+			}
+			return time.Duration(minTTL) * time.Second, true
+		}
+	case dnsmessage.RCodeNameError:
+	default:
+		return 0, false
+	}
+
+	for _, rr := range msg.Authorities {
+		if soa, ok := rr.Body.(*dnsmessage.SOAResource); ok {
+			return time.Duration(min(rr.Header.TTL, soa.MinTTL)) * time.Second, true
+		}
+	}
+	return c.negativeTTL, true
+}
+
+// copyMessage 复制响应中的各个段，避免修改 TTL 时影响缓存
+func copyMessage(msg *dnsmessage.Message) *dnsmessage.Message {
+	cp := *msg
+	cp.Questions = append([]dnsmessage.Question(nil), msg.Questions...)
+	cp.Answers = append([]dnsmessage.Resource(nil), msg.Answers...)
+	cp.Authorities = append([]dnsmessage.Resource(nil), msg.Authorities...)
+	cp.Additionals = append([]dnsmessage.Resource(nil), msg.Additionals...)
+	return &cp
+}
@@ -0,0 +1,182 @@
+package dns
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+)
+
+// Fake-IP 默认参数
+const (
+	DefaultFakeIPRange = "198.18.0.0/15"
+	DefaultFakeIPSize  = 65535
+	fakeIPTTL          = 1
+)
+
+// FakeIP 为域名分配保留网段中的假地址，并维护域名与地址之间的双向映射。
+// 地址用尽时淘汰最久未使用的映射并复用其地址
+type FakeIP struct {
+	v4 *fakePool
+	v6 *fakePool
+}
+
+// NewFakeIP 创建 Fake-IP 地址池，v6Range 为空表示不为 AAAA 查询分配地址，size 为每个地址池的映射上限
+func NewFakeIP(v4Range, v6Range string, size int) (*FakeIP, error) {
+	if v4Range == "" {
+		v4Range = DefaultFakeIPRange
+	}
+	if size <= 0 {
+		size = DefaultFakeIPSize
+	}
+
+	f := &FakeIP{}
+	var err error
+	if f.v4, err = newFakePool(v4Range, size, true); err != nil {
+		return nil, err
+	}
+	if v6Range != "" {
+		if f.v6, err = newFakePool(v6Range, size, false); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// Lookup 返回域名对应的假地址，尚未分配时分配一个新地址。ipv6 为 true 且未配置 IPv6 网段时返回 nil
+func (f *FakeIP) Lookup(domain string, ipv6 bool) net.IP {
+	pool := f.v4
+	if ipv6 {
+		pool = f.v6
+	}
+	if pool == nil {
+		return nil
+	}
+	return pool.lookup(strings.TrimSuffix(strings.ToLower(domain), "."))
+}
+
+// Domain 返回假地址对应的域名
+func (f *FakeIP) Domain(ip net.IP) (string, bool) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return "", false
+	}
+	addr = addr.Unmap()
+	for _, pool := range []*fakePool{f.v4, f.v6} {
+		if pool != nil && pool.prefix.Contains(addr) {
+			return pool.domain(addr)
+		}
+	}
+	return "", false
+}
+
+// Contains 判断地址是否位于假地址网段内
+func (f *FakeIP) Contains(ip net.IP) bool {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	addr = addr.Unmap()
+	return f.v4.prefix.Contains(addr) || (f.v6 != nil && f.v6.prefix.Contains(addr))
+}
+
+type fakeEntry struct {
+	domain string
+	addr   netip.Addr
+}
+
+// fakePool 是单个网段内的地址池
+type fakePool struct {
+	mu       sync.Mutex
+	prefix   netip.Prefix
+	size     int
+	next     int // 下一个未使用过的地址序号，从 1 开始以跳过网络地址
+	ll       *list.List
+	byDomain map[string]*list.Element
+	byAddr   map[netip.Addr]*list.Element
+}
+
+func newFakePool(cidr string, size int, ipv4 bool) (*fakePool, error) {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("无效的 Fake-IP 网段 %q: %w", cidr, err)
+	}
+	if prefix.Addr().Is4() != ipv4 {
+		return nil, fmt.Errorf("Fake-IP 网段 %q 的地址族不正确", cidr)
+	}
+	prefix = prefix.Masked()
+
+	// 可用地址数为网段大小减去网络地址与广播地址
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+	if hostBits < 63 {
+		if capacity := (1 << hostBits) - 2; capacity < size {
+			size = capacity
+		}
+	}
+	if size <= 0 {
+		return nil, errors.New("Fake-IP 网段过小")
+	}
+
+	return &fakePool{
+		prefix:   prefix,
+		size:     size,
+		next:     1,
+		ll:       list.New(),
+		byDomain: make(map[string]*list.Element),
+		byAddr:   make(map[netip.Addr]*list.Element),
+	}, nil
+}
+
+func (p *fakePool) lookup(domain string) net.IP {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if elem, ok := p.byDomain[domain]; ok {
+		p.ll.MoveToFront(elem)
+		return net.IP(elem.Value.(*fakeEntry).addr.AsSlice())
+	}
+
+	var addr netip.Addr
+	if p.ll.Len() < p.size {
+		addr = p.nth(p.next)
+		p.next++
+	} else {
+		// 地址已用尽，复用最久未使用的地址
+		oldest := p.ll.Back()
+		entry := oldest.Value.(*fakeEntry)
+		p.ll.Remove(oldest)
+		delete(p.byDomain, entry.domain)
+		delete(p.byAddr, entry.addr)
+		addr = entry.addr
+	}
+
+	elem := p.ll.PushFront(&fakeEntry{domain: domain, addr: addr})
+	p.byDomain[domain] = elem
+	p.byAddr[addr] = elem
+	return net.IP(addr.AsSlice())
+}
+
+func (p *fakePool) domain(addr netip.Addr) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	elem, ok := p.byAddr[addr]
+	if !ok {
+		return "", false
+	}
+	p.ll.MoveToFront(elem)
+	return elem.Value.(*fakeEntry).domain, true
+}
+
+// nth 返回网段内第 n 个地址
+func (p *fakePool) nth(n int) netip.Addr {
+	base := new(big.Int).SetBytes(p.prefix.Addr().AsSlice())
+	base.Add(base, big.NewInt(int64(n)))
+	buf := base.FillBytes(make([]byte, p.prefix.Addr().BitLen()/8))
+	addr, _ := netip.AddrFromSlice(buf)
+	return addr
+}
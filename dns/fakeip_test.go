@@ -0,0 +1,89 @@
+package dns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestFakeIP_Lookup(t *testing.T) {
+	f, err := NewFakeIP("198.18.0.0/15", "fc00::/64", 0)
+	require.NoError(t, err)
+
+	ip := f.Lookup("Example.com.", false)
+	assert.Equal(t, "198.18.0.1", ip.String())
+	assert.Equal(t, ip, f.Lookup("example.com", false), "同一域名应得到相同地址")
+	assert.Equal(t, "198.18.0.2", f.Lookup("example.org", false).String())
+
+	domain, ok := f.Domain(ip)
+	assert.True(t, ok)
+	assert.Equal(t, "example.com", domain)
+
+	ip6 := f.Lookup("example.com", true)
+	assert.Equal(t, "fc00::1", ip6.String())
+	domain, ok = f.Domain(ip6)
+	assert.True(t, ok)
+	assert.Equal(t, "example.com", domain)
+
+	assert.True(t, f.Contains(net.ParseIP("198.19.255.1")))
+	assert.False(t, f.Contains(net.ParseIP("8.8.8.8")))
+	_, ok = f.Domain(net.ParseIP("198.18.0.200"))
+	assert.False(t, ok)
+}
+
+func TestFakeIP_Evict(t *testing.T) {
+	f, err := NewFakeIP("10.0.0.0/30", "", 0)
+	require.NoError(t, err)
+
+	// /30 只有两个可用地址
+	a := f.Lookup("a.com", false)
+	b := f.Lookup("b.com", false)
+	_, ok := f.Domain(a) // a 变为最近使用
+	assert.True(t, ok)
+
+	c := f.Lookup("c.com", false)
+	assert.Equal(t, b, c, "应复用最久未使用的地址")
+	domain, _ := f.Domain(c)
+	assert.Equal(t, "c.com", domain)
+	domain, _ = f.Domain(a)
+	assert.Equal(t, "a.com", domain)
+
+	assert.Nil(t, f.Lookup("a.com", true), "未配置 IPv6 网段时不分配地址")
+
+	_, err = NewFakeIP("10.0.0.0/32", "", 0)
+	assert.Error(t, err)
+	_, err = NewFakeIP("fc00::/64", "", 0)
+	assert.Error(t, err)
+}
+
+func TestServer_FakeIP(t *testing.T) {
+	upstream := &fakeUpstream{name: "tunnel"}
+	s := NewServer("127.0.0.1:0", upstream, NewCache(0, 0))
+	require.NoError(t, s.SetRules([]string{"domain-suffix:lan -> 192.168.1.1:53"}, nil))
+	lan := &fakeUpstream{name: "lan"}
+	s.rules.Load().upstreams["192.168.1.1:53"] = lan
+
+	fakeIP, err := NewFakeIP("", "", 0)
+	require.NoError(t, err)
+	s.FakeIP = fakeIP
+
+	resp := unpack(t, s.Resolve(newQuery(t, 1, "example.com.", dnsmessage.TypeA)))
+	require.Len(t, resp.Answers, 1)
+	a := resp.Answers[0].Body.(*dnsmessage.AResource).A
+	domain, ok := fakeIP.Domain(net.IP(a[:]))
+	assert.True(t, ok)
+	assert.Equal(t, "example.com", domain)
+
+	resp = unpack(t, s.Resolve(newQuery(t, 2, "example.com.", dnsmessage.TypeAAAA)))
+	assert.Equal(t, dnsmessage.RCodeSuccess, resp.RCode)
+	assert.Empty(t, resp.Answers)
+	assert.Equal(t, int32(0), upstream.calls.Load())
+
+	// 分流到其他上游的域名返回真实地址
+	resp = unpack(t, s.Resolve(newQuery(t, 3, "nas.lan.", dnsmessage.TypeA)))
+	assert.Equal(t, [4]byte{1, 2, 3, 4}, resp.Answers[0].Body.(*dnsmessage.AResource).A)
+	assert.Equal(t, int32(1), lan.calls.Load())
+}
@@ -0,0 +1,154 @@
+package dns
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Resolver 将域名解析为 IP 地址
+type Resolver interface {
+	LookupIP(ctx context.Context, host string) ([]net.IP, error)
+}
+
+// SystemResolver 使用操作系统配置的解析器
+type SystemResolver struct{}
+
+// LookupIP 实现 Resolver 接口
+func (SystemResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	return net.DefaultResolver.LookupIP(ctx, "ip", host)
+}
+
+// UpstreamResolver 通过指定的上游查询 A 与 AAAA 记录，并按记录的 TTL 缓存结果
+type UpstreamResolver struct {
+	Upstream Upstream
+	cache    *Cache
+}
+
+// NewUpstreamResolver 创建基于上游的解析器，cache 为 nil 时使用默认参数的缓存
+func NewUpstreamResolver(upstream Upstream, cache *Cache) *UpstreamResolver {
+	if cache == nil {
+		cache = NewCache(0, 0)
+	}
+	return &UpstreamResolver{Upstream: upstream, cache: cache}
+}
+
+// NewResolver 根据配置创建解析器，addr 为空或 "system" 时使用系统解析器，其余格式见 ParseUpstream
+func NewResolver(addr string) (Resolver, error) {
+	if addr == "" || addr == "system" {
+		return SystemResolver{}, nil
+	}
+	upstream, err := ParseUpstream(addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewUpstreamResolver(upstream, nil), nil
+}
+
+// LookupIP 实现 Resolver 接口，同时查询 A 与 AAAA 记录，IPv4 地址排在前面
+func (r *UpstreamResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	if !strings.HasSuffix(host, ".") {
+		host += "."
+	}
+	name, err := dnsmessage.NewName(host)
+	if err != nil {
+		return nil, fmt.Errorf("无效的域名 %q: %w", host, err)
+	}
+
+	type result struct {
+		ips []net.IP
+		err error
+	}
+	types := []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA}
+	results := make([]chan result, len(types))
+	for i, typ := range types {
+		results[i] = make(chan result, 1)
+		go func(ch chan<- result, typ dnsmessage.Type) {
+			ips, err := r.lookup(ctx, dnsmessage.Question{Name: name, Type: typ, Class: dnsmessage.ClassINET})
+			ch <- result{ips, err}
+		}(results[i], typ)
+	}
+
+	var ips []net.IP
+	var errs []error
+	for _, ch := range results {
+		res := <-ch
+		ips = append(ips, res.ips...)
+		if res.err != nil {
+			errs = append(errs, res.err)
+		}
+	}
+	if len(ips) > 0 {
+		return ips, nil
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return nil, fmt.Errorf("域名 %s 没有可用的地址", strings.TrimSuffix(host, "."))
+}
+
+// lookup 查询一种记录类型，优先使用缓存
+func (r *UpstreamResolver) lookup(ctx context.Context, q dnsmessage.Question) ([]net.IP, error) {
+	if msg, ok := r.cache.Get(q); ok {
+		return extractIPs(msg, q.Type)
+	}
+
+	var idBuf [2]byte
+	rand.Read(idBuf[:])
+	query := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: binary.BigEndian.Uint16(idBuf[:]), RecursionDesired: true},
+		Questions: []dnsmessage.Question{q},
+	}
+	buf, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := r.Upstream.Exchange(ctx, buf)
+	if err != nil {
+		return nil, err
+	}
+	var msg dnsmessage.Message
+	if err := msg.Unpack(raw); err != nil {
+		return nil, fmt.Errorf("解析上游响应失败: %w", err)
+	}
+	if msg.ID != query.ID || !msg.Response {
+		return nil, errors.New("上游响应与查询不匹配")
+	}
+	r.cache.Set(q, &msg)
+	return extractIPs(&msg, q.Type)
+}
+
+// extractIPs 从响应中取出指定类型的地址记录，CNAME 链上的地址记录同样包含在应答段中
+func extractIPs(msg *dnsmessage.Message, typ dnsmessage.Type) ([]net.IP, error) {
+	switch msg.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, errors.New("域名不存在")
+	default:
+		return nil, fmt.Errorf("上游返回错误 %s", msg.RCode)
+	}
+
+	var ips []net.IP
+	for _, rr := range msg.Answers {
+		if rr.Header.Type != typ {
+			continue
+		}
+		switch body := rr.Body.(type) {
+		case *dnsmessage.AResource:
+			ips = append(ips, append(net.IP(nil), body.A[:]...))
+		case *dnsmessage.AAAAResource:
+			ips = append(ips, append(net.IP(nil), body.AAAA[:]...))
+		}
+	}
+	return ips, nil
+}
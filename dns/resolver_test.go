@@ -0,0 +1,124 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newDoHServer 启动一个本地 DoH 服务，查询交给 fakeUpstream 应答
+func newDoHServer(t *testing.T, upstream *fakeUpstream) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		query, _ := io.ReadAll(r.Body)
+		if query[0] != 0 || query[1] != 0 {
+			http.Error(w, "DoH 查询 ID 应为 0", http.StatusBadRequest)
+			return
+		}
+		resp, err := upstream.Exchange(r.Context(), query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(resp)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestUpstreamResolver_DoH(t *testing.T) {
+	upstream := &fakeUpstream{name: "doh"}
+	srv := newDoHServer(t, upstream)
+
+	r := NewUpstreamResolver(&HTTPSUpstream{URL: srv.URL + "/dns-query", Client: srv.Client()}, nil)
+	ips, err := r.LookupIP(context.Background(), "example.com")
+	require.NoError(t, err)
+	require.Len(t, ips, 1, "fakeUpstream 对 AAAA 查询同样返回 A 记录，应被过滤")
+	assert.Equal(t, "1.2.3.4", ips[0].String())
+	assert.Equal(t, int32(2), upstream.calls.Load())
+
+	// 第二次解析命中缓存
+	ips, err = r.LookupIP(context.Background(), "example.com.")
+	require.NoError(t, err)
+	assert.Equal(t, "1.2.3.4", ips[0].String())
+	assert.Equal(t, int32(2), upstream.calls.Load())
+
+	_, err = r.LookupIP(context.Background(), "missing.nx")
+	assert.Error(t, err)
+
+	ips, err = r.LookupIP(context.Background(), "10.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", ips[0].String())
+}
+
+func TestUpstreamResolver_DoT(t *testing.T) {
+	// 复用 httptest 生成的自签名证书
+	certSrv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer certSrv.Close()
+	clientConfig := certSrv.Client().Transport.(*http.Transport).TLSClientConfig
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: certSrv.TLS.Certificates})
+	require.NoError(t, err)
+	defer ln.Close()
+	s := NewServer(ln.Addr().String(), &fakeUpstream{name: "dot"}, NewCache(0, 0))
+	go s.serveTCP(ln)
+
+	r := NewUpstreamResolver(&TLSUpstream{
+		Addr:      ln.Addr().String(),
+		TLSConfig: &tls.Config{RootCAs: clientConfig.RootCAs, ServerName: "example.com"},
+	}, nil)
+	ips, err := r.LookupIP(context.Background(), "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "1.2.3.4", ips[0].String())
+}
+
+func TestUpstreamResolver_UDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer pc.Close()
+	s := NewServer(pc.LocalAddr().String(), &fakeUpstream{name: "udp"}, NewCache(0, 0))
+	go s.serveUDP(pc)
+
+	r := NewUpstreamResolver(&UDPUpstream{Addr: pc.LocalAddr().String()}, nil)
+	ips, err := r.LookupIP(context.Background(), "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "1.2.3.4", ips[0].String())
+}
+
+func TestParseUpstream(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{"8.8.8.8", "udp://8.8.8.8:53"},
+		{"udp://8.8.8.8:5353", "udp://8.8.8.8:5353"},
+		{"tcp://8.8.8.8", "tcp://8.8.8.8:53"},
+		{"tls://1.1.1.1", "tls://1.1.1.1:853"},
+		{"tls://[2606:4700::1111]", "tls://[2606:4700::1111]:853"},
+		{"https://1.1.1.1/dns-query", "https://1.1.1.1/dns-query"},
+	}
+	for _, tt := range tests {
+		u, err := ParseUpstream(tt.addr)
+		require.NoError(t, err, tt.addr)
+		assert.Equal(t, tt.want, u.String())
+	}
+
+	_, err := ParseUpstream("quic://1.1.1.1")
+	assert.Error(t, err)
+
+	r, err := NewResolver("system")
+	require.NoError(t, err)
+	assert.IsType(t, SystemResolver{}, r)
+}
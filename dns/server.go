@@ -0,0 +1,302 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/beijian128/minisocks/route"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// 未携带 EDNS 时 UDP 响应的最大长度
+const minUDPSize = 512
+
+// splitRules 是一组分流规则及其对应的上游
+type splitRules struct {
+	router    *route.Router
+	upstreams map[route.Action]Upstream
+}
+
+// Server 是本地 DNS 服务，同时监听 UDP 与 TCP，按分流规则把查询转发给不同的上游并缓存响应
+type Server struct {
+	Addr     string   // 监听地址
+	Upstream Upstream // 默认上游，规则动作为 proxy 或没有规则命中时使用
+	// FakeIP 不为空时，走默认上游的 A/AAAA 查询改为返回假地址，由 LsLocal 在建立连接时还原域名
+	FakeIP *FakeIP
+	// OnExchange 不为空时在每次向上游查询后被调用，传入查询耗时与错误，用于统计
+	OnExchange func(d time.Duration, err error)
+	cache      *Cache
+	rules      atomic.Pointer[splitRules]
+	logger     *logrus.Entry
+}
+
+// NewServer 创建 DNS 服务
+func NewServer(addr string, upstream Upstream, cache *Cache) *Server {
+	s := &Server{
+		Addr:     addr,
+		Upstream: upstream,
+		cache:    cache,
+		logger: logrus.WithFields(logrus.Fields{
+			"component":  "DNS",
+			"listenAddr": addr,
+		}),
+	}
+	router, _ := route.New(nil, nil)
+	s.rules.Store(&splitRules{router: router})
+	return s
+}
+
+// SetRules 加载分流规则，格式与路由规则相同，例如 "domain-suffix:lan -> 192.168.1.1:53"。
+// 动作为 proxy 表示使用默认上游，reject 表示返回 NXDOMAIN，其余动作视为直接以 UDP 访问的上游地址。
+// 加载后清空缓存，避免继续返回按旧规则从其他上游得到的响应
+func (s *Server) SetRules(rules []string, geo *route.Geo) error {
+	parsed, err := route.ParseRules(rules, geo)
+	if err != nil {
+		return err
+	}
+	upstreams := make(map[route.Action]Upstream)
+	for _, rule := range parsed {
+		switch rule.Action {
+		case route.ActionProxy, route.ActionReject:
+			continue
+		case route.ActionDirect:
+			return fmt.Errorf("规则 %q 无效: DNS 分流规则需要填写上游地址而不是 direct", rule.Raw)
+		}
+		if _, _, err := net.SplitHostPort(string(rule.Action)); err != nil {
+			return fmt.Errorf("规则 %q 的上游地址无效: %w", rule.Raw, err)
+		}
+		upstreams[rule.Action] = &UDPUpstream{Addr: string(rule.Action)}
+	}
+
+	router := &route.Router{}
+	router.Reload(parsed)
+	s.rules.Store(&splitRules{router: router, upstreams: upstreams})
+	s.cache.Clear()
+	s.logger.WithField("rules", len(parsed)).Info("DNS 分流规则已加载")
+	return nil
+}
+
+// ListenAndServe 启动 UDP 与 TCP 监听，任意一个出错时返回
+func (s *Server) ListenAndServe() error {
+	pc, err := net.ListenPacket("udp", s.Addr)
+	if err != nil {
+		return fmt.Errorf("监听 UDP 失败: %w", err)
+	}
+	defer pc.Close()
+	ln, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return fmt.Errorf("监听 TCP 失败: %w", err)
+	}
+	defer ln.Close()
+
+	s.logger.Info("DNS 服务启动")
+	errCh := make(chan error, 2)
+	go func() { errCh <- s.serveUDP(pc) }()
+	go func() { errCh <- s.serveTCP(ln) }()
+	return <-errCh
+}
+
+func (s *Server) serveUDP(pc net.PacketConn) error {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return fmt.Errorf("读取 UDP 查询失败: %w", err)
+		}
+		query := append([]byte(nil), buf[:n]...)
+		go func() {
+			resp := s.Resolve(query)
+			if resp == nil {
+				return
+			}
+			resp = truncateUDP(query, resp)
+			if _, err := pc.WriteTo(resp, addr); err != nil {
+				s.logger.WithError(err).Debug("发送 UDP 响应失败")
+			}
+		}()
+	}
+}
+
+func (s *Server) serveTCP(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return fmt.Errorf("接受 TCP 连接失败: %w", err)
+		}
+		go func() {
+			defer conn.Close()
+			for {
+				conn.SetDeadline(time.Now().Add(exchangeTimeout * 2))
+				query, err := readTCPMessage(conn)
+				if err != nil {
+					return
+				}
+				resp := s.Resolve(query)
+				if resp == nil {
+					return
+				}
+				if err := writeTCPMessage(conn, resp); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// Resolve 处理一个查询报文并返回响应报文，查询无法解析时返回 nil
+func (s *Server) Resolve(query []byte) []byte {
+	var req dnsmessage.Message
+	if err := req.Unpack(query); err != nil {
+		var p dnsmessage.Parser
+		h, herr := p.Start(query)
+		if herr != nil {
+			s.logger.WithError(err).Debug("丢弃无法解析的查询")
+			return nil
+		}
+		return s.reply(&dnsmessage.Message{Header: h}, dnsmessage.RCodeFormatError)
+	}
+	if len(req.Questions) != 1 {
+		return s.reply(&req, dnsmessage.RCodeFormatError)
+	}
+
+	q := req.Questions[0]
+	logger := s.logger.WithFields(logrus.Fields{
+		"name": q.Name.String(),
+		"type": q.Type,
+	})
+
+	rules := s.rules.Load()
+	rule, action := rules.router.Match(&route.Metadata{Host: q.Name.String()})
+	if rule != nil {
+		logger = logger.WithField("rule", rule.Raw)
+	}
+	upstream := s.Upstream
+	switch {
+	case action == route.ActionReject:
+		logger.Debug("DNS 查询被规则拒绝")
+		return s.reply(&req, dnsmessage.RCodeNameError)
+	case rules.upstreams[action] != nil:
+		upstream = rules.upstreams[action]
+	case s.FakeIP != nil && (q.Type == dnsmessage.TypeA || q.Type == dnsmessage.TypeAAAA):
+		return s.fakeReply(logger, &req)
+	}
+
+	if resp, ok := s.cache.Get(q); ok {
+		logger.Debug("命中 DNS 缓存")
+		resp.ID = req.ID
+		resp.RecursionDesired = req.RecursionDesired
+		return s.pack(resp)
+	}
+	logger = logger.WithField("upstream", upstream.String())
+
+	ctx, cancel := context.WithTimeout(context.Background(), exchangeTimeout)
+	defer cancel()
+	start := time.Now()
+	raw, err := upstream.Exchange(ctx, query)
+	if s.OnExchange != nil {
+		s.OnExchange(time.Since(start), err)
+	}
+	if err != nil {
+		logger.WithError(err).Warn("上游查询失败")
+		return s.reply(&req, dnsmessage.RCodeServerFailure)
+	}
+
+	var resp dnsmessage.Message
+	if err := resp.Unpack(raw); err != nil || resp.ID != req.ID || !resp.Response {
+		if err == nil {
+			err = errors.New("响应与查询不匹配")
+		}
+		logger.WithError(err).Warn("上游响应无效")
+		return s.reply(&req, dnsmessage.RCodeServerFailure)
+	}
+	s.cache.Set(q, &resp)
+	logger.WithField("rcode", resp.RCode).Debug("DNS 查询完成")
+	return raw
+}
+
+// fakeReply 用 Fake-IP 地址应答 A/AAAA 查询，未配置 IPv6 网段时 AAAA 查询返回空应答
+func (s *Server) fakeReply(logger *logrus.Entry, req *dnsmessage.Message) []byte {
+	q := req.Questions[0]
+	ip := s.FakeIP.Lookup(q.Name.String(), q.Type == dnsmessage.TypeAAAA)
+
+	msg := &dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:                 req.ID,
+			Response:           true,
+			RecursionDesired:   req.RecursionDesired,
+			RecursionAvailable: true,
+		},
+		Questions: req.Questions,
+	}
+	if ip != nil {
+		rr := dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: q.Name, Type: q.Type, Class: q.Class, TTL: fakeIPTTL},
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			rr.Body = &dnsmessage.AResource{A: [4]byte(ip4)}
+		} else {
+			rr.Body = &dnsmessage.AAAAResource{AAAA: [16]byte(ip.To16())}
+		}
+		msg.Answers = []dnsmessage.Resource{rr}
+	}
+	logger.WithField("fakeIP", ip).Debug("返回 Fake-IP")
+	return s.pack(msg)
+}
+
+// reply 构造一个只包含问题段的响应
+func (s *Server) reply(req *dnsmessage.Message, rcode dnsmessage.RCode) []byte {
+	return s.pack(&dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:                 req.ID,
+			Response:           true,
+			OpCode:             req.OpCode,
+			RecursionDesired:   req.RecursionDesired,
+			RecursionAvailable: true,
+			RCode:              rcode,
+		},
+		Questions: req.Questions,
+	})
+}
+
+func (s *Server) pack(msg *dnsmessage.Message) []byte {
+	buf, err := msg.Pack()
+	if err != nil {
+		s.logger.WithError(err).Warn("打包 DNS 响应失败")
+		return nil
+	}
+	return buf
+}
+
+// truncateUDP 响应超过客户端可接收的长度时，只返回带 TC 标志的头部与问题段，客户端会改用 TCP 重试
+func truncateUDP(query, resp []byte) []byte {
+	size := minUDPSize
+	var req dnsmessage.Message
+	if err := req.Unpack(query); err == nil {
+		for _, rr := range req.Additionals {
+			if rr.Header.Type == dnsmessage.TypeOPT && int(rr.Header.Class) > size {
+				size = int(rr.Header.Class)
+			}
+		}
+	}
+	if len(resp) <= size {
+		return resp
+	}
+
+	var p dnsmessage.Parser
+	h, err := p.Start(resp)
+	if err != nil {
+		return resp
+	}
+	questions, _ := p.AllQuestions()
+	h.Truncated = true
+	msg := dnsmessage.Message{Header: h, Questions: questions}
+	if buf, err := msg.Pack(); err == nil {
+		return buf
+	}
+	return resp
+}
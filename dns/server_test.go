@@ -0,0 +1,206 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// fakeUpstream 对 A 查询返回 1.2.3.4，对 nx. 结尾的域名返回 NXDOMAIN
+type fakeUpstream struct {
+	name  string
+	calls atomic.Int32
+}
+
+func (u *fakeUpstream) Exchange(_ context.Context, query []byte) ([]byte, error) {
+	u.calls.Add(1)
+	var req dnsmessage.Message
+	if err := req.Unpack(query); err != nil {
+		return nil, err
+	}
+	resp := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: req.ID, Response: true, RecursionAvailable: true},
+		Questions: req.Questions,
+	}
+	q := req.Questions[0]
+	if strings.HasSuffix(q.Name.String(), ".nx.") {
+		resp.RCode = dnsmessage.RCodeNameError
+		resp.Authorities = []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName("nx."), Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET, TTL: 300},
+			Body: &dnsmessage.SOAResource{
+				NS:     dnsmessage.MustNewName("ns.nx."),
+				MBox:   dnsmessage.MustNewName("admin.nx."),
+				MinTTL: 30,
+			},
+		}}
+	} else {
+		resp.Answers = []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 120},
+			Body:   &dnsmessage.AResource{A: [4]byte{1, 2, 3, 4}},
+		}}
+	}
+	return resp.Pack()
+}
+
+func (u *fakeUpstream) String() string {
+	return u.name
+}
+
+func newQuery(t *testing.T, id uint16, name string, typ dnsmessage.Type) []byte {
+	t.Helper()
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{
+			Name:  dnsmessage.MustNewName(name),
+			Type:  typ,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	buf, err := msg.Pack()
+	require.NoError(t, err)
+	return buf
+}
+
+func unpack(t *testing.T, buf []byte) *dnsmessage.Message {
+	t.Helper()
+	var msg dnsmessage.Message
+	require.NoError(t, msg.Unpack(buf))
+	return &msg
+}
+
+func TestServer_Cache(t *testing.T) {
+	upstream := &fakeUpstream{name: "tunnel"}
+	cache := NewCache(0, 0)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	s := NewServer("127.0.0.1:0", upstream, cache)
+	exchanges := 0
+	s.OnExchange = func(d time.Duration, err error) {
+		assert.NoError(t, err)
+		exchanges++
+	}
+
+	resp := unpack(t, s.Resolve(newQuery(t, 1, "example.com.", dnsmessage.TypeA)))
+	assert.Equal(t, uint16(1), resp.ID)
+	require.Len(t, resp.Answers, 1)
+	assert.Equal(t, uint32(120), resp.Answers[0].Header.TTL)
+
+	// 命中缓存时不再访问上游，TTL 按经过时间递减，ID 与新查询一致
+	now = now.Add(20 * time.Second)
+	resp = unpack(t, s.Resolve(newQuery(t, 2, "EXAMPLE.com.", dnsmessage.TypeA)))
+	assert.Equal(t, uint16(2), resp.ID)
+	assert.Equal(t, uint32(100), resp.Answers[0].Header.TTL)
+	assert.Equal(t, int32(1), upstream.calls.Load())
+
+	// 过期后重新查询
+	now = now.Add(101 * time.Second)
+	s.Resolve(newQuery(t, 3, "example.com.", dnsmessage.TypeA))
+	assert.Equal(t, int32(2), upstream.calls.Load())
+	assert.Equal(t, 2, exchanges, "命中缓存时不统计上游查询")
+}
+
+func TestServer_NegativeCache(t *testing.T) {
+	upstream := &fakeUpstream{name: "tunnel"}
+	cache := NewCache(0, 0)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	s := NewServer("127.0.0.1:0", upstream, cache)
+
+	resp := unpack(t, s.Resolve(newQuery(t, 1, "missing.nx.", dnsmessage.TypeA)))
+	assert.Equal(t, dnsmessage.RCodeNameError, resp.RCode)
+
+	// 负缓存时长取 SOA 的 MINIMUM(30s)
+	now = now.Add(29 * time.Second)
+	resp = unpack(t, s.Resolve(newQuery(t, 2, "missing.nx.", dnsmessage.TypeA)))
+	assert.Equal(t, dnsmessage.RCodeNameError, resp.RCode)
+	assert.Equal(t, int32(1), upstream.calls.Load())
+
+	now = now.Add(2 * time.Second)
+	s.Resolve(newQuery(t, 3, "missing.nx.", dnsmessage.TypeA))
+	assert.Equal(t, int32(2), upstream.calls.Load())
+}
+
+func TestServer_SplitRules(t *testing.T) {
+	tunnel := &fakeUpstream{name: "tunnel"}
+	s := NewServer("127.0.0.1:0", tunnel, NewCache(0, 0))
+
+	lan := &fakeUpstream{name: "lan"}
+	require.NoError(t, s.SetRules([]string{
+		"domain-suffix:ads.com -> reject",
+		"domain-suffix:lan -> 192.168.1.1:53",
+	}, nil))
+	// 用假上游替换规则生成的 UDP 上游
+	s.rules.Load().upstreams["192.168.1.1:53"] = lan
+
+	resp := unpack(t, s.Resolve(newQuery(t, 1, "x.ads.com.", dnsmessage.TypeA)))
+	assert.Equal(t, dnsmessage.RCodeNameError, resp.RCode)
+
+	s.Resolve(newQuery(t, 2, "nas.lan.", dnsmessage.TypeA))
+	assert.Equal(t, int32(1), lan.calls.Load())
+	assert.Equal(t, int32(0), tunnel.calls.Load())
+
+	s.Resolve(newQuery(t, 3, "example.com.", dnsmessage.TypeA))
+	assert.Equal(t, int32(1), tunnel.calls.Load())
+
+	// 重新加载规则后清空缓存，按新规则查询
+	require.NoError(t, s.SetRules(nil, nil))
+	s.Resolve(newQuery(t, 4, "nas.lan.", dnsmessage.TypeA))
+	assert.Equal(t, int32(1), lan.calls.Load())
+	assert.Equal(t, int32(2), tunnel.calls.Load())
+
+	assert.Error(t, s.SetRules([]string{"domain-suffix:lan -> direct"}, nil))
+	assert.Error(t, s.SetRules([]string{"domain-suffix:lan -> 192.168.1.1"}, nil))
+}
+
+func TestCache_Evict(t *testing.T) {
+	cache := NewCache(2, 0)
+	answer := func(name string) (dnsmessage.Question, *dnsmessage.Message) {
+		q := dnsmessage.Question{Name: dnsmessage.MustNewName(name), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}
+		return q, &dnsmessage.Message{
+			Header:    dnsmessage.Header{Response: true},
+			Questions: []dnsmessage.Question{q},
+			Answers: []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+				Body:   &dnsmessage.AResource{A: [4]byte{1, 1, 1, 1}},
+			}},
+		}
+	}
+
+	qa, ma := answer("a.com.")
+	qb, mb := answer("b.com.")
+	qc, mc := answer("c.com.")
+	cache.Set(qa, ma)
+	cache.Set(qb, mb)
+	_, ok := cache.Get(qa) // a 变为最近使用
+	assert.True(t, ok)
+	cache.Set(qc, mc)
+
+	assert.Equal(t, 2, cache.Len())
+	_, ok = cache.Get(qb)
+	assert.False(t, ok, "最久未使用的条目应被淘汰")
+	_, ok = cache.Get(qa)
+	assert.True(t, ok)
+}
+
+func TestTCPUpstream(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	s := NewServer(ln.Addr().String(), &fakeUpstream{name: "tunnel"}, NewCache(0, 0))
+	go s.serveTCP(ln)
+
+	upstream := &TCPUpstream{Addr: ln.Addr().String()}
+	raw, err := upstream.Exchange(context.Background(), newQuery(t, 7, "example.com.", dnsmessage.TypeA))
+	require.NoError(t, err)
+	resp := unpack(t, raw)
+	assert.Equal(t, uint16(7), resp.ID)
+	assert.Len(t, resp.Answers, 1)
+}
@@ -0,0 +1,261 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// exchangeTimeout 是单次上游查询的超时时间
+const exchangeTimeout = 5 * time.Second
+
+// Upstream 将 DNS 查询报文转发给上游解析器并返回响应报文
+type Upstream interface {
+	Exchange(ctx context.Context, query []byte) ([]byte, error)
+	String() string
+}
+
+// DialFunc 建立到 addr 的 TCP 连接
+type DialFunc func(addr string) (net.Conn, error)
+
+// TCPUpstream 以 DNS over TCP 方式查询上游，连接由 Dial 建立。
+// 配合 LsLocal.DialProxy 使用时，查询经由加密隧道发送，由服务端所在网络访问上游解析器
+type TCPUpstream struct {
+	Addr string
+	Dial DialFunc
+}
+
+// Exchange 实现 Upstream 接口
+func (u *TCPUpstream) Exchange(ctx context.Context, query []byte) ([]byte, error) {
+	dial := u.Dial
+	if dial == nil {
+		dial = func(addr string) (net.Conn, error) {
+			return net.DialTimeout("tcp", addr, exchangeTimeout)
+		}
+	}
+	conn, err := dial(u.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("连接上游 %s 失败: %w", u.Addr, err)
+	}
+	defer conn.Close()
+
+	setDeadline(ctx, conn)
+	if err := writeTCPMessage(conn, query); err != nil {
+		return nil, err
+	}
+	return readTCPMessage(conn)
+}
+
+func (u *TCPUpstream) String() string {
+	return "tcp://" + u.Addr
+}
+
+// UDPUpstream 直接通过 UDP 查询上游，响应被截断时改用 TCP 重试
+type UDPUpstream struct {
+	Addr string
+}
+
+// Exchange 实现 Upstream 接口
+func (u *UDPUpstream) Exchange(ctx context.Context, query []byte) ([]byte, error) {
+	conn, err := net.Dial("udp", u.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("连接上游 %s 失败: %w", u.Addr, err)
+	}
+	defer conn.Close()
+
+	setDeadline(ctx, conn)
+	if _, err := conn.Write(query); err != nil {
+		return nil, fmt.Errorf("发送查询失败: %w", err)
+	}
+
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, fmt.Errorf("读取响应失败: %w", err)
+		}
+		// 忽略 ID 不匹配的报文，防止伪造响应
+		if n < 12 || binary.BigEndian.Uint16(buf) != binary.BigEndian.Uint16(query) {
+			continue
+		}
+		if buf[2]&0x02 != 0 {
+			return (&TCPUpstream{Addr: u.Addr}).Exchange(ctx, query)
+		}
+		return append([]byte(nil), buf[:n]...), nil
+	}
+}
+
+func (u *UDPUpstream) String() string {
+	return "udp://" + u.Addr
+}
+
+// TLSUpstream 以 DNS over TLS（RFC 7858）方式查询上游
+type TLSUpstream struct {
+	Addr      string      // 上游地址，例如 1.1.1.1:853
+	TLSConfig *tls.Config // 为空时使用默认配置，并以 Addr 中的主机名作为 SNI 校验证书
+}
+
+// Exchange 实现 Upstream 接口
+func (u *TLSUpstream) Exchange(ctx context.Context, query []byte) ([]byte, error) {
+	config := u.TLSConfig
+	if config == nil {
+		host, _, err := net.SplitHostPort(u.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("解析上游地址 %s 失败: %w", u.Addr, err)
+		}
+		config = &tls.Config{ServerName: host}
+	}
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: exchangeTimeout},
+		Config:    config,
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", u.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("连接上游 %s 失败: %w", u.Addr, err)
+	}
+	defer conn.Close()
+
+	setDeadline(ctx, conn)
+	if err := writeTCPMessage(conn, query); err != nil {
+		return nil, err
+	}
+	return readTCPMessage(conn)
+}
+
+func (u *TLSUpstream) String() string {
+	return "tls://" + u.Addr
+}
+
+// HTTPSUpstream 以 DNS over HTTPS（RFC 8484）方式查询上游，使用 POST 方法
+type HTTPSUpstream struct {
+	URL    string       // 查询地址，例如 https://1.1.1.1/dns-query
+	Client *http.Client // 为空时使用 http.DefaultClient
+}
+
+// Exchange 实现 Upstream 接口
+func (u *HTTPSUpstream) Exchange(ctx context.Context, query []byte) ([]byte, error) {
+	if len(query) < 12 {
+		return nil, errors.New("DNS 报文过短")
+	}
+	// RFC 8484 建议 ID 置 0 以便 HTTP 缓存，收到响应后再还原
+	id := binary.BigEndian.Uint16(query)
+	body := append([]byte(nil), query...)
+	binary.BigEndian.PutUint16(body, 0)
+
+	ctx, cancel := context.WithTimeout(ctx, exchangeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("创建 DoH 请求失败: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	client := u.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("DoH 请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DoH 服务返回状态码 %d", resp.StatusCode)
+	}
+
+	msg, err := io.ReadAll(io.LimitReader(resp.Body, 65535))
+	if err != nil {
+		return nil, fmt.Errorf("读取 DoH 响应失败: %w", err)
+	}
+	if len(msg) < 12 {
+		return nil, errors.New("DoH 响应过短")
+	}
+	binary.BigEndian.PutUint16(msg, id)
+	return msg, nil
+}
+
+func (u *HTTPSUpstream) String() string {
+	return u.URL
+}
+
+// ParseUpstream 根据地址创建上游，支持以下格式：
+//   - 8.8.8.8 或 8.8.8.8:53 或 udp://8.8.8.8:53：UDP
+//   - tcp://8.8.8.8:53：TCP
+//   - tls://1.1.1.1 或 tls://1.1.1.1:853：DNS over TLS
+//   - https://1.1.1.1/dns-query：DNS over HTTPS
+func ParseUpstream(addr string) (Upstream, error) {
+	if strings.HasPrefix(addr, "https://") {
+		if _, err := url.Parse(addr); err != nil {
+			return nil, fmt.Errorf("无效的 DoH 地址 %q: %w", addr, err)
+		}
+		return &HTTPSUpstream{URL: addr}, nil
+	}
+
+	scheme, hostport, ok := strings.Cut(addr, "://")
+	if !ok {
+		scheme, hostport = "udp", addr
+	}
+	defaultPort := "53"
+	if scheme == "tls" {
+		defaultPort = "853"
+	}
+	if _, _, err := net.SplitHostPort(hostport); err != nil {
+		hostport = net.JoinHostPort(strings.Trim(hostport, "[]"), defaultPort)
+	}
+
+	switch scheme {
+	case "udp":
+		return &UDPUpstream{Addr: hostport}, nil
+	case "tcp":
+		return &TCPUpstream{Addr: hostport}, nil
+	case "tls":
+		return &TLSUpstream{Addr: hostport}, nil
+	default:
+		return nil, fmt.Errorf("不支持的上游协议 %q", scheme)
+	}
+}
+
+func setDeadline(ctx context.Context, conn net.Conn) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(exchangeTimeout)
+	}
+	conn.SetDeadline(deadline)
+}
+
+// writeTCPMessage 按 DNS over TCP 格式写入带两字节长度前缀的报文
+func writeTCPMessage(w io.Writer, msg []byte) error {
+	if len(msg) > 65535 {
+		return errors.New("DNS 报文过长")
+	}
+	buf := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(buf, uint16(len(msg)))
+	copy(buf[2:], msg)
+	if _, err := w.Write(buf); err != nil {
+		return fmt.Errorf("发送 DNS 报文失败: %w", err)
+	}
+	return nil
+}
+
+// readTCPMessage 按 DNS over TCP 格式读取一个报文
+func readTCPMessage(r io.Reader) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, fmt.Errorf("读取 DNS 报文长度失败: %w", err)
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, fmt.Errorf("读取 DNS 报文失败: %w", err)
+	}
+	return msg, nil
+}
@@ -1,11 +1,16 @@
 package local
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"strconv"
 	"time"
 
 	"github.com/beijian128/minisocks/core"
+	"github.com/beijian128/minisocks/dns"
+	"github.com/beijian128/minisocks/route"
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 )
@@ -15,31 +20,74 @@ type LsLocal struct {
 	*core.SecureSocket      // 嵌入 SecureSocket 结构体，用于数据的加密和解密传输
 	running            bool // 标识本地代理服务是否正在运行
 	logger             *logrus.Entry
+	router             *route.Router                 // 路由规则，决定每个连接直连、代理还是拒绝
+	servers            map[string]*core.SecureSocket // 具名远程服务器，供路由规则引用
+	conns              *core.ConnTable               // 正在转发的连接
+	metrics            *localMetrics                 // Prometheus 指标
 	// AfterListen 是一个回调函数，在本地代理开始监听后被调用，传入监听地址
 	AfterListen func(listenAddr net.Addr)
+	// FakeIP 不为空时，目标为假地址的连接会还原为对应的域名后再路由与转发
+	FakeIP *dns.FakeIP
 }
 
 // New 新建一个本地端实例
-func New(secret string, localAddr, serverAddr *net.TCPAddr) *LsLocal {
+func New(secret string, localAddr, serverAddr net.Addr) *LsLocal {
 	logger := logrus.WithFields(logrus.Fields{
 		"component":  "LsLocal",
-		"localAddr":  localAddr.String(),
-		"serverAddr": serverAddr.String(),
+		"localAddr":  localAddr,
+		"serverAddr": serverAddr,
 	})
 	logger.Debug("创建新的本地代理实例")
 
 	ci, _ := core.NewSimple(secret)
+	router, _ := route.New(nil, nil)
 	return &LsLocal{
 		SecureSocket: core.NewSecureSocket(ci, localAddr, serverAddr),
 		logger:       logger,
+		router:       router,
+		servers:      make(map[string]*core.SecureSocket),
+		conns:        core.NewConnTable(),
+		metrics:      newLocalMetrics(),
 	}
 }
 
+// AddServer 注册一个具名远程服务器，路由规则可以通过名称将连接转发到该服务器。
+// 返回的 SecureSocket 可用于进一步配置，例如设置 TLSConfig
+func (l *LsLocal) AddServer(name, secret string, serverAddr net.Addr) *core.SecureSocket {
+	l.logger.WithFields(logrus.Fields{
+		"name":       name,
+		"serverAddr": serverAddr,
+	}).Debug("注册具名服务器")
+
+	ci, _ := core.NewSimple(secret)
+	ss := core.NewSecureSocket(ci, l.LocalAddr, serverAddr)
+	l.servers[name] = ss
+	return ss
+}
+
+// SetRules 校验并加载路由规则，可在运行期间调用以重新加载规则
+// geo 为 geoip 与 geosite 规则提供数据，不使用这两类规则时可以为 nil
+func (l *LsLocal) SetRules(rules []string, geo *route.Geo) error {
+	parsed, err := route.ParseRules(rules, geo)
+	if err != nil {
+		return err
+	}
+	for _, rule := range parsed {
+		if rule.Action.IsServer() && l.servers[string(rule.Action)] == nil {
+			return fmt.Errorf("规则 %q 引用了未配置的服务器 %q", rule.Raw, rule.Action)
+		}
+	}
+
+	l.router.Reload(parsed)
+	l.logger.WithField("rules", len(parsed)).Info("路由规则已加载")
+	return nil
+}
+
 // Listen 本地端启动监听，等待本地浏览器的代理请求
 func (l *LsLocal) Listen() error {
 	l.logger.Info("开始监听本地地址")
 
-	listener, err := net.ListenTCP("tcp", l.LocalAddr)
+	listener, err := net.Listen(l.LocalAddr.Network(), l.LocalAddr.String())
 	if err != nil {
 		l.logger.WithError(err).Error("监听失败")
 		return fmt.Errorf("监听失败: %w", err)
@@ -55,14 +103,14 @@ func (l *LsLocal) Listen() error {
 
 	for l.running {
 		l.logger.Debug("等待新连接")
-		userConn, err := listener.AcceptTCP()
+		userConn, err := listener.Accept()
 		if err != nil {
 			l.logger.WithError(err).Warn("接受连接失败")
 			continue
 		}
 
 		l.logger.WithField("remoteAddr", userConn.RemoteAddr()).Debug("接受新连接")
-		userConn.SetLinger(0)
+		userConn.(*net.TCPConn).SetLinger(0)
 		go l.handleConn(userConn)
 	}
 
@@ -76,45 +124,275 @@ func (l *LsLocal) Close() {
 	l.SecureSocket = nil
 }
 
-// handleConn 处理与用户浏览器建立的 TCP 连接
-func (l *LsLocal) handleConn(userConn *net.TCPConn) {
+// Route 返回连接命中的规则与动作，没有规则命中时规则为 nil
+func (l *LsLocal) Route(meta *route.Metadata) (*route.Rule, route.Action) {
+	return l.router.Match(meta)
+}
+
+// handleConn 处理与用户浏览器建立的连接
+func (l *LsLocal) handleConn(userConn net.Conn) {
+	start := time.Now()
 	connID := uuid.New().String()
 	logger := l.logger.WithFields(logrus.Fields{
 		"connID":     connID,
 		"remoteAddr": userConn.RemoteAddr(),
 	})
 	logger.Debug("开始处理连接")
+	l.metrics.accepted.Inc()
+	l.metrics.active.Inc()
+	defer l.metrics.active.Dec()
 
 	defer func() {
-		if err := userConn.Close(); err != nil {
+		if err := userConn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
 			logger.WithError(err).Warn("关闭用户连接失败")
 		}
 		logger.Debug("连接处理完成")
 	}()
 
+	// 处理 SOCKS5 协商与请求，获取目标地址
+	if err := readGreeting(userConn); err != nil {
+		logger.WithError(err).Error("协商失败")
+		l.metrics.handshakeFailures.Inc("socks")
+		return
+	}
+	req, err := readRequest(userConn)
+	if err != nil {
+		logger.WithError(err).Error("请求处理失败")
+		l.metrics.handshakeFailures.Inc("socks")
+		return
+	}
+
+	// 目标为 Fake-IP 时还原为原始域名，交由服务端解析
+	if req.ip != nil && l.FakeIP != nil && l.FakeIP.Contains(req.ip) {
+		domain, ok := l.FakeIP.Domain(req.ip)
+		if !ok {
+			logger.WithField("fakeIP", req.ip).Warn("Fake-IP 没有对应的域名，映射可能已被淘汰")
+			writeReply(userConn, repHostUnreachable)
+			return
+		}
+		if req, err = newSocksRequest(net.JoinHostPort(domain, strconv.Itoa(req.port))); err != nil {
+			logger.WithError(err).Error("还原 Fake-IP 域名失败")
+			writeReply(userConn, repGeneralFailure)
+			return
+		}
+	}
+
+	// 根据路由规则决定连接去向
+	meta := &route.Metadata{Host: req.host, DstIP: req.ip, DstPort: req.port}
+	if addr, ok := userConn.RemoteAddr().(*net.TCPAddr); ok {
+		meta.SrcIP = addr.IP
+	}
+	rule, action := l.Route(meta)
+	logger = logger.WithFields(logrus.Fields{
+		"target": req.addr(),
+		"action": action,
+	})
+	if rule != nil {
+		logger = logger.WithField("rule", rule.Raw)
+	}
+	logger.Debug("路由匹配完成")
+
+	if action == route.ActionReject {
+		logger.Info("连接被路由规则拒绝")
+		writeReply(userConn, repNotAllowed)
+		return
+	}
+
+	// 登记到连接表，连接服务端与目标的过程也计入持续时间
+	tracked := l.conns.Add(core.ConnInfo{
+		ID:     connID,
+		Route:  string(action),
+		Source: userConn.RemoteAddr().String(),
+		Target: req.addr(),
+		Start:  start,
+	}, func() { userConn.Close() })
+	defer tracked.Remove()
+	up, down := l.metrics.byteCounters(string(action), tracked)
+
+	if action == route.ActionDirect {
+		l.handleDirect(logger, userConn, req, up, down)
+	} else {
+		ss := l.SecureSocket
+		if action.IsServer() {
+			ss = l.servers[string(action)]
+		}
+		if ss == nil {
+			logger.Error("路由规则引用了不存在的服务器")
+			writeReply(userConn, repGeneralFailure)
+			return
+		}
+		l.handleProxy(logger, string(action), ss, userConn, req, up, down)
+	}
+}
+
+// handleDirect 不经过远程服务端，直接连接目标地址，up 与 down 分别统计上行与下行字节数
+func (l *LsLocal) handleDirect(logger *logrus.Entry, userConn net.Conn, req *socksRequest, up, down core.Throttle) {
+	logger.Debug("直连目标地址")
+	start := time.Now()
+	dstConn, err := net.DialTimeout("tcp", req.addr(), core.TIMEOUT)
+	l.metrics.observeDial(string(route.ActionDirect), start, err)
+	if err != nil {
+		logger.WithError(err).Error("直连目标地址失败")
+		writeReply(userConn, repHostUnreachable)
+		return
+	}
+	defer dstConn.Close()
+
+	dstConn.(*net.TCPConn).SetLinger(0)
+	if err := writeReply(userConn, repSucceeded); err != nil {
+		logger.WithError(err).Error("发送响应失败")
+		return
+	}
+
+	// 目标连接在 core.TIMEOUT 内没有任何读写时超时，活跃的长连接不受影响
+	target := idleConn{Conn: dstConn, timeout: core.TIMEOUT}
+	go func() {
+		if _, err := io.Copy(countingWriter{target, up}, userConn); err != nil {
+			logger.WithError(err).Debug("直连上行转发结束")
+			// 用户连接出错或被连接表中断，关闭目标连接使下行转发随之结束
+			dstConn.Close()
+			return
+		}
+		// 用户已经发送完毕，向目标发送 FIN，目标随后关闭连接使下行转发结束
+		dstConn.(*net.TCPConn).CloseWrite()
+	}()
+	if _, err := io.Copy(countingWriter{userConn, down}, target); err != nil {
+		logger.WithError(err).Debug("直连下行转发结束")
+	}
+	logger.Debug("直连转发完成")
+}
+
+// idleConn 在每次读写前将连接的截止时间推迟 timeout，即空闲超过 timeout 时读写失败
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c idleConn) Read(b []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Read(b)
+}
+
+func (c idleConn) Write(b []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Write(b)
+}
+
+// countingWriter 在每次写入后将写入的字节数交给 counter
+type countingWriter struct {
+	w       io.Writer
+	counter core.Throttle
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.counter.Wait(n)
+	return n, err
+}
+
+// handleProxy 经 name 对应的远程服务端转发连接，up 与 down 分别统计上行与下行字节数
+func (l *LsLocal) handleProxy(logger *logrus.Entry, name string, ss *core.SecureSocket, userConn net.Conn, req *socksRequest, up, down core.Throttle) {
 	// 连接远程服务端
 	logger.Debug("连接远程服务端")
-	server, err := l.DialServer()
+	start := time.Now()
+	server, err := ss.DialServer()
+	l.metrics.observeDial(name, start, err)
 	if err != nil {
 		logger.WithError(err).Error("连接服务端失败")
+		writeReply(userConn, repGeneralFailure)
 		return
 	}
 	defer func() {
-		if err := server.Close(); err != nil {
+		if err := server.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
 			logger.WithError(err).Warn("关闭服务端连接失败")
 		}
 	}()
 
-	server.SetLinger(0)
+	if tcpConn, ok := server.(*net.TCPConn); ok {
+		tcpConn.SetLinger(0)
+	}
 	if err := server.SetDeadline(time.Now().Add(core.TIMEOUT)); err != nil {
 		logger.WithError(err).Warn("设置截止时间失败")
 	}
 
-	// 启动数据转发
-	l.startForwarding(logger, userConn, server)
+	if err := serverHandshake(ss, server, req); err != nil {
+		logger.WithError(err).Error("与服务端握手失败")
+		l.metrics.handshakeFailures.Inc("server")
+		l.metrics.cipherError(err)
+		writeReply(userConn, repGeneralFailure)
+		return
+	}
+
+	// 启动数据转发，服务端对请求的响应会随解密转发回到浏览器
+	l.startForwarding(logger, ss, userConn, server, up, down)
+}
+
+// serverHandshake 代替浏览器与服务端完成 SOCKS5 协商并发送原始请求
+func serverHandshake(ss *core.SecureSocket, server net.Conn, req *socksRequest) error {
+	greeting, err := ss.Cipher.Encrypt([]byte{socksVersion, 0x01, 0x00})
+	if err != nil {
+		return fmt.Errorf("加密协商数据失败: %w", err)
+	}
+	if _, err := server.Write(greeting); err != nil {
+		return fmt.Errorf("发送协商数据失败: %w", err)
+	}
+
+	resp := make([]byte, 2)
+	if _, err := io.ReadFull(server, resp); err != nil {
+		return fmt.Errorf("读取协商响应失败: %w", err)
+	}
+	resp, err = ss.Cipher.Decrypt(resp)
+	if err != nil {
+		return &core.CipherError{Op: "解密协商响应", Err: err}
+	}
+	if resp[0] != socksVersion || resp[1] != 0x00 {
+		return fmt.Errorf("服务端拒绝协商: % x", resp)
+	}
+
+	request, err := ss.Cipher.Encrypt(append([]byte(nil), req.raw...))
+	if err != nil {
+		return fmt.Errorf("加密请求数据失败: %w", err)
+	}
+	if _, err := server.Write(request); err != nil {
+		return fmt.Errorf("发送请求数据失败: %w", err)
+	}
+	return nil
+}
+
+// DialProxy 经默认远程服务端建立到 addr 的隧道连接，返回的连接读写的都是明文
+func (l *LsLocal) DialProxy(addr string) (net.Conn, error) {
+	req, err := newSocksRequest(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ss := l.SecureSocket
+	server, err := ss.DialServer()
+	if err != nil {
+		return nil, err
+	}
+	if err := server.SetDeadline(time.Now().Add(core.TIMEOUT)); err != nil {
+		l.logger.WithError(err).Warn("设置截止时间失败")
+	}
+	if err := serverHandshake(ss, server, req); err != nil {
+		server.Close()
+		return nil, err
+	}
+
+	conn := core.NewCipherConn(server, ss.Cipher)
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("读取服务端响应失败: %w", err)
+	}
+	if reply[1] != repSucceeded {
+		conn.Close()
+		return nil, fmt.Errorf("服务端连接 %s 失败，响应码 0x%x", addr, reply[1])
+	}
+	return conn, nil
 }
 
-func (l *LsLocal) startForwarding(logger *logrus.Entry, userConn, server *net.TCPConn) {
+func (l *LsLocal) startForwarding(logger *logrus.Entry, ss *core.SecureSocket, userConn net.Conn, server net.Conn, up, down core.Throttle) {
 	logger.WithFields(logrus.Fields{
 		"userAddr":   userConn.RemoteAddr(),
 		"serverAddr": server.RemoteAddr(),
@@ -122,14 +400,18 @@ func (l *LsLocal) startForwarding(logger *logrus.Entry, userConn, server *net.TC
 
 	// 启动加密转发协程
 	go func() {
-		if err := l.EncodeCopy(server, userConn); err != nil {
+		if err := ss.EncodeCopy(server, userConn, up); err != nil {
 			logger.WithError(err).Debug("加密转发结束")
+			l.metrics.cipherError(err)
+			// 用户连接出错或被连接表中断，关闭服务端连接使解密转发随之结束
+			server.Close()
 		}
 	}()
 
 	// 执行解密转发
-	if err := l.DecodeCopy(userConn, server); err != nil {
+	if err := ss.DecodeCopy(userConn, server, down); err != nil {
 		logger.WithError(err).Debug("解密转发结束")
+		l.metrics.cipherError(err)
 	}
 
 	logger.Debug("数据转发完成")
@@ -0,0 +1,488 @@
+package local
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beijian128/minisocks/core"
+	"github.com/beijian128/minisocks/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startEcho 启动一个回显服务作为代理目标
+func startEcho(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// startServer 在随机端口启动服务端，允许访问本机地址，返回监听地址
+func startServer(t *testing.T, s *server.LsServer) *net.TCPAddr {
+	t.Helper()
+	s.Egress = nil
+	addrCh := make(chan net.Addr, 1)
+	s.AfterListen = func(addr net.Addr) { addrCh <- addr }
+	go s.Listen()
+	select {
+	case addr := <-addrCh:
+		return addr.(*net.TCPAddr)
+	case <-time.After(5 * time.Second):
+		t.Fatal("服务端启动超时")
+		return nil
+	}
+}
+
+// selfSignedCert 生成自签名证书，返回证书与私钥文件路径
+func selfSignedCert(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "minisocks"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile
+}
+
+// assertEcho 通过 DialProxy 建立隧道并校验回显
+func assertEcho(t *testing.T, l *LsLocal, target string) {
+	t.Helper()
+	conn, err := l.DialProxy(target)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("hello minisocks"))
+	require.NoError(t, err)
+	buf := make([]byte, len("hello minisocks"))
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hello minisocks", string(buf))
+}
+
+func TestDialProxy(t *testing.T) {
+	target := startEcho(t)
+	secret := core.GenerateCipherTable()
+	serverAddr := startServer(t, server.New(secret, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}))
+
+	assertEcho(t, New(secret, &net.TCPAddr{}, serverAddr), target)
+}
+
+func TestDialProxy_Obfs(t *testing.T) {
+	target := startEcho(t)
+	secret := core.GenerateCipherTable()
+	obfs := &core.ObfsOptions{PaddingRecords: 4, MaxPadding: 512, MaxRecord: 1024, MergeDelay: time.Millisecond}
+
+	s := server.New(secret, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	s.Obfs = obfs
+	serverAddr := startServer(t, s)
+
+	l := New(secret, &net.TCPAddr{}, serverAddr)
+	l.Obfs = obfs
+	assertEcho(t, l, target)
+
+	// 只有一端启用混淆时无法建立隧道
+	plain := server.New(secret, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	l = New(secret, &net.TCPAddr{}, startServer(t, plain))
+	l.Obfs = obfs
+	_, err := l.DialProxy(target)
+	assert.Error(t, err)
+}
+
+func TestDialProxy_HTTPObfs(t *testing.T) {
+	target := startEcho(t)
+	secret := core.GenerateCipherTable()
+
+	s := server.New(secret, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	s.HTTPObfs = &core.HTTPObfsOptions{}
+	serverAddr := startServer(t, s)
+
+	l := New(secret, &net.TCPAddr{}, serverAddr)
+	l.HTTPObfs = &core.HTTPObfsOptions{Host: "www.example.com"}
+	assertEcho(t, l, target)
+
+	// 与混淆记录叠加使用
+	s = server.New(secret, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	s.HTTPObfs = &core.HTTPObfsOptions{}
+	s.Obfs = &core.ObfsOptions{PaddingRecords: 4}
+	l = New(secret, &net.TCPAddr{}, startServer(t, s))
+	l.HTTPObfs = &core.HTTPObfsOptions{Host: "www.example.com"}
+	l.Obfs = &core.ObfsOptions{PaddingRecords: 4}
+	assertEcho(t, l, target)
+}
+
+func TestDialProxy_KeyExchange(t *testing.T) {
+	target := startEcho(t)
+	secret := core.GenerateCipherTable()
+
+	s := server.New(secret, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	s.KeyExchange = core.NewKeyExchange(secret)
+	serverAddr := startServer(t, s)
+
+	l := New(secret, &net.TCPAddr{}, serverAddr)
+	l.KeyExchange = core.NewKeyExchange(secret)
+	assertEcho(t, l, target)
+
+	// 与 HTTP 伪装、混淆记录叠加使用
+	s = server.New(secret, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	s.KeyExchange = core.NewKeyExchange(secret)
+	s.HTTPObfs = &core.HTTPObfsOptions{}
+	s.Obfs = &core.ObfsOptions{PaddingRecords: 4}
+	l = New(secret, &net.TCPAddr{}, startServer(t, s))
+	l.KeyExchange = core.NewKeyExchange(secret)
+	l.HTTPObfs = &core.HTTPObfsOptions{}
+	l.Obfs = &core.ObfsOptions{PaddingRecords: 4}
+	assertEcho(t, l, target)
+
+	// 密码不一致时密钥交换失败
+	l = New(secret, &net.TCPAddr{}, serverAddr)
+	l.KeyExchange = core.NewKeyExchange("other")
+	_, err := l.DialProxy(target)
+	assert.Error(t, err)
+}
+
+func TestDialProxy_Users(t *testing.T) {
+	target := startEcho(t)
+	aliceSecret, bobSecret := core.GenerateCipherTable(), core.GenerateCipherTable()
+	alice, err := server.NewUser("alice", aliceSecret)
+	require.NoError(t, err)
+	bob, err := server.NewUser("bob", bobSecret)
+	require.NoError(t, err)
+	users, err := server.NewUsers(alice, bob)
+	require.NoError(t, err)
+
+	s := server.New(core.GenerateCipherTable(), &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	s.Users = users
+	serverAddr := startServer(t, s)
+	assertEcho(t, New(aliceSecret, &net.TCPAddr{}, serverAddr), target)
+	assertEcho(t, New(bobSecret, &net.TCPAddr{}, serverAddr), target)
+
+	// 停用或删除的用户无法再建立连接
+	alice.SetDisabled(true)
+	_, err = New(aliceSecret, &net.TCPAddr{}, serverAddr).DialProxy(target)
+	assert.Error(t, err)
+	alice.SetDisabled(false)
+	users.Remove("bob")
+	_, err = New(bobSecret, &net.TCPAddr{}, serverAddr).DialProxy(target)
+	assert.Error(t, err)
+
+	// 与密钥交换、混淆记录叠加使用
+	s = server.New(core.GenerateCipherTable(), &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	s.Users = users
+	s.KeyExchange = core.NewKeyExchange("")
+	s.Obfs = &core.ObfsOptions{PaddingRecords: 4}
+	l := New(aliceSecret, &net.TCPAddr{}, startServer(t, s))
+	l.KeyExchange = core.NewKeyExchange(aliceSecret)
+	l.Obfs = &core.ObfsOptions{PaddingRecords: 4}
+	assertEcho(t, l, target)
+
+	s = server.New(core.GenerateCipherTable(), &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	s.Users = users
+	s.Obfs = &core.ObfsOptions{PaddingRecords: 4, MaxPadding: 4096}
+	l = New(aliceSecret, &net.TCPAddr{}, startServer(t, s))
+	l.Obfs = &core.ObfsOptions{PaddingRecords: 4, MaxPadding: 4096}
+	assertEcho(t, l, target)
+}
+
+func TestDialProxy_RateLimit(t *testing.T) {
+	target := startEcho(t)
+	secret := core.GenerateCipherTable()
+	s := server.New(secret, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	s.RateLimits = server.NewRateLimits(server.RateLimitOptions{Conn: server.Bandwidth{Down: 64 * 1024, Burst: 16 * 1024}})
+	l := New(secret, &net.TCPAddr{}, startServer(t, s))
+
+	conn, err := l.DialProxy(target)
+	require.NoError(t, err)
+	defer conn.Close()
+	data := make([]byte, 48*1024)
+	go conn.Write(data)
+	start := time.Now()
+	_, err = io.ReadFull(conn, data)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond, "下行被限速")
+}
+
+func TestDialProxy_Quota(t *testing.T) {
+	target := startEcho(t)
+	secret := core.GenerateCipherTable()
+	s := server.New(secret, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	quotas, err := server.NewQuotas(server.QuotaOptions{Daily: 1024})
+	require.NoError(t, err)
+	s.Quotas = quotas
+	l := New(secret, &net.TCPAddr{}, startServer(t, s))
+	assertEcho(t, l, target)
+
+	// 超出配额时中断转发
+	conn, err := l.DialProxy(target)
+	require.NoError(t, err)
+	defer conn.Close()
+	go conn.Write(make([]byte, 4096))
+	io.ReadAll(conn)
+	assert.True(t, quotas.Exceeded("127.0.0.1"))
+
+	// 配额用尽后拒绝新连接
+	_, err = l.DialProxy(target)
+	assert.Error(t, err)
+}
+
+func TestDialProxy_MaxConns(t *testing.T) {
+	target := startEcho(t)
+	secret := core.GenerateCipherTable()
+	s := server.New(secret, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	s.ConnLimits = server.NewConnLimits(server.ConnLimitOptions{PerIP: 1})
+	l := New(secret, &net.TCPAddr{}, startServer(t, s))
+
+	conn, err := l.DialProxy(target)
+	require.NoError(t, err)
+	_, err = l.DialProxy(target)
+	assert.Error(t, err, "来源 IP 的并发连接数达到上限")
+
+	conn.Close()
+	assert.Eventually(t, func() bool {
+		conn, err := l.DialProxy(target)
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond, "连接关闭后释放名额")
+}
+
+func TestDialProxy_Pipe(t *testing.T) {
+	target := startEcho(t)
+	secret := core.GenerateCipherTable()
+	certFile, keyFile := selfSignedCert(t)
+	transport := core.NewPipeTransport()
+
+	s := server.New(secret, nil)
+	s.Egress = nil
+	s.Transport = transport
+	done := make(chan error, 1)
+	go func() { done <- s.Listen() }()
+	l := New(secret, nil, nil)
+	l.Transport = transport
+	assertEcho(t, l, target)
+
+	// 关闭监听器后服务端退出
+	ln, err := transport.Listen()
+	require.NoError(t, err)
+	require.NoError(t, ln.Close())
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("关闭监听器后服务端没有退出")
+	}
+
+	// TLS 与 HTTP/2 同样建立在内存管道之上
+	transport = core.NewPipeTransport()
+	s = server.New(secret, nil)
+	s.Egress = nil
+	s.Transport = transport
+	s.HTTP2 = &server.HTTP2Options{}
+	s.TLSConfig, err = (&core.TLSOptions{CertFile: certFile, KeyFile: keyFile}).ServerConfig()
+	require.NoError(t, err)
+	pins, err := core.CertificatePins(s.TLSConfig)
+	require.NoError(t, err)
+	go s.Listen()
+	defer func() {
+		ln, _ := transport.Listen()
+		ln.Close()
+	}()
+
+	l = New(secret, nil, nil)
+	l.Transport = transport
+	l.HTTP2 = &core.HTTP2Options{URL: "https://cdn.example.com/"}
+	l.TLSConfig, err = (&core.TLSOptions{Pins: pins}).ClientConfig()
+	require.NoError(t, err)
+	assertEcho(t, l, target)
+}
+
+func TestDialProxy_TLS(t *testing.T) {
+	target := startEcho(t)
+	secret := core.GenerateCipherTable()
+	certFile, keyFile := selfSignedCert(t)
+
+	s := server.New(secret, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	var err error
+	s.TLSConfig, err = (&core.TLSOptions{CertFile: certFile, KeyFile: keyFile}).ServerConfig()
+	require.NoError(t, err)
+	pins, err := core.CertificatePins(s.TLSConfig)
+	require.NoError(t, err)
+	serverAddr := startServer(t, s)
+
+	l := New(secret, &net.TCPAddr{}, serverAddr)
+	l.TLSConfig, err = (&core.TLSOptions{Pins: pins, ServerName: "www.example.com"}).ClientConfig()
+	require.NoError(t, err)
+	assertEcho(t, l, target)
+
+	// 公钥不匹配时拒绝连接
+	l.TLSConfig, err = (&core.TLSOptions{Pins: []string{strings.Repeat("00", 32)}}).ClientConfig()
+	require.NoError(t, err)
+	_, err = l.DialProxy(target)
+	assert.Error(t, err)
+}
+
+func TestDialProxy_WebSocket(t *testing.T) {
+	target := startEcho(t)
+	secret := core.GenerateCipherTable()
+
+	s := server.New(secret, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	s.WebSocket = &server.WebSocketOptions{Path: "/tunnel"}
+	serverAddr := startServer(t, s)
+
+	l := New(secret, &net.TCPAddr{}, serverAddr)
+	l.WebSocket = &core.WebSocketOptions{URL: "ws://cdn.example.com/tunnel"}
+	assertEcho(t, l, target)
+
+	// 路径不匹配时握手失败
+	l.WebSocket = &core.WebSocketOptions{URL: "ws://cdn.example.com/other"}
+	_, err := l.DialProxy(target)
+	assert.Error(t, err)
+}
+
+func TestDialProxy_WebSocketTLS(t *testing.T) {
+	target := startEcho(t)
+	secret := core.GenerateCipherTable()
+	certFile, keyFile := selfSignedCert(t)
+
+	s := server.New(secret, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	s.WebSocket = &server.WebSocketOptions{}
+	var err error
+	s.TLSConfig, err = (&core.TLSOptions{CertFile: certFile, KeyFile: keyFile}).ServerConfig()
+	require.NoError(t, err)
+	pins, err := core.CertificatePins(s.TLSConfig)
+	require.NoError(t, err)
+	serverAddr := startServer(t, s)
+
+	l := New(secret, &net.TCPAddr{}, serverAddr)
+	l.WebSocket = &core.WebSocketOptions{URL: "wss://origin.example.com/", Host: "cdn.example.com"}
+	l.TLSConfig, err = (&core.TLSOptions{Pins: pins}).ClientConfig()
+	require.NoError(t, err)
+	assertEcho(t, l, target)
+}
+
+func TestDialProxy_HTTP2(t *testing.T) {
+	target := startEcho(t)
+	secret := core.GenerateCipherTable()
+
+	s := server.New(secret, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	s.HTTP2 = &server.HTTP2Options{Path: "/tunnel"}
+	serverAddr := startServer(t, s)
+
+	l := New(secret, &net.TCPAddr{}, serverAddr)
+	l.HTTP2 = &core.HTTP2Options{URL: "http://cdn.example.com/tunnel"}
+	assertEcho(t, l, target)
+
+	// 路径不匹配时服务端返回 404
+	l = New(secret, &net.TCPAddr{}, serverAddr)
+	l.HTTP2 = &core.HTTP2Options{URL: "http://cdn.example.com/other"}
+	_, err := l.DialProxy(target)
+	assert.ErrorContains(t, err, "404")
+}
+
+func TestDialProxy_HTTP2TLS(t *testing.T) {
+	target := startEcho(t)
+	secret := core.GenerateCipherTable()
+	certFile, keyFile := selfSignedCert(t)
+
+	s := server.New(secret, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	s.HTTP2 = &server.HTTP2Options{}
+	s.WebSocket = &server.WebSocketOptions{}
+	var err error
+	s.TLSConfig, err = (&core.TLSOptions{CertFile: certFile, KeyFile: keyFile}).ServerConfig()
+	require.NoError(t, err)
+	pins, err := core.CertificatePins(s.TLSConfig)
+	require.NoError(t, err)
+	serverAddr := startServer(t, s)
+
+	l := New(secret, &net.TCPAddr{}, serverAddr)
+	l.HTTP2 = &core.HTTP2Options{URL: "https://origin.example.com/", Host: "cdn.example.com"}
+	l.TLSConfig, err = (&core.TLSOptions{Pins: pins}).ClientConfig()
+	require.NoError(t, err)
+	assertEcho(t, l, target)
+
+	// 多条代理连接复用同一条 HTTP/2 连接
+	first, err := l.DialProxy(target)
+	require.NoError(t, err)
+	defer first.Close()
+	second, err := l.DialProxy(target)
+	require.NoError(t, err)
+	defer second.Close()
+	assert.Equal(t, first.LocalAddr().String(), second.LocalAddr().String())
+
+	// 同一端口上仍然接受 WebSocket
+	ws := New(secret, &net.TCPAddr{}, serverAddr)
+	ws.WebSocket = &core.WebSocketOptions{URL: "wss://cdn.example.com/"}
+	ws.TLSConfig = l.TLSConfig
+	assertEcho(t, ws, target)
+}
+
+func TestHandleDirect_HalfClose(t *testing.T) {
+	// 目标读到 EOF 后回复并关闭连接，用户一端发送完毕后目标应当立即收到 FIN
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		data, _ := io.ReadAll(conn)
+		received <- string(data)
+	}()
+
+	l := New(core.GenerateCipherTable(), &net.TCPAddr{}, &net.TCPAddr{})
+	require.NoError(t, l.SetRules([]string{"final -> direct"}, nil))
+	browser := socksConnect(t, l, ln.Addr().String())
+	_, err = browser.Write([]byte("request"))
+	require.NoError(t, err)
+	browser.Close()
+
+	select {
+	case data := <-received:
+		assert.Equal(t, "request", data)
+	case <-time.After(5 * time.Second):
+		t.Fatal("目标没有收到 FIN")
+	}
+}
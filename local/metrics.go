@@ -0,0 +1,81 @@
+package local
+
+import (
+	"errors"
+	"time"
+
+	"github.com/beijian128/minisocks/core"
+	"github.com/beijian128/minisocks/metrics"
+)
+
+// localMetrics 是本地端导出的 Prometheus 指标
+type localMetrics struct {
+	registry          *metrics.Registry
+	accepted          *metrics.Counter
+	active            *metrics.Gauge
+	handshakeFailures *metrics.Counter
+	dialDuration      *metrics.Histogram
+	dnsDuration       *metrics.Histogram
+	bytes             *metrics.Counter
+	cipherErrors      *metrics.Counter
+}
+
+func newLocalMetrics() *localMetrics {
+	r := metrics.NewRegistry()
+	return &localMetrics{
+		registry:          r,
+		accepted:          r.Counter("minisocks_local_connections_accepted_total", "接受的浏览器连接数"),
+		active:            r.Gauge("minisocks_local_connections_active", "正在处理的浏览器连接数"),
+		handshakeFailures: r.Counter("minisocks_local_handshake_failures_total", "握手失败的连接数，reason 为 socks（与浏览器）或 server（与服务端）", "reason"),
+		dialDuration:      r.Histogram("minisocks_local_dial_duration_seconds", "直连目标或连接服务端的耗时", nil, "route", "result"),
+		dnsDuration:       r.Histogram("minisocks_local_dns_duration_seconds", "本地 DNS 服务向上游查询的耗时", nil, "result"),
+		bytes:             r.Counter("minisocks_local_bytes_total", "转发的字节数，direction 为 up（浏览器到目标）或 down", "direction", "route"),
+		cipherErrors:      r.Counter("minisocks_local_cipher_errors_total", "加解密失败次数"),
+	}
+}
+
+// Metrics 返回本地端的指标，可以通过 ListenAndServe 提供给 Prometheus 抓取
+func (l *LsLocal) Metrics() *metrics.Registry {
+	return l.metrics.registry
+}
+
+// ObserveDNS 记录一次上游 DNS 查询的耗时，可以用作 dns.Server 的 OnExchange
+func (l *LsLocal) ObserveDNS(d time.Duration, err error) {
+	l.metrics.dnsDuration.Observe(d.Seconds(), result(err))
+}
+
+// observeDial 记录经 route 连接的耗时
+func (m *localMetrics) observeDial(route string, start time.Time, err error) {
+	m.dialDuration.Observe(time.Since(start).Seconds(), route, result(err))
+}
+
+// cipherError 在 err 是加解密错误时计数
+func (m *localMetrics) cipherError(err error) {
+	var cipherErr *core.CipherError
+	if errors.As(err, &cipherErr) {
+		m.cipherErrors.Inc()
+	}
+}
+
+// byteCounters 返回统计 route 上行与下行字节数的 Throttle，同时累计到 tracked
+func (m *localMetrics) byteCounters(route string, tracked *core.TrackedConn) (up, down core.Throttle) {
+	upBytes, downBytes := m.bytes.With("up", route), m.bytes.With("down", route)
+	trackedUp, trackedDown := tracked.Up(), tracked.Down()
+	up = core.ThrottleFunc(func(n int) error {
+		upBytes.Add(float64(n))
+		return trackedUp.Wait(n)
+	})
+	down = core.ThrottleFunc(func(n int) error {
+		downBytes.Add(float64(n))
+		return trackedDown.Wait(n)
+	})
+	return up, down
+}
+
+// result 返回耗时指标的 result 标签
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
@@ -0,0 +1,53 @@
+package local
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/beijian128/minisocks/core"
+	"github.com/beijian128/minisocks/metrics"
+	"github.com/beijian128/minisocks/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func exposition(t *testing.T, r *metrics.Registry) string {
+	t.Helper()
+	var out strings.Builder
+	_, err := r.WriteTo(&out)
+	require.NoError(t, err)
+	return out.String()
+}
+
+func TestMetrics(t *testing.T) {
+	target := startEcho(t)
+	secret := core.GenerateCipherTable()
+	s := server.New(secret, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	serverAddr := startServer(t, s)
+	l := New(secret, &net.TCPAddr{}, serverAddr)
+
+	browser := socksConnect(t, l, target)
+	_, err := browser.Write([]byte("hello"))
+	require.NoError(t, err)
+	_, err = io.ReadFull(browser, make([]byte, 5))
+	require.NoError(t, err)
+
+	// 密码错误的连接计入服务端的握手失败
+	_, err = New(core.GenerateCipherTable(), &net.TCPAddr{}, serverAddr).DialProxy(target)
+	require.Error(t, err)
+
+	out := exposition(t, l.Metrics())
+	assert.Contains(t, out, "minisocks_local_connections_accepted_total 1\n")
+	assert.Contains(t, out, "minisocks_local_connections_active 1\n")
+	assert.Contains(t, out, `minisocks_local_bytes_total{direction="up",route="proxy"} 5`+"\n")
+	assert.Contains(t, out, `minisocks_local_dial_duration_seconds_count{route="proxy",result="success"} 1`+"\n")
+
+	out = exposition(t, s.Metrics())
+	assert.Contains(t, out, "minisocks_server_connections_accepted_total 2\n")
+	assert.Contains(t, out, `minisocks_server_bytes_total{direction="up",user=""} 5`+"\n")
+	assert.Contains(t, out, `minisocks_server_dial_duration_seconds_count{result="success"} 1`+"\n")
+	assert.Contains(t, out, `minisocks_server_handshake_failures_total{reason="protocol"} 1`+"\n")
+	assert.Contains(t, out, "minisocks_server_cipher_errors_total 0\n")
+}
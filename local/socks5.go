@@ -0,0 +1,154 @@
+package local
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// SOCKS5 协议相关常量
+const (
+	socksVersion = 0x05
+
+	cmdConnect = 0x01
+
+	atypIPv4   = 0x01
+	atypDomain = 0x03
+	atypIPv6   = 0x04
+
+	repSucceeded           = 0x00
+	repGeneralFailure      = 0x01
+	repNotAllowed          = 0x02
+	repHostUnreachable     = 0x04
+	repCommandNotSupported = 0x07
+)
+
+// socksRequest 表示浏览器发来的 SOCKS5 CONNECT 请求
+type socksRequest struct {
+	host string // 目标域名，目标为 IP 时为空
+	ip   net.IP // 目标 IP，目标为域名时为空
+	port int    // 目标端口
+	raw  []byte // 原始请求报文，走代理时原样发给服务端
+}
+
+// addr 返回可直接用于拨号的目标地址
+func (r *socksRequest) addr() string {
+	host := r.host
+	if host == "" {
+		host = r.ip.String()
+	}
+	return net.JoinHostPort(host, strconv.Itoa(r.port))
+}
+
+// newSocksRequest 根据目标地址构造 CONNECT 请求
+func newSocksRequest(addr string) (*socksRequest, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("解析目标地址 %s 失败: %w", addr, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 65535 {
+		return nil, fmt.Errorf("无效的目标端口 %q", portStr)
+	}
+
+	req := &socksRequest{port: port, raw: []byte{socksVersion, cmdConnect, 0x00}}
+	if ip := net.ParseIP(host); ip != nil {
+		req.ip = ip
+		if ip4 := ip.To4(); ip4 != nil {
+			req.raw = append(req.raw, atypIPv4)
+			req.raw = append(req.raw, ip4...)
+		} else {
+			req.raw = append(req.raw, atypIPv6)
+			req.raw = append(req.raw, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return nil, fmt.Errorf("域名过长: %s", host)
+		}
+		req.host = host
+		req.raw = append(req.raw, atypDomain, byte(len(host)))
+		req.raw = append(req.raw, host...)
+	}
+	req.raw = binary.BigEndian.AppendUint16(req.raw, uint16(port))
+	return req, nil
+}
+
+// readGreeting 读取浏览器的 SOCKS5 协商报文，并回复无需认证
+func readGreeting(conn net.Conn) error {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("读取协商数据失败: %w", err)
+	}
+	if header[0] != socksVersion {
+		return fmt.Errorf("不支持的协议版本: 0x%x，仅支持 Socks5", header[0])
+	}
+
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return fmt.Errorf("读取认证方式失败: %w", err)
+	}
+
+	if _, err := conn.Write([]byte{socksVersion, 0x00}); err != nil {
+		return fmt.Errorf("发送协商响应失败: %w", err)
+	}
+	return nil
+}
+
+// readRequest 读取并解析浏览器的 SOCKS5 请求
+func readRequest(conn net.Conn) (*socksRequest, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, fmt.Errorf("读取请求数据失败: %w", err)
+	}
+	if header[0] != socksVersion {
+		return nil, fmt.Errorf("不支持的协议版本: 0x%x，仅支持 Socks5", header[0])
+	}
+	if header[1] != cmdConnect {
+		writeReply(conn, repCommandNotSupported)
+		return nil, fmt.Errorf("不支持的请求类型: 0x%x，仅支持 CONNECT(0x01)", header[1])
+	}
+
+	req := &socksRequest{raw: header}
+	var addrLen int
+	switch header[3] {
+	case atypIPv4:
+		addrLen = net.IPv4len
+	case atypIPv6:
+		addrLen = net.IPv6len
+	case atypDomain:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(conn, l); err != nil {
+			return nil, fmt.Errorf("读取域名长度失败: %w", err)
+		}
+		req.raw = append(req.raw, l[0])
+		addrLen = int(l[0])
+	default:
+		return nil, fmt.Errorf("不支持的目标地址类型: 0x%x", header[3])
+	}
+
+	rest := make([]byte, addrLen+2)
+	if _, err := io.ReadFull(conn, rest); err != nil {
+		return nil, fmt.Errorf("读取目标地址失败: %w", err)
+	}
+	req.raw = append(req.raw, rest...)
+
+	if header[3] == atypDomain {
+		req.host = string(rest[:addrLen])
+		if req.host == "" {
+			return nil, errors.New("目标域名为空")
+		}
+	} else {
+		req.ip = net.IP(rest[:addrLen])
+	}
+	req.port = int(binary.BigEndian.Uint16(rest[addrLen:]))
+	return req, nil
+}
+
+// writeReply 向浏览器发送 SOCKS5 响应，绑定地址固定为 0.0.0.0:0
+func writeReply(conn net.Conn, rep byte) error {
+	_, err := conn.Write([]byte{socksVersion, rep, 0x00, atypIPv4, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	return err
+}
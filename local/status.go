@@ -0,0 +1,64 @@
+package local
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/beijian128/minisocks/core"
+	"github.com/sirupsen/logrus"
+)
+
+// Status 是本地端的状态接口，只能监听本机回环地址，响应均为 JSON
+//
+//	GET /api/conns   正在转发的连接
+//	GET /api/stats   汇总统计
+type Status struct {
+	local  *LsLocal
+	mux    *http.ServeMux
+	logger *logrus.Entry
+}
+
+// NewStatus 创建 l 的状态接口
+func NewStatus(l *LsLocal) *Status {
+	s := &Status{
+		local:  l,
+		mux:    http.NewServeMux(),
+		logger: logrus.WithField("component", "Status"),
+	}
+	s.mux.HandleFunc("GET /api/conns", s.listConns)
+	s.mux.HandleFunc("GET /api/stats", s.stats)
+	return s
+}
+
+// ListenAndServe 在 addr 上提供状态接口，addr 必须是本机回环地址
+func (s *Status) ListenAndServe(addr string) error {
+	if !core.IsLoopbackAddr(addr) {
+		return fmt.Errorf("状态接口只能监听本机回环地址: %s", addr)
+	}
+	s.logger.WithField("address", addr).Info("状态接口启动")
+	srv := &http.Server{Addr: addr, Handler: s, ReadHeaderTimeout: core.TIMEOUT}
+	return srv.ListenAndServe()
+}
+
+// ServeHTTP 分发请求
+func (s *Status) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.logger.WithFields(logrus.Fields{
+		"remoteAddr": r.RemoteAddr,
+		"path":       r.URL.Path,
+	}).Debug("处理状态请求")
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Status) listConns(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.local.conns.List())
+}
+
+func (s *Status) stats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.local.conns.Stats())
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,90 @@
+package local
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/beijian128/minisocks/core"
+	"github.com/beijian128/minisocks/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// socksConnect 通过 handleConn 以 SOCKS5 协议连接 target，返回浏览器一端的连接
+func socksConnect(t *testing.T, l *LsLocal, target string) net.Conn {
+	t.Helper()
+	host, portStr, err := net.SplitHostPort(target)
+	require.NoError(t, err)
+	port, err := strconv.Atoi(portStr)
+	require.NoError(t, err)
+
+	browser, userConn := net.Pipe()
+	t.Cleanup(func() { browser.Close() })
+	go l.handleConn(userConn)
+
+	_, err = browser.Write([]byte{socksVersion, 0x01, 0x00})
+	require.NoError(t, err)
+	resp := make([]byte, 2)
+	_, err = io.ReadFull(browser, resp)
+	require.NoError(t, err)
+
+	req := append([]byte{socksVersion, 0x01, 0x00, 0x01}, net.ParseIP(host).To4()...)
+	req = append(req, byte(port>>8), byte(port))
+	_, err = browser.Write(req)
+	require.NoError(t, err)
+	reply := make([]byte, 10)
+	_, err = io.ReadFull(browser, reply)
+	require.NoError(t, err)
+	require.Equal(t, byte(repSucceeded), reply[1])
+	return browser
+}
+
+func getJSON(t *testing.T, handler http.Handler, path string, v any) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), v), rec.Body.String())
+}
+
+func TestStatus(t *testing.T) {
+	target := startEcho(t)
+	secret := core.GenerateCipherTable()
+	l := New(secret, &net.TCPAddr{}, startServer(t, server.New(secret, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})))
+	status := NewStatus(l)
+
+	browser := socksConnect(t, l, target)
+	_, err := browser.Write([]byte("hello"))
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(browser, buf)
+	require.NoError(t, err)
+
+	var conns []core.ConnInfo
+	getJSON(t, status, "/api/conns", &conns)
+	require.Len(t, conns, 1)
+	assert.Equal(t, "proxy", conns[0].Route)
+	assert.Equal(t, target, conns[0].Target)
+	assert.Equal(t, int64(5), conns[0].Up)
+	assert.Positive(t, conns[0].Down)
+
+	// 从连接表中断连接后浏览器一端随之关闭，连接移出连接表
+	assert.True(t, l.conns.Close(conns[0].ID))
+	browser.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = browser.Read(buf)
+	assert.Error(t, err)
+	assert.Eventually(t, func() bool { return len(l.conns.List()) == 0 }, 5*time.Second, 10*time.Millisecond)
+
+	var stats core.ConnStats
+	getJSON(t, status, "/api/stats", &stats)
+	assert.Equal(t, int64(1), stats.TotalConns)
+	assert.Equal(t, int64(5), stats.Up)
+
+	assert.Error(t, status.ListenAndServe(":0"), "只能监听本机回环地址")
+}
@@ -0,0 +1,120 @@
+// Package logging 根据配置设置 logrus 的级别、格式与输出，支持按组件覆盖级别与按大小轮转日志文件
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Options 定义了日志的级别、格式与输出
+type Options struct {
+	Level      string            // 默认级别：trace、debug、info、warn、error，为空时为 info
+	Format     string            // 格式：text 或 json，为空时为 text
+	File       string            // 日志文件，为空时输出到标准错误
+	MaxSize    int64             // 日志文件超过该字节数时轮转，为 0 时不轮转
+	MaxBackups int               // 保留的历史文件数，为 0 时不限制
+	MaxAge     int               // 历史文件保留的天数，为 0 时不限制
+	Components map[string]string // 按 component 字段覆盖级别，例如 {"DNS": "debug"}
+}
+
+// Setup 按 opts 配置 logrus 的标准 logger
+func Setup(opts Options) error {
+	return Configure(logrus.StandardLogger(), opts)
+}
+
+// Configure 按 opts 配置 logger，配置有误时不修改 logger
+func Configure(logger *logrus.Logger, opts Options) error {
+	level, err := parseLevel(opts.Level)
+	if err != nil {
+		return err
+	}
+	components := make(map[string]logrus.Level, len(opts.Components))
+	enabled := level
+	for name, value := range opts.Components {
+		if components[name], err = parseLevel(value); err != nil {
+			return fmt.Errorf("组件 %s: %w", name, err)
+		}
+		enabled = max(enabled, components[name])
+	}
+
+	var formatter logrus.Formatter
+	switch opts.Format {
+	case "", "text":
+		formatter = &logrus.TextFormatter{FullTimestamp: true, DisableColors: opts.File != ""}
+	case "json":
+		formatter = stringFields{&logrus.JSONFormatter{}}
+	default:
+		return fmt.Errorf("不支持的日志格式: %s，可选 text 或 json", opts.Format)
+	}
+	if len(components) > 0 {
+		formatter = &componentFilter{Formatter: formatter, level: level, components: components}
+	}
+
+	var out io.Writer = os.Stderr
+	if opts.File != "" {
+		if out, err = OpenFile(opts.File, opts.MaxSize, opts.MaxBackups, opts.MaxAge); err != nil {
+			return err
+		}
+	}
+
+	// logger 的级别取所有级别中最详细的一个，由 componentFilter 按组件丢弃多余的日志
+	logger.SetLevel(enabled)
+	logger.SetFormatter(formatter)
+	logger.SetOutput(out)
+	return nil
+}
+
+func parseLevel(s string) (logrus.Level, error) {
+	if s == "" {
+		return logrus.InfoLevel, nil
+	}
+	level, err := logrus.ParseLevel(s)
+	if err != nil {
+		return 0, fmt.Errorf("无效的日志级别 %q: %w", s, err)
+	}
+	return level, nil
+}
+
+// componentFilter 按日志的 component 字段决定是否输出，没有覆盖级别的组件使用默认级别
+type componentFilter struct {
+	logrus.Formatter
+	level      logrus.Level
+	components map[string]logrus.Level
+}
+
+func (f *componentFilter) Format(entry *logrus.Entry) ([]byte, error) {
+	level := f.level
+	if name, ok := entry.Data["component"].(string); ok {
+		if override, ok := f.components[name]; ok {
+			level = override
+		}
+	}
+	if entry.Level > level {
+		return nil, nil
+	}
+	return f.Formatter.Format(entry)
+}
+
+// stringFields 将实现了 fmt.Stringer 的字段（例如 net.Addr）转换为字符串后再格式化，
+// 避免 JSON 格式中出现 {"IP": ..., "Port": ...} 这样的结构
+type stringFields struct {
+	logrus.Formatter
+}
+
+func (f stringFields) Format(entry *logrus.Entry) ([]byte, error) {
+	data := make(logrus.Fields, len(entry.Data))
+	for k, v := range entry.Data {
+		if s, ok := v.(fmt.Stringer); ok {
+			if _, isErr := v.(error); !isErr {
+				v = fmt.Sprint(s)
+			}
+		}
+		data[k] = v
+	}
+	e := *entry
+	e.Data = data
+	return f.Formatter.Format(&e)
+}
@@ -0,0 +1,100 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigure(t *testing.T) {
+	logger := logrus.New()
+	require.NoError(t, Configure(logger, Options{
+		Level:      "warn",
+		Format:     "json",
+		Components: map[string]string{"DNS": "debug"},
+	}))
+	var out bytes.Buffer
+	logger.SetOutput(&out)
+
+	logger.WithField("component", "LsServer").Info("不输出")
+	logger.WithFields(logrus.Fields{
+		"component":  "LsServer",
+		"remoteAddr": &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 7448},
+	}).Warn("服务端警告")
+	logger.WithField("component", "DNS").Debug("DNS 调试")
+	logger.Info("没有组件时使用默认级别")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2)
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, "服务端警告", entry["msg"])
+	assert.Equal(t, "warning", entry["level"])
+	assert.Equal(t, "127.0.0.1:7448", entry["remoteAddr"], "net.Addr 以字符串输出")
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &entry))
+	assert.Equal(t, "DNS", entry["component"])
+	assert.Equal(t, "debug", entry["level"])
+
+	assert.Error(t, Configure(logger, Options{Level: "verbose"}))
+	assert.Error(t, Configure(logger, Options{Format: "xml"}))
+	assert.Error(t, Configure(logger, Options{Components: map[string]string{"DNS": "loud"}}))
+	assert.Equal(t, logrus.DebugLevel, logger.GetLevel(), "配置有误时不修改 logger")
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "minisocks.log")
+	f, err := OpenFile(path, 10, 2, 0)
+	require.NoError(t, err)
+	defer f.Close()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local)
+	f.now = func() time.Time { return now }
+
+	for i := range 4 {
+		now = now.Add(time.Second)
+		_, err := f.Write([]byte("012345" + string(rune('a'+i)) + "\n"))
+		require.NoError(t, err)
+	}
+
+	// 每次写入前文件都会超出 10 字节，前 3 条依次轮转为以轮转时间命名的历史文件，只保留最近 2 个
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "012345d\n", string(data))
+	backups, err := filepath.Glob(filepath.Join(filepath.Dir(path), "minisocks-*.log"))
+	require.NoError(t, err)
+	require.Len(t, backups, 2)
+	assert.Equal(t, "minisocks-20240501-120004.000.log", filepath.Base(backups[1]))
+	data, err = os.ReadFile(backups[1])
+	require.NoError(t, err)
+	assert.Equal(t, "012345c\n", string(data))
+}
+
+func TestRotatingFile_MaxAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "minisocks.log")
+	f, err := OpenFile(path, 4, 0, 1)
+	require.NoError(t, err)
+	defer f.Close()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local)
+	f.now = func() time.Time { return now }
+
+	f.Write([]byte("old\n"))
+	f.Write([]byte("mid\n"))
+	now = now.Add(36 * time.Hour)
+	f.Write([]byte("new\n"))
+
+	// 第一次轮转产生的历史文件超过 1 天后被删除
+	backups, err := filepath.Glob(filepath.Join(filepath.Dir(path), "minisocks-*.log"))
+	require.NoError(t, err)
+	require.Len(t, backups, 1)
+	data, err := os.ReadFile(backups[0])
+	require.NoError(t, err)
+	assert.Equal(t, "mid\n", string(data))
+}
@@ -0,0 +1,136 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat 是历史文件名中的时间格式，按字典序排序即按时间排序
+const backupTimeFormat = "20060102-150405.000"
+
+// RotatingFile 是按大小轮转的日志文件。写入后超过 maxSize 时将当前文件重命名为
+// 带时间的历史文件，例如 minisocks-20240501-150405.000.log，并清理超出保留数量或天数的历史文件
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	maxAge     time.Duration
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+	now  func() time.Time
+}
+
+// OpenFile 以追加方式打开日志文件，maxSize 为 0 时不轮转，maxBackups 与 maxAge（天）为 0 时不限制
+func OpenFile(path string, maxSize int64, maxBackups, maxAge int) (*RotatingFile, error) {
+	f := &RotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+		maxAge:     time.Duration(maxAge) * 24 * time.Hour,
+		now:        time.Now,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	if dir := filepath.Dir(f.path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("创建日志目录失败: %w", err)
+		}
+	}
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("打开日志文件失败: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("读取日志文件信息失败: %w", err)
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// Write 写入一条日志，写入前文件已经达到 maxSize 时先轮转
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Close 关闭日志文件
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
+
+// rotate 将当前文件重命名为历史文件并打开新文件，调用时需持有 mu
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return fmt.Errorf("关闭日志文件失败: %w", err)
+	}
+	prefix, ext := f.backupName()
+	backup := prefix + f.now().Format(backupTimeFormat) + ext
+	if err := os.Rename(f.path, backup); err != nil {
+		return fmt.Errorf("轮转日志文件失败: %w", err)
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	f.prune()
+	return nil
+}
+
+// backupName 返回历史文件名中时间之前与之后的部分
+func (f *RotatingFile) backupName() (prefix, ext string) {
+	ext = filepath.Ext(f.path)
+	return strings.TrimSuffix(f.path, ext) + "-", ext
+}
+
+// prune 删除超出保留数量或天数的历史文件，删除失败时忽略
+func (f *RotatingFile) prune() {
+	if f.maxBackups <= 0 && f.maxAge <= 0 {
+		return
+	}
+	prefix, ext := f.backupName()
+	matches, _ := filepath.Glob(prefix + "*" + ext)
+	var backups []string
+	for _, name := range matches {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext)
+		if _, err := time.ParseInLocation(backupTimeFormat, stamp, time.Local); err == nil {
+			backups = append(backups, name)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+
+	cutoff := f.now().Add(-f.maxAge)
+	for i, name := range backups {
+		expired := f.maxBackups > 0 && i >= f.maxBackups
+		if !expired && f.maxAge > 0 {
+			stamp := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext)
+			rotated, _ := time.ParseInLocation(backupTimeFormat, stamp, time.Local)
+			expired = rotated.Before(cutoff)
+		}
+		if expired {
+			os.Remove(name)
+		}
+	}
+}
@@ -0,0 +1,389 @@
+// Package metrics 实现计数器、仪表与直方图，并以 Prometheus 文本格式输出，不依赖外部库
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefBuckets 是直方图默认的桶上界（秒），适用于网络延迟
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry 管理一组指标，按名称排序输出
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+// NewRegistry 创建空的指标集合
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]metric)}
+}
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+func (r *Registry) register(name string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.metrics[name]; ok {
+		panic("metrics: 重复注册指标 " + name)
+	}
+	r.metrics[name] = m
+}
+
+// WriteTo 以 Prometheus 文本格式输出所有指标
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	metrics := make([]metric, len(names))
+	sort.Strings(names)
+	for i, name := range names {
+		metrics[i] = r.metrics[name]
+	}
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP 输出所有指标
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+// ListenAndServe 在 addr 的 /metrics 路径上提供指标
+func (r *Registry) ListenAndServe(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", r)
+	logrus.WithFields(logrus.Fields{
+		"component": "Metrics",
+		"address":   addr,
+	}).Info("指标服务启动")
+	return http.ListenAndServe(addr, mux)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// family 是同名指标按标签值区分的一组序列
+type family[T any] struct {
+	name, help, kind string
+	labels           []string
+
+	mu     sync.RWMutex
+	series map[string]*T
+	values map[string][]string // 序列对应的标签值
+	create func() *T
+}
+
+// newFamily 创建一组序列，没有标签时立即创建唯一的序列，使其在首次更新前也以 0 输出
+func newFamily[T any](name, help, kind string, labels []string, create func() *T) *family[T] {
+	f := &family[T]{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		series: make(map[string]*T),
+		values: make(map[string][]string),
+		create: create,
+	}
+	if len(labels) == 0 {
+		f.with(nil)
+	}
+	return f
+}
+
+// with 返回标签值对应的序列，不存在时创建。标签值个数与标签名不一致时 panic
+func (f *family[T]) with(values []string) *T {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: 指标 %s 需要 %d 个标签值，实际 %d 个", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	f.mu.RLock()
+	s, ok := f.series[key]
+	f.mu.RUnlock()
+	if ok {
+		return s
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s, ok := f.series[key]; ok {
+		return s
+	}
+	s = f.create()
+	f.series[key] = s
+	f.values[key] = append([]string(nil), values...)
+	return s
+}
+
+// each 按标签值排序遍历所有序列
+func (f *family[T]) each(fn func(values []string, s *T)) {
+	f.mu.RLock()
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	series := make([]*T, len(keys))
+	values := make([][]string, len(keys))
+	for i, key := range keys {
+		series[i], values[i] = f.series[key], f.values[key]
+	}
+	f.mu.RUnlock()
+	for i := range series {
+		fn(values[i], series[i])
+	}
+}
+
+func (f *family[T]) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+}
+
+// Value 是一个可以原子增减的浮点数，作为计数器与仪表的一条序列
+type Value struct {
+	bits atomic.Uint64
+}
+
+// Add 增加 v
+func (v *Value) Add(delta float64) {
+	for {
+		old := v.bits.Load()
+		if v.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+// Set 设置为 value
+func (v *Value) Set(value float64) {
+	v.bits.Store(math.Float64bits(value))
+}
+
+// Get 返回当前值
+func (v *Value) Get() float64 {
+	return math.Float64frombits(v.bits.Load())
+}
+
+// Counter 是只增不减的计数器
+type Counter struct {
+	*family[Value]
+}
+
+// Counter 注册一个计数器，labels 是标签名
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	c := &Counter{newFamily(name, help, "counter", labels, func() *Value { return new(Value) })}
+	r.register(name, c)
+	return c
+}
+
+// With 返回标签值对应的序列，在热点路径上可以保存下来避免重复查找
+func (c *Counter) With(values ...string) *Value {
+	return c.with(values)
+}
+
+// Inc 为标签值对应的序列加一
+func (c *Counter) Inc(values ...string) {
+	c.with(values).Add(1)
+}
+
+// Add 为标签值对应的序列加上 delta，delta 不能为负数
+func (c *Counter) Add(delta float64, values ...string) {
+	c.with(values).Add(delta)
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.writeHeader(w)
+	c.each(func(values []string, v *Value) {
+		writeSample(w, c.name, c.labels, values, "", "", v.Get())
+	})
+}
+
+// Gauge 是可以增减的仪表
+type Gauge struct {
+	*family[Value]
+}
+
+// Gauge 注册一个仪表，labels 是标签名
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{newFamily(name, help, "gauge", labels, func() *Value { return new(Value) })}
+	r.register(name, g)
+	return g
+}
+
+// With 返回标签值对应的序列
+func (g *Gauge) With(values ...string) *Value {
+	return g.with(values)
+}
+
+// Inc 为标签值对应的序列加一
+func (g *Gauge) Inc(values ...string) {
+	g.with(values).Add(1)
+}
+
+// Dec 为标签值对应的序列减一
+func (g *Gauge) Dec(values ...string) {
+	g.with(values).Add(-1)
+}
+
+// Set 设置标签值对应的序列
+func (g *Gauge) Set(value float64, values ...string) {
+	g.with(values).Set(value)
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.writeHeader(w)
+	g.each(func(values []string, v *Value) {
+		writeSample(w, g.name, g.labels, values, "", "", v.Get())
+	})
+}
+
+// histogramSeries 是直方图的一条序列
+type histogramSeries struct {
+	mu     sync.Mutex
+	counts []uint64 // 每个桶（不累计）的观测数，最后一个是 +Inf
+	sum    float64
+	count  uint64
+}
+
+// Histogram 按桶统计观测值的分布
+type Histogram struct {
+	*family[histogramSeries]
+	buckets []float64
+}
+
+// Histogram 注册一个直方图，buckets 是递增的桶上界，为空时使用 DefBuckets
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+	if !sort.Float64sAreSorted(buckets) {
+		panic("metrics: 直方图 " + name + " 的桶上界必须递增")
+	}
+	h := &Histogram{buckets: buckets}
+	h.family = newFamily(name, help, "histogram", labels, func() *histogramSeries {
+		return &histogramSeries{counts: make([]uint64, len(buckets)+1)}
+	})
+	r.register(name, h)
+	return h
+}
+
+// Observe 为标签值对应的序列记录一个观测值
+func (h *Histogram) Observe(v float64, values ...string) {
+	s := h.with(values)
+	i := sort.SearchFloat64s(h.buckets, v)
+	s.mu.Lock()
+	s.counts[i]++
+	s.sum += v
+	s.count++
+	s.mu.Unlock()
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.writeHeader(w)
+	h.each(func(values []string, s *histogramSeries) {
+		s.mu.Lock()
+		counts := append([]uint64(nil), s.counts...)
+		sum, count := s.sum, s.count
+		s.mu.Unlock()
+
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += counts[i]
+			writeSample(w, h.name+"_bucket", h.labels, values, "le", formatFloat(bound), float64(cumulative))
+		}
+		writeSample(w, h.name+"_bucket", h.labels, values, "le", "+Inf", float64(count))
+		writeSample(w, h.name+"_sum", h.labels, values, "", "", sum)
+		writeSample(w, h.name+"_count", h.labels, values, "", "", float64(count))
+	})
+}
+
+// GaugeFunc 注册一个在输出时调用 fn 取值的仪表，适用于已经在别处维护的数值
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.register(name, gaugeFunc{name: name, help: help, fn: fn})
+}
+
+type gaugeFunc struct {
+	name, help string
+	fn         func() float64
+}
+
+func (g gaugeFunc) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", g.name, escapeHelp(g.help))
+	fmt.Fprintf(w, "# TYPE %s gauge\n", g.name)
+	writeSample(w, g.name, nil, nil, "", "", g.fn())
+}
+
+// writeSample 输出一行样本，extraName 不为空时追加一个标签（直方图的 le）
+func writeSample(w *bufio.Writer, name string, labels, values []string, extraName, extraValue string, v float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", label, escapeLabel(values[i]))
+		}
+		if extraName != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", extraName, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	conns := r.Counter("conns_total", "已接受的连接数")
+	failures := r.Counter("failures_total", "握手失败次数", "reason")
+	active := r.Gauge("active", "正在处理的连接数")
+	latency := r.Histogram("latency_seconds", "延迟", []float64{0.1, 1}, "result")
+	r.GaugeFunc("uptime_seconds", "运行时长", func() float64 { return 42 })
+
+	conns.Inc()
+	conns.With().Add(2)
+	failures.Inc("key_exchange")
+	failures.Add(2, `bad "greeting"`+"\n")
+	active.Inc()
+	active.Inc()
+	active.Dec()
+	latency.Observe(0.05, "success")
+	latency.Observe(0.1, "success")
+	latency.Observe(3, "success")
+
+	var out strings.Builder
+	_, err := r.WriteTo(&out)
+	require.NoError(t, err)
+	assert.Equal(t, `# HELP active 正在处理的连接数
+# TYPE active gauge
+active 1
+# HELP conns_total 已接受的连接数
+# TYPE conns_total counter
+conns_total 3
+# HELP failures_total 握手失败次数
+# TYPE failures_total counter
+failures_total{reason="bad \"greeting\"\n"} 2
+failures_total{reason="key_exchange"} 1
+# HELP latency_seconds 延迟
+# TYPE latency_seconds histogram
+latency_seconds_bucket{result="success",le="0.1"} 2
+latency_seconds_bucket{result="success",le="1"} 2
+latency_seconds_bucket{result="success",le="+Inf"} 3
+latency_seconds_sum{result="success"} 3.15
+latency_seconds_count{result="success"} 3
+# HELP uptime_seconds 运行时长
+# TYPE uptime_seconds gauge
+uptime_seconds 42
+`, out.String())
+
+	assert.Panics(t, func() { failures.Inc() }, "标签值个数不一致")
+	assert.Panics(t, func() { r.Counter("conns_total", "重复") }, "重复注册")
+
+	// 没有标签的指标在更新前也以 0 输出
+	idle := NewRegistry()
+	idle.Counter("idle_total", "空闲")
+	out.Reset()
+	idle.WriteTo(&out)
+	assert.Contains(t, out.String(), "idle_total 0\n")
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get("Content-Type"), "version=0.0.4")
+	assert.Contains(t, rec.Body.String(), "conns_total 3\n")
+}
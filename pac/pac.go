@@ -0,0 +1,206 @@
+package pac
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Options 描述生成 PAC 文件所需的信息
+type Options struct {
+	ProxyAddr string   // 本地 SOCKS5 代理地址，例如 127.0.0.1:7448
+	Direct    []string // 直连的域名后缀、IPv4 地址或网段
+	Proxy     []string // 走代理的域名后缀、IPv4 地址或网段
+	Default   string   // 列表均未命中时的动作，proxy 或 direct，默认 proxy
+}
+
+// ProxyAddr 根据本地监听地址推导浏览器应使用的代理地址，监听在全部网卡时使用 host 或 127.0.0.1
+func ProxyAddr(listenAddr, host string) (string, error) {
+	listenHost, port, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return "", fmt.Errorf("解析监听地址 %s 失败: %w", listenAddr, err)
+	}
+	if host == "" {
+		host = listenHost
+		if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+			host = "127.0.0.1"
+		}
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+type cidr struct {
+	IP, Mask string
+	Ones     int
+	Proxy    bool
+}
+
+// Generate 生成 PAC 文件内容
+//
+// 域名按最长后缀匹配，IPv4 网段按最长前缀匹配，直连与代理列表之间更具体的条目优先。
+// 为避免浏览器发起 DNS 查询，网段只对 IP 形式的主机名生效。
+func Generate(opts Options) ([]byte, error) {
+	if opts.ProxyAddr == "" {
+		return nil, fmt.Errorf("代理地址不能为空")
+	}
+	defaultProxy := true
+	switch opts.Default {
+	case "", "proxy":
+	case "direct":
+		defaultProxy = false
+	default:
+		return nil, fmt.Errorf("无效的默认动作 %q，仅支持 proxy 或 direct", opts.Default)
+	}
+
+	domains := make(map[string]bool)
+	var cidrs []cidr
+	for _, list := range []struct {
+		entries []string
+		proxy   bool
+	}{{opts.Direct, false}, {opts.Proxy, true}} {
+		for _, entry := range list.entries {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			if ip := net.ParseIP(entry); ip != nil {
+				// 单个 IP 视为 /32 网段，否则会被当作域名而永远无法命中
+				entry += "/32"
+			} else if !strings.Contains(entry, "/") {
+				domains[strings.Trim(strings.ToLower(entry), ".")] = list.proxy
+				continue
+			}
+			_, ipNet, err := net.ParseCIDR(entry)
+			if err != nil {
+				return nil, fmt.Errorf("无效的网段 %q: %w", entry, err)
+			}
+			if ipNet.IP.To4() == nil {
+				return nil, fmt.Errorf("PAC 仅支持 IPv4 网段: %q", entry)
+			}
+			ones, _ := ipNet.Mask.Size()
+			cidrs = append(cidrs, cidr{
+				IP:    ipNet.IP.String(),
+				Mask:  net.IP(ipNet.Mask).String(),
+				Ones:  ones,
+				Proxy: list.proxy,
+			})
+		}
+	}
+	sort.SliceStable(cidrs, func(i, j int) bool { return cidrs[i].Ones > cidrs[j].Ones })
+
+	domainsJSON, err := json.Marshal(domains)
+	if err != nil {
+		return nil, err
+	}
+	// 代理地址以 JSON 字符串写入脚本，避免其中的引号或反斜杠破坏 PAC 文件
+	proxyJSON, err := json.Marshal(fmt.Sprintf("SOCKS5 %s; SOCKS %s", opts.ProxyAddr, opts.ProxyAddr))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	err = pacTemplate.Execute(&buf, map[string]any{
+		"Proxy":        string(proxyJSON),
+		"DefaultProxy": defaultProxy,
+		"Domains":      string(domainsJSON),
+		"CIDRs":        cidrs,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("生成 PAC 文件失败: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+var pacTemplate = template.Must(template.New("pac").Parse(`// 由 minisocks 生成的代理自动配置文件
+var proxy = {{.Proxy}};
+var direct = "DIRECT";
+var defaultProxy = {{.DefaultProxy}};
+
+// 域名后缀 -> 是否走代理
+var domains = {{.Domains}};
+
+// IPv4 网段，按前缀长度从长到短排列
+var cidrs = [
+{{- range .CIDRs}}
+  ["{{.IP}}", "{{.Mask}}", {{.Proxy}}],
+{{- end}}
+];
+
+function choose(useProxy) {
+  return useProxy ? proxy : direct;
+}
+
+function FindProxyForURL(url, host) {
+  host = host.toLowerCase();
+  if (/^\d+\.\d+\.\d+\.\d+$/.test(host)) {
+    for (var i = 0; i < cidrs.length; i++) {
+      if (isInNet(host, cidrs[i][0], cidrs[i][1])) {
+        return choose(cidrs[i][2]);
+      }
+    }
+    return choose(defaultProxy);
+  }
+
+  var suffix = host;
+  while (true) {
+    if (domains.hasOwnProperty(suffix)) {
+      return choose(domains[suffix]);
+    }
+    var dot = suffix.indexOf(".");
+    if (dot < 0) {
+      break;
+    }
+    suffix = suffix.substring(dot + 1);
+  }
+  return choose(defaultProxy);
+}
+`))
+
+// Server 通过 HTTP 提供 PAC 文件，内容可在运行期间更新
+type Server struct {
+	mu      sync.RWMutex
+	content []byte
+	logger  *logrus.Entry
+}
+
+// NewServer 创建 PAC 文件服务
+func NewServer(content []byte) *Server {
+	return &Server{
+		content: content,
+		logger:  logrus.WithField("component", "PAC"),
+	}
+}
+
+// SetContent 更新 PAC 文件内容
+func (s *Server) SetContent(content []byte) {
+	s.mu.Lock()
+	s.content = content
+	s.mu.Unlock()
+}
+
+// ServeHTTP 返回 PAC 文件，任意路径都会返回相同内容，推荐使用 /proxy.pac
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	content := s.content
+	s.mu.RUnlock()
+
+	s.logger.WithFields(logrus.Fields{
+		"remoteAddr": r.RemoteAddr,
+		"path":       r.URL.Path,
+	}).Debug("提供 PAC 文件")
+	w.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
+	w.Write(content)
+}
+
+// ListenAndServe 在指定地址启动 PAC 文件 HTTP 服务
+func (s *Server) ListenAndServe(addr string) error {
+	s.logger.WithField("address", addr).Info("PAC 服务启动")
+	return http.ListenAndServe(addr, s)
+}
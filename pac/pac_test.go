@@ -0,0 +1,83 @@
+package pac
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyAddr(t *testing.T) {
+	tests := []struct {
+		listen, host, want string
+	}{
+		{":7448", "", "127.0.0.1:7448"},
+		{"0.0.0.0:7448", "", "127.0.0.1:7448"},
+		{"192.168.1.2:1080", "", "192.168.1.2:1080"},
+		{":7448", "10.0.0.1", "10.0.0.1:7448"},
+	}
+	for _, tt := range tests {
+		got, err := ProxyAddr(tt.listen, tt.host)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.want, got)
+	}
+
+	_, err := ProxyAddr("bad", "")
+	assert.Error(t, err)
+}
+
+func TestGenerate(t *testing.T) {
+	content, err := Generate(Options{
+		ProxyAddr: "127.0.0.1:7448",
+		Direct:    []string{"cn", ".lan", "10.0.0.0/8", "192.168.0.0/16", "10.1.2.3"},
+		Proxy:     []string{"google.cn", "10.1.0.0/16"},
+		Default:   "direct",
+	})
+	require.NoError(t, err)
+
+	js := string(content)
+	assert.Contains(t, js, `var proxy = "SOCKS5 127.0.0.1:7448; SOCKS 127.0.0.1:7448";`)
+	assert.Contains(t, js, `var defaultProxy = false;`)
+	assert.Contains(t, js, `"cn":false`)
+	assert.Contains(t, js, `"lan":false`)
+	assert.Contains(t, js, `"google.cn":true`)
+	assert.Contains(t, js, "function FindProxyForURL(url, host)")
+
+	assert.NotContains(t, js, `"10.1.2.3":false`, "单个 IP 不作为域名")
+
+	// 更长的前缀排在前面，单个 IP 视为 /32
+	assert.Less(t, strings.Index(js, `"10.1.2.3", "255.255.255.255", false`), strings.Index(js, `"10.1.0.0", "255.255.0.0", true`))
+	assert.Less(t, strings.Index(js, `"10.1.0.0", "255.255.0.0", true`), strings.Index(js, `"10.0.0.0", "255.0.0.0", false`))
+
+	// 代理地址中的特殊字符经过转义
+	content, err = Generate(Options{ProxyAddr: `proxy"\host:7448`})
+	require.NoError(t, err)
+	assert.Contains(t, string(content), `var proxy = "SOCKS5 proxy\"\\host:7448; SOCKS proxy\"\\host:7448";`)
+}
+
+func TestGenerate_Invalid(t *testing.T) {
+	_, err := Generate(Options{})
+	assert.Error(t, err)
+	_, err = Generate(Options{ProxyAddr: "127.0.0.1:7448", Default: "reject"})
+	assert.Error(t, err)
+	_, err = Generate(Options{ProxyAddr: "127.0.0.1:7448", Direct: []string{"10.0.0.0/33"}})
+	assert.Error(t, err)
+	_, err = Generate(Options{ProxyAddr: "127.0.0.1:7448", Proxy: []string{"fc00::/7"}})
+	assert.Error(t, err)
+	_, err = Generate(Options{ProxyAddr: "127.0.0.1:7448", Proxy: []string{"::1"}})
+	assert.Error(t, err)
+}
+
+func TestServer(t *testing.T) {
+	s := NewServer([]byte("old"))
+	s.SetContent([]byte("function FindProxyForURL(url, host) {}"))
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", "/proxy.pac", nil))
+	body, _ := io.ReadAll(rec.Body)
+	assert.Equal(t, "application/x-ns-proxy-autoconfig", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "function FindProxyForURL(url, host) {}", string(body))
+}
@@ -0,0 +1,130 @@
+package route
+
+import (
+	"errors"
+	"net"
+	"strings"
+)
+
+// Geo 提供 geoip 与 geosite 规则所需的本地离线数据，运行时不需要访问网络
+type Geo struct {
+	IPDB       *MMDB  // GeoIP 数据库，为 nil 时只支持 geoip:private
+	GeositeDir string // geosite 域名列表所在目录
+	sites      map[string]*DomainSet
+}
+
+// LoadGeo 加载 GeoIP 数据库并指定 geosite 目录，参数为空表示不启用对应数据
+func LoadGeo(geoipPath, geositeDir string) (*Geo, error) {
+	geo := &Geo{
+		GeositeDir: geositeDir,
+		sites:      make(map[string]*DomainSet),
+	}
+	if geoipPath != "" {
+		db, err := OpenMMDB(geoipPath)
+		if err != nil {
+			return nil, err
+		}
+		geo.IPDB = db
+	}
+	return geo, nil
+}
+
+// DomainSet 返回 geosite 分类对应的域名集合，spec 形如 "google" 或 "google@ads"
+func (g *Geo) DomainSet(spec string) (*DomainSet, error) {
+	if g == nil || g.GeositeDir == "" {
+		return nil, errors.New("未配置 geosite 目录")
+	}
+	spec = strings.ToLower(spec)
+	if set, ok := g.sites[spec]; ok {
+		return set, nil
+	}
+
+	name, attr, _ := strings.Cut(spec, "@")
+	set, err := LoadDomainList(g.GeositeDir, name, attr)
+	if err != nil {
+		return nil, err
+	}
+	if g.sites == nil {
+		g.sites = make(map[string]*DomainSet)
+	}
+	g.sites[spec] = set
+	return set, nil
+}
+
+// PrivateNets 是环回、链路本地、私有与保留地址段，即 geoip:private 匹配的范围，
+// 也是服务端出站策略默认禁止的范围。169.254.0.0/16 与 100.64.0.0/10 覆盖了常见云厂商的元数据服务地址
+var PrivateNets = mustParseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets
+}
+
+func newGeoIPMatcher(geo *Geo, country string) (matcher, error) {
+	country = strings.ToLower(country)
+	if country == "private" {
+		return privateMatcher{}, nil
+	}
+	if geo == nil || geo.IPDB == nil {
+		return nil, errors.New("未配置 GeoIP 数据库")
+	}
+	return &geoIPMatcher{db: geo.IPDB, country: country}, nil
+}
+
+type privateMatcher struct{}
+
+func (privateMatcher) Match(m *Metadata) bool {
+	if m.DstIP == nil {
+		return false
+	}
+	for _, ipNet := range PrivateNets {
+		if ipNet.Contains(m.DstIP) {
+			return true
+		}
+	}
+	return false
+}
+
+// geoIPMatcher 与 ip-cidr 一样只匹配 IP 目标，不会为域名发起 DNS 解析
+type geoIPMatcher struct {
+	db      *MMDB
+	country string
+}
+
+func (g *geoIPMatcher) Match(m *Metadata) bool {
+	if m.DstIP == nil {
+		return false
+	}
+	country, err := g.db.Country(m.DstIP)
+	return err == nil && country == g.country
+}
+
+type geositeMatcher struct {
+	set *DomainSet
+}
+
+func (g *geositeMatcher) Match(m *Metadata) bool {
+	return m.Host != "" && g.set.Match(m.Host)
+}
@@ -0,0 +1,210 @@
+package route
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// encodeMMDBString 按 MaxMind DB 格式编码短字符串
+func encodeMMDBString(s string) []byte {
+	return append([]byte{0x40 | byte(len(s))}, s...)
+}
+
+// buildTestMMDB 构造一个 record_size 为 24 的 IPv6 MaxMind DB，networks 为网段到国家代码的映射
+func buildTestMMDB(t *testing.T, networks map[string]string) []byte {
+	t.Helper()
+
+	// 数据段：每个国家一条 {"country": {"iso_code": code}} 记录，
+	// 第二条及以后的记录通过指针复用第一条记录中的 "country" 键
+	var data bytes.Buffer
+	offsets := make(map[string]int)
+	keyOffset := -1
+	for _, code := range networks {
+		if _, ok := offsets[code]; ok {
+			continue
+		}
+		offsets[code] = data.Len()
+		data.WriteByte(0xE0 | 1)
+		if keyOffset < 0 {
+			keyOffset = data.Len()
+			data.Write(encodeMMDBString("country"))
+		} else {
+			data.Write([]byte{0x20, byte(keyOffset)})
+		}
+		data.WriteByte(0xE0 | 1)
+		data.Write(encodeMMDBString("iso_code"))
+		data.Write(encodeMMDBString(code))
+	}
+
+	// 搜索树：-1 表示空记录，-2-offset 表示指向数据段 offset 处
+	nodes := [][2]int{{-1, -1}}
+	for cidr, code := range networks {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		require.NoError(t, err)
+		ones, bits := ipNet.Mask.Size()
+		ip := ipNet.IP.To16()
+		if bits == 32 {
+			// IPv4 网段位于 IPv6 搜索树的 ::/96 之下
+			ip = append(make(net.IP, 12), ipNet.IP.To4()...)
+			ones += 96
+		}
+		node := 0
+		for i := 0; i < ones; i++ {
+			bit := (ip[i>>3] >> (7 - uint(i&7))) & 1
+			if i == ones-1 {
+				nodes[node][bit] = -2 - offsets[code]
+				break
+			}
+			if nodes[node][bit] < 0 {
+				nodes = append(nodes, [2]int{-1, -1})
+				nodes[node][bit] = len(nodes) - 1
+			}
+			node = nodes[node][bit]
+		}
+	}
+
+	nodeCount := len(nodes)
+	var buf bytes.Buffer
+	for _, n := range nodes {
+		for _, r := range n {
+			v := r
+			switch {
+			case r == -1:
+				v = nodeCount
+			case r <= -2:
+				v = nodeCount + 16 + (-2 - r)
+			}
+			buf.Write([]byte{byte(v >> 16), byte(v >> 8), byte(v)})
+		}
+	}
+	buf.Write(make([]byte, 16))
+	buf.Write(data.Bytes())
+
+	buf.Write(mmdbMetadataMarker)
+	buf.WriteByte(0xE0 | 3)
+	buf.Write(encodeMMDBString("node_count"))
+	buf.Write([]byte{0xC0 | 4, byte(nodeCount >> 24), byte(nodeCount >> 16), byte(nodeCount >> 8), byte(nodeCount)})
+	buf.Write(encodeMMDBString("record_size"))
+	buf.Write([]byte{0xA0 | 1, 24})
+	buf.Write(encodeMMDBString("ip_version"))
+	buf.Write([]byte{0xA0 | 1, 6})
+	return buf.Bytes()
+}
+
+func TestMMDB_Country(t *testing.T) {
+	db, err := NewMMDB(buildTestMMDB(t, map[string]string{
+		"1.0.1.0/24":    "CN",
+		"8.8.8.0/24":    "US",
+		"2001:db8::/32": "JP",
+	}))
+	require.NoError(t, err)
+
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"1.0.1.1", "cn"},
+		{"8.8.8.8", "us"},
+		{"2001:db8::1", "jp"},
+		{"9.9.9.9", ""},
+		{"2001:db9::1", ""},
+	}
+	for _, tt := range tests {
+		got, err := db.Country(net.ParseIP(tt.ip))
+		assert.NoError(t, err, tt.ip)
+		assert.Equal(t, tt.want, got, tt.ip)
+	}
+
+	_, err = NewMMDB([]byte("not a database"))
+	assert.Error(t, err)
+}
+
+func TestMMDBDecoder_Corrupt(t *testing.T) {
+	nested := bytes.Repeat([]byte{0x01, 0x04}, 100) // 100 层长度为 1 的 array
+	nested = append(nested, encodeMMDBString("x")...)
+
+	tests := map[string][]byte{
+		"指向自身的指针":        {0x20, 0x00},
+		"map 长度超出剩余数据":   {0xE0 | 29, 0xFF},
+		"array 长度超出剩余数据": {0x1E, 0x04, 0xFF, 0xFF},
+		"嵌套层数过多":         nested,
+	}
+	for name, buf := range tests {
+		_, _, err := (&mmdbDecoder{buf: buf}).decode(0, 0)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestLoadDomainList(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ads"), []byte(`
+# 广告域名
+doubleclick.net
+full:ads.example.com
+keyword:adservice
+regexp:^ad[0-9]+\.
+include:tracker
+`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "tracker.txt"), []byte(`
+domain:tracker.io @ads
+include:ads
+`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "google"), []byte(`
+google.com
+googleadservices.com @ads
+`), 0644))
+
+	set, err := LoadDomainList(dir, "ads", "")
+	require.NoError(t, err)
+	assert.Equal(t, 5, set.Len())
+	for _, domain := range []string{"doubleclick.net", "stats.doubleclick.net", "ads.example.com", "pagead-adservice.com", "ad12.foo.net", "x.tracker.io"} {
+		assert.True(t, set.Match(domain), domain)
+	}
+	for _, domain := range []string{"www.ads.example.com", "notdoubleclick.net", "example.com"} {
+		assert.False(t, set.Match(domain), domain)
+	}
+
+	set, err = LoadDomainList(dir, "google", "ads")
+	require.NoError(t, err)
+	assert.True(t, set.Match("www.googleadservices.com"))
+	assert.False(t, set.Match("www.google.com"))
+
+	_, err = LoadDomainList(dir, "missing", "")
+	assert.Error(t, err)
+}
+
+func TestRouter_GeoRules(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ads"), []byte("doubleclick.net\n"), 0644))
+	dbPath := filepath.Join(dir, "Country.mmdb")
+	require.NoError(t, os.WriteFile(dbPath, buildTestMMDB(t, map[string]string{"1.0.1.0/24": "CN"}), 0644))
+
+	geo, err := LoadGeo(dbPath, dir)
+	require.NoError(t, err)
+	r, err := New([]string{
+		"geosite:ads -> reject",
+		"geoip:private -> direct",
+		"geoip:cn -> direct",
+	}, geo)
+	require.NoError(t, err)
+
+	_, action := r.Match(&Metadata{Host: "ad.doubleclick.net"})
+	assert.Equal(t, ActionReject, action)
+	_, action = r.Match(&Metadata{DstIP: net.ParseIP("192.168.1.1")})
+	assert.Equal(t, ActionDirect, action)
+	_, action = r.Match(&Metadata{DstIP: net.ParseIP("1.0.1.8")})
+	assert.Equal(t, ActionDirect, action)
+	_, action = r.Match(&Metadata{DstIP: net.ParseIP("8.8.8.8")})
+	assert.Equal(t, ActionProxy, action)
+
+	_, err = New([]string{"geoip:cn -> direct"}, nil)
+	assert.Error(t, err, "未配置数据库时 geoip 规则应报错")
+	_, err = New([]string{"geoip:private -> direct"}, nil)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,167 @@
+package route
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// DomainSet 是一组域名匹配条件，对应 geosite 中的一个分类
+type DomainSet struct {
+	full     map[string]struct{}
+	suffixes map[string]struct{}
+	keywords []string
+	regexps  []*regexp.Regexp
+}
+
+func newDomainSet() *DomainSet {
+	return &DomainSet{
+		full:     make(map[string]struct{}),
+		suffixes: make(map[string]struct{}),
+	}
+}
+
+// Len 返回域名条目数量
+func (s *DomainSet) Len() int {
+	return len(s.full) + len(s.suffixes) + len(s.keywords) + len(s.regexps)
+}
+
+// Match 判断域名是否属于该集合
+func (s *DomainSet) Match(domain string) bool {
+	domain = normalizeDomain(domain)
+	if _, ok := s.full[domain]; ok {
+		return true
+	}
+	for d := domain; d != ""; {
+		if _, ok := s.suffixes[d]; ok {
+			return true
+		}
+		_, rest, found := strings.Cut(d, ".")
+		if !found {
+			break
+		}
+		d = rest
+	}
+	for _, keyword := range s.keywords {
+		if strings.Contains(domain, keyword) {
+			return true
+		}
+	}
+	for _, re := range s.regexps {
+		if re.MatchString(domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewDomainSet 由条目列表创建域名集合，条目格式与域名列表文件相同，不支持 "include:"
+func NewDomainSet(entries []string) (*DomainSet, error) {
+	set := newDomainSet()
+	for _, entry := range entries {
+		kind, value, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok {
+			kind, value = "domain", kind
+		}
+		if err := set.add(kind, value); err != nil {
+			return nil, fmt.Errorf("域名条目 %q %w", entry, err)
+		}
+	}
+	return set, nil
+}
+
+// add 添加一个条目，kind 为 domain、full、keyword 或 regexp
+func (s *DomainSet) add(kind, value string) error {
+	switch kind {
+	case "domain":
+		s.suffixes[normalizeDomain(value)] = struct{}{}
+	case "full":
+		s.full[normalizeDomain(value)] = struct{}{}
+	case "keyword":
+		s.keywords = append(s.keywords, strings.ToLower(value))
+	case "regexp":
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return fmt.Errorf("正则无效: %w", err)
+		}
+		s.regexps = append(s.regexps, re)
+	default:
+		return fmt.Errorf("类型未知: %q", kind)
+	}
+	return nil
+}
+
+// LoadDomainList 按 v2fly domain-list-community 的文本格式加载 dir 目录下名为 name 的域名列表
+//
+// 每行一个条目，支持 "domain:"（后缀，默认）、"full:"、"keyword:"、"regexp:" 前缀，
+// "include:" 引用同目录下的其他列表，"#" 之后为注释，"@" 之后为属性。
+// attr 非空时只保留带有该属性的条目。
+func LoadDomainList(dir, name, attr string) (*DomainSet, error) {
+	set := newDomainSet()
+	if err := loadDomainList(set, dir, name, attr, make(map[string]bool)); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+func loadDomainList(set *DomainSet, dir, name, attr string, visited map[string]bool) error {
+	name = strings.ToLower(name)
+	if visited[name] {
+		return nil
+	}
+	visited[name] = true
+
+	path := filepath.Join(dir, name)
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		file, err = os.Open(path + ".txt")
+	}
+	if err != nil {
+		return fmt.Errorf("打开域名列表 %s 失败: %w", name, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		entry := fields[0]
+		if attr != "" && !hasAttr(fields[1:], attr) && !strings.HasPrefix(entry, "include:") {
+			continue
+		}
+
+		kind, value, ok := strings.Cut(entry, ":")
+		if !ok {
+			kind, value = "domain", entry
+		}
+		if kind == "include" {
+			if err := loadDomainList(set, dir, value, attr, visited); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := set.add(kind, value); err != nil {
+			return fmt.Errorf("域名列表 %s 第 %d 行%w", name, lineNo, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("读取域名列表 %s 失败: %w", name, err)
+	}
+	return nil
+}
+
+func hasAttr(fields []string, attr string) bool {
+	for _, f := range fields {
+		if strings.EqualFold(strings.TrimPrefix(f, "@"), attr) {
+			return true
+		}
+	}
+	return false
+}
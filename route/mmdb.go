@@ -0,0 +1,349 @@
+package route
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"strings"
+)
+
+// mmdbMetadataMarker 标记 MaxMind DB 文件中元数据段的起始位置
+var mmdbMetadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// MMDB 是 MaxMind DB 格式（GeoLite2-Country、Country.mmdb 等）的只读解析器，
+// 只实现了国家代码查询所需的部分
+type MMDB struct {
+	buf        []byte
+	data       []byte // 数据段
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	ipv4Start  uint // IPv6 数据库中 ::/96 对应的节点，用于查询 IPv4 地址
+}
+
+// OpenMMDB 从文件加载 MaxMind DB
+func OpenMMDB(path string) (*MMDB, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取 GeoIP 数据库失败: %w", err)
+	}
+	db, err := NewMMDB(buf)
+	if err != nil {
+		return nil, fmt.Errorf("解析 GeoIP 数据库 %s 失败: %w", path, err)
+	}
+	return db, nil
+}
+
+// NewMMDB 从内存中的数据创建 MaxMind DB 解析器
+func NewMMDB(buf []byte) (*MMDB, error) {
+	idx := bytes.LastIndex(buf, mmdbMetadataMarker)
+	if idx < 0 {
+		return nil, errors.New("找不到元数据段，不是有效的 MaxMind DB 文件")
+	}
+	metaStart := idx + len(mmdbMetadataMarker)
+	raw, _, err := (&mmdbDecoder{buf: buf[metaStart:]}).decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("解析元数据失败: %w", err)
+	}
+	meta, ok := raw.(map[string]any)
+	if !ok {
+		return nil, errors.New("元数据格式错误")
+	}
+
+	db := &MMDB{
+		buf:        buf,
+		nodeCount:  uint(toUint(meta["node_count"])),
+		recordSize: uint(toUint(meta["record_size"])),
+		ipVersion:  uint(toUint(meta["ip_version"])),
+	}
+	switch db.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("不支持的记录长度 %d", db.recordSize)
+	}
+
+	treeSize := db.nodeCount * db.recordSize / 4
+	dataStart := treeSize + 16
+	if dataStart > uint(idx) {
+		return nil, errors.New("搜索树长度超出文件范围")
+	}
+	db.data = buf[dataStart:idx]
+
+	if db.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < db.nodeCount; i++ {
+			node = db.readNode(node, 0)
+		}
+		db.ipv4Start = node
+	}
+	return db, nil
+}
+
+// Country 返回 IP 所属国家的 ISO 代码（小写），找不到时返回空字符串
+func (db *MMDB) Country(ip net.IP) (string, error) {
+	record, err := db.Lookup(ip)
+	if err != nil || record == nil {
+		return "", err
+	}
+	m, _ := record.(map[string]any)
+	for _, key := range []string{"country", "registered_country", "represented_country"} {
+		if c, ok := m[key].(map[string]any); ok {
+			if code, ok := c["iso_code"].(string); ok && code != "" {
+				return strings.ToLower(code), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// Lookup 返回 IP 对应的原始记录，找不到时返回 nil
+func (db *MMDB) Lookup(ip net.IP) (any, error) {
+	node, bitCount := uint(0), 128
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		bitCount = 32
+		if db.ipVersion == 6 {
+			node = db.ipv4Start
+		}
+	} else if db.ipVersion == 4 {
+		return nil, errors.New("IPv4 数据库无法查询 IPv6 地址")
+	}
+
+	for i := 0; i < bitCount && node < db.nodeCount; i++ {
+		bit := (ip[i>>3] >> (7 - uint(i&7))) & 1
+		node = db.readNode(node, uint(bit))
+	}
+	switch {
+	case node == db.nodeCount:
+		return nil, nil
+	case node > db.nodeCount:
+		offset := node - db.nodeCount - 16
+		if offset >= uint(len(db.data)) {
+			return nil, errors.New("数据指针超出范围")
+		}
+		record, _, err := (&mmdbDecoder{buf: db.data}).decode(offset, 0)
+		return record, err
+	default:
+		return nil, errors.New("搜索树数据不完整")
+	}
+}
+
+// readNode 读取节点的左（bit=0）或右（bit=1）记录
+func (db *MMDB) readNode(node, bit uint) uint {
+	switch db.recordSize {
+	case 24:
+		b := db.buf[node*6+bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		b := db.buf[node*7:]
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(db.buf[node*8+bit*4:]))
+	}
+}
+
+// MaxMind DB 数据段中的字段类型
+const (
+	mmdbExtended  = 0
+	mmdbPointer   = 1
+	mmdbString    = 2
+	mmdbDouble    = 3
+	mmdbBytes     = 4
+	mmdbUint16    = 5
+	mmdbUint32    = 6
+	mmdbMap       = 7
+	mmdbInt32     = 8
+	mmdbUint64    = 9
+	mmdbUint128   = 10
+	mmdbArray     = 11
+	mmdbContainer = 12
+	mmdbEnd       = 13
+	mmdbBool      = 14
+	mmdbFloat     = 15
+)
+
+// mmdbMaxDepth 限制指针与嵌套 map、array 的层数，防止损坏的数据库造成无限递归
+const mmdbMaxDepth = 32
+
+var errMMDBTruncated = errors.New("数据段被截断")
+
+type mmdbDecoder struct {
+	buf []byte
+}
+
+// decode 解码 offset 处的值，返回值以及下一个字段的偏移量，depth 为当前的指针与嵌套层数
+func (d *mmdbDecoder) decode(offset, depth uint) (any, uint, error) {
+	if depth > mmdbMaxDepth {
+		return nil, 0, errors.New("数据嵌套层数过多")
+	}
+	typ, size, offset, err := d.decodeControl(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if typ == mmdbPointer {
+		ptr, next, err := d.decodePointer(size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decode(ptr, depth+1)
+		return value, next, err
+	}
+
+	// 声明的长度不能超过剩余数据：map 的每个键值对至少占 2 字节，array 的每个元素至少占 1 字节
+	remain := uint(len(d.buf)) - offset
+	end := offset + size
+	switch typ {
+	case mmdbBool:
+	case mmdbMap:
+		if size > remain/2 {
+			return nil, 0, errMMDBTruncated
+		}
+	default:
+		if size > remain {
+			return nil, 0, errMMDBTruncated
+		}
+	}
+
+	switch typ {
+	case mmdbString:
+		return string(d.buf[offset:end]), end, nil
+	case mmdbBytes:
+		return append([]byte(nil), d.buf[offset:end]...), end, nil
+	case mmdbDouble:
+		if size != 8 {
+			return nil, 0, errors.New("double 长度错误")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(d.buf[offset:end])), end, nil
+	case mmdbFloat:
+		if size != 4 {
+			return nil, 0, errors.New("float 长度错误")
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(d.buf[offset:end])), end, nil
+	case mmdbUint16, mmdbUint32, mmdbUint64, mmdbInt32:
+		var v uint64
+		for _, b := range d.buf[offset:end] {
+			v = v<<8 | uint64(b)
+		}
+		if typ == mmdbInt32 {
+			return int32(v), end, nil
+		}
+		return v, end, nil
+	case mmdbUint128:
+		// 国家查询用不到 uint128，原样返回字节
+		return append([]byte(nil), d.buf[offset:end]...), end, nil
+	case mmdbBool:
+		return size != 0, offset, nil
+	case mmdbMap:
+		m := make(map[string]any, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.New("map 的键不是字符串")
+			}
+			value, next, err := d.decode(next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[k] = value
+			offset = next
+		}
+		return m, offset, nil
+	case mmdbArray:
+		a := make([]any, 0, size)
+		for i := uint(0); i < size; i++ {
+			value, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+			offset = next
+		}
+		return a, offset, nil
+	default:
+		return nil, 0, fmt.Errorf("不支持的字段类型 %d", typ)
+	}
+}
+
+// decodeControl 解析控制字节，返回字段类型、长度以及数据起始偏移量
+func (d *mmdbDecoder) decodeControl(offset uint) (typ, size, next uint, err error) {
+	if offset >= uint(len(d.buf)) {
+		return 0, 0, 0, errMMDBTruncated
+	}
+	ctrl := d.buf[offset]
+	offset++
+
+	typ = uint(ctrl >> 5)
+	if typ == mmdbExtended {
+		if offset >= uint(len(d.buf)) {
+			return 0, 0, 0, errMMDBTruncated
+		}
+		typ = 7 + uint(d.buf[offset])
+		offset++
+	}
+	size = uint(ctrl & 0x1f)
+	if typ == mmdbPointer || size < 29 {
+		return typ, size, offset, nil
+	}
+
+	extra := size - 28
+	if offset+extra > uint(len(d.buf)) {
+		return 0, 0, 0, errMMDBTruncated
+	}
+	var v uint
+	for _, b := range d.buf[offset : offset+extra] {
+		v = v<<8 | uint(b)
+	}
+	switch size {
+	case 29:
+		size = 29 + v
+	case 30:
+		size = 285 + v
+	default:
+		size = 65821 + v
+	}
+	return typ, size, offset + extra, nil
+}
+
+// decodePointer 解析指针字段，size 为控制字节的低 5 位
+func (d *mmdbDecoder) decodePointer(size, offset uint) (ptr, next uint, err error) {
+	n := (size >> 3) + 1
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, errMMDBTruncated
+	}
+	var v uint
+	if n != 4 {
+		v = size & 0x7
+	}
+	for _, b := range d.buf[offset : offset+n] {
+		v = v<<8 | uint(b)
+	}
+	switch n {
+	case 2:
+		v += 2048
+	case 3:
+		v += 526336
+	}
+	return v, offset + n, nil
+}
+
+func toUint(v any) uint64 {
+	switch n := v.(type) {
+	case uint64:
+		return n
+	case int32:
+		return uint64(n)
+	}
+	return 0
+}
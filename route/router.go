@@ -0,0 +1,66 @@
+package route
+
+import (
+	"sync"
+)
+
+// DefaultAction 是没有任何规则命中时采用的动作，与引入路由前的行为保持一致
+const DefaultAction = ActionProxy
+
+// Router 按顺序评估路由规则，第一条命中的规则决定连接的去向
+type Router struct {
+	mu    sync.RWMutex
+	rules []*Rule
+}
+
+// New 根据规则文本创建路由器，geo 为 geoip 与 geosite 规则提供数据，可以为 nil
+func New(rules []string, geo *Geo) (*Router, error) {
+	parsed, err := ParseRules(rules, geo)
+	if err != nil {
+		return nil, err
+	}
+	r := &Router{}
+	r.Reload(parsed)
+	return r, nil
+}
+
+// Reload 以解析好的规则替换当前规则。Reload 本身不做校验，调用方需先用 ParseRules
+// 解析并校验全部规则，出错时不调用 Reload 即可保留原有规则
+func (r *Router) Reload(rules []*Rule) {
+	r.mu.Lock()
+	r.rules = rules
+	r.mu.Unlock()
+}
+
+// ParseRules 批量解析规则文本
+func ParseRules(rules []string, geo *Geo) ([]*Rule, error) {
+	parsed := make([]*Rule, 0, len(rules))
+	for _, raw := range rules {
+		rule, err := ParseRule(raw, geo)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, rule)
+	}
+	return parsed, nil
+}
+
+// Rules 返回当前生效的规则列表
+func (r *Router) Rules() []*Rule {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]*Rule(nil), r.rules...)
+}
+
+// Match 返回第一条命中的规则及其动作，没有规则命中时返回 nil 和 DefaultAction
+func (r *Router) Match(m *Metadata) (*Rule, Action) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, rule := range r.rules {
+		if rule.Match(m) {
+			return rule, rule.Action
+		}
+	}
+	return nil, DefaultAction
+}
@@ -0,0 +1,106 @@
+package route
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRule(t *testing.T) {
+	rule, err := ParseRule("domain-suffix:Google.com. -> proxy", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "domain-suffix", rule.Type)
+	assert.Equal(t, ActionProxy, rule.Action)
+	assert.True(t, rule.Match(&Metadata{Host: "www.google.com"}))
+	assert.True(t, rule.Match(&Metadata{Host: "google.com"}))
+	assert.False(t, rule.Match(&Metadata{Host: "fakegoogle.com"}))
+
+	for _, raw := range []string{
+		"domain-suffix:google.com",
+		"domain-suffix: -> proxy",
+		"unknown:x -> proxy",
+		"ip-cidr:10.0.0.0/33 -> direct",
+		"dst-port:90-80 -> direct",
+		"domain-regex:( -> reject",
+		"final ->",
+	} {
+		_, err := ParseRule(raw, nil)
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestRouter_Match(t *testing.T) {
+	r, err := New([]string{
+		"domain:ads.example.com -> reject",
+		"domain-suffix:example.com -> direct",
+		"domain-keyword:google -> hk",
+		"domain-regex:^api[0-9]+\\. -> reject",
+		"ip-cidr:192.168.0.0/16 -> direct",
+		"src-cidr:10.0.0.5 -> reject",
+		"dst-port:8000-8999 -> direct",
+		"final -> proxy",
+	}, nil)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name string
+		meta *Metadata
+		want Action
+	}{
+		{"完全匹配优先于后缀", &Metadata{Host: "ads.example.com", DstPort: 443}, ActionReject},
+		{"后缀匹配", &Metadata{Host: "www.example.com", DstPort: 443}, ActionDirect},
+		{"关键字指向具名服务器", &Metadata{Host: "www.google.com.hk", DstPort: 443}, Action("hk")},
+		{"正则匹配", &Metadata{Host: "api12.foo.net", DstPort: 443}, ActionReject},
+		{"IP 网段", &Metadata{DstIP: net.ParseIP("192.168.1.1"), DstPort: 80}, ActionDirect},
+		{"域名不触发 IP 规则", &Metadata{Host: "router.lan", DstPort: 80}, ActionProxy},
+		{"来源地址", &Metadata{Host: "foo.net", DstPort: 443, SrcIP: net.ParseIP("10.0.0.5")}, ActionReject},
+		{"端口范围", &Metadata{Host: "foo.net", DstPort: 8080}, ActionDirect},
+		{"兜底规则", &Metadata{Host: "foo.net", DstPort: 443}, ActionProxy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, got := r.Match(tt.meta)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRouter_FirstMatchWins(t *testing.T) {
+	r, err := New([]string{
+		"domain-suffix:example.com -> direct",
+		"domain:www.example.com -> reject",
+	}, nil)
+	assert.NoError(t, err)
+
+	rule, action := r.Match(&Metadata{Host: "www.example.com"})
+	assert.Equal(t, ActionDirect, action)
+	assert.Equal(t, "domain-suffix:example.com -> direct", rule.String())
+
+	rule, action = r.Match(&Metadata{Host: "other.net"})
+	assert.Nil(t, rule)
+	assert.Equal(t, DefaultAction, action)
+}
+
+func TestRouter_Reload(t *testing.T) {
+	r, err := New([]string{"final -> direct"}, nil)
+	assert.NoError(t, err)
+
+	_, err = ParseRules([]string{"final -> proxy", "bad rule"}, nil)
+	assert.Error(t, err)
+	_, action := r.Match(&Metadata{Host: "foo.net"})
+	assert.Equal(t, ActionDirect, action, "无效规则不应替换原有规则")
+
+	rules, err := ParseRules([]string{"final -> reject"}, nil)
+	assert.NoError(t, err)
+	r.Reload(rules)
+	_, action = r.Match(&Metadata{Host: "foo.net"})
+	assert.Equal(t, ActionReject, action)
+}
+
+func TestAction_IsServer(t *testing.T) {
+	assert.False(t, ActionProxy.IsServer())
+	assert.False(t, ActionDirect.IsServer())
+	assert.False(t, ActionReject.IsServer())
+	assert.True(t, Action("hk").IsServer())
+}
@@ -0,0 +1,267 @@
+package route
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Action 表示规则命中后对连接的处理方式
+type Action string
+
+const (
+	ActionProxy  Action = "proxy"  // 经默认远程服务器转发
+	ActionDirect Action = "direct" // 本地直连目标
+	ActionReject Action = "reject" // 拒绝连接
+)
+
+// IsServer 判断该动作是否指向一个具名远程服务器
+func (a Action) IsServer() bool {
+	switch a {
+	case ActionProxy, ActionDirect, ActionReject:
+		return false
+	}
+	return a != ""
+}
+
+// Metadata 描述一次待路由的连接
+type Metadata struct {
+	Host    string // 目标域名，目标为 IP 时为空
+	DstIP   net.IP // 目标 IP，目标为域名时为空
+	DstPort int    // 目标端口
+	SrcIP   net.IP // 发起连接的客户端地址
+}
+
+// Target 返回用于日志展示的目标地址
+func (m *Metadata) Target() string {
+	host := m.Host
+	if host == "" && m.DstIP != nil {
+		host = m.DstIP.String()
+	}
+	return net.JoinHostPort(host, strconv.Itoa(m.DstPort))
+}
+
+// matcher 判断连接是否满足某条规则的条件
+type matcher interface {
+	Match(m *Metadata) bool
+}
+
+// Rule 表示一条路由规则，格式为 "类型:值 -> 动作"，例如 "domain-suffix:google.com -> proxy"
+type Rule struct {
+	Raw    string // 原始规则文本
+	Type   string // 规则类型
+	Value  string // 规则值
+	Action Action // 命中后的动作
+	m      matcher
+}
+
+// Match 判断连接是否命中该规则
+func (r *Rule) Match(m *Metadata) bool {
+	return r.m.Match(m)
+}
+
+func (r *Rule) String() string {
+	return r.Raw
+}
+
+// ParseRule 解析一条规则文本
+//
+// 支持的类型：
+//   - domain:        域名完全匹配
+//   - domain-suffix: 域名后缀匹配，google.com 同时匹配 google.com 与 www.google.com
+//   - domain-keyword:域名包含关键字
+//   - domain-regex:  域名正则匹配
+//   - ip-cidr:       目标 IP 属于网段，目标为域名时不做解析，不会命中
+//   - dst-port:      目标端口，支持单个端口或 "起始-结束" 范围
+//   - src-cidr:      客户端来源地址属于网段，也可以是单个 IP
+//   - geoip:         目标 IP 所属国家代码，例如 geoip:cn；geoip:private 匹配内网地址
+//   - geosite:       目标域名属于 geosite 分类，例如 geosite:ads 或 geosite:google@ads
+//   - final:         兜底规则，匹配所有连接，无需填写值
+//
+// 动作可以是 proxy、direct、reject，或者配置中某个具名服务器的名称。
+// geo 提供 geoip 与 geosite 规则所需的数据，不使用这两类规则时可以为 nil。
+func ParseRule(raw string, geo *Geo) (*Rule, error) {
+	cond, action, ok := strings.Cut(raw, "->")
+	if !ok {
+		return nil, fmt.Errorf("规则 %q 缺少 \"->\"", raw)
+	}
+	cond = strings.TrimSpace(cond)
+	rule := &Rule{
+		Raw:    strings.TrimSpace(raw),
+		Action: Action(strings.TrimSpace(action)),
+	}
+	if rule.Action == "" {
+		return nil, fmt.Errorf("规则 %q 缺少动作", raw)
+	}
+
+	typ, value, _ := strings.Cut(cond, ":")
+	rule.Type = strings.ToLower(strings.TrimSpace(typ))
+	rule.Value = strings.TrimSpace(value)
+	if rule.Type != "final" && rule.Value == "" {
+		return nil, fmt.Errorf("规则 %q 缺少匹配值", raw)
+	}
+
+	m, err := newMatcher(rule.Type, rule.Value, geo)
+	if err != nil {
+		return nil, fmt.Errorf("规则 %q 无效: %w", raw, err)
+	}
+	rule.m = m
+	return rule, nil
+}
+
+func newMatcher(typ, value string, geo *Geo) (matcher, error) {
+	switch typ {
+	case "domain":
+		return domainMatcher(normalizeDomain(value)), nil
+	case "domain-suffix":
+		return domainSuffixMatcher(normalizeDomain(value)), nil
+	case "domain-keyword":
+		return domainKeywordMatcher(strings.ToLower(value)), nil
+	case "domain-regex":
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return nil, err
+		}
+		return &domainRegexMatcher{re: re}, nil
+	case "ip-cidr":
+		ipNet, err := ParseCIDR(value)
+		if err != nil {
+			return nil, err
+		}
+		return &ipCIDRMatcher{ipNet: ipNet}, nil
+	case "src-cidr":
+		ipNet, err := ParseCIDR(value)
+		if err != nil {
+			return nil, err
+		}
+		return &ipCIDRMatcher{ipNet: ipNet, src: true}, nil
+	case "dst-port":
+		r, err := ParsePortRange(value)
+		if err != nil {
+			return nil, err
+		}
+		return portRangeMatcher{r}, nil
+	case "geoip":
+		return newGeoIPMatcher(geo, value)
+	case "geosite":
+		set, err := geo.DomainSet(value)
+		if err != nil {
+			return nil, err
+		}
+		return &geositeMatcher{set: set}, nil
+	case "final":
+		return finalMatcher{}, nil
+	default:
+		return nil, fmt.Errorf("未知的规则类型 %q", typ)
+	}
+}
+
+// normalizeDomain 统一域名格式：转为小写并去掉末尾的点
+func normalizeDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(domain), ".")
+}
+
+// ParseCIDR 解析网段，单个 IP 视为 /32 或 /128
+func ParseCIDR(value string) (*net.IPNet, error) {
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("无效的 IP 地址 %q", value)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, ipNet, err := net.ParseCIDR(value)
+	if err != nil {
+		return nil, fmt.Errorf("无效的网段 %q: %w", value, err)
+	}
+	return ipNet, nil
+}
+
+type domainMatcher string
+
+func (d domainMatcher) Match(m *Metadata) bool {
+	return m.Host != "" && normalizeDomain(m.Host) == string(d)
+}
+
+type domainSuffixMatcher string
+
+func (d domainSuffixMatcher) Match(m *Metadata) bool {
+	if m.Host == "" {
+		return false
+	}
+	host := normalizeDomain(m.Host)
+	return host == string(d) || strings.HasSuffix(host, "."+string(d))
+}
+
+type domainKeywordMatcher string
+
+func (d domainKeywordMatcher) Match(m *Metadata) bool {
+	return m.Host != "" && strings.Contains(normalizeDomain(m.Host), string(d))
+}
+
+type domainRegexMatcher struct {
+	re *regexp.Regexp
+}
+
+func (d *domainRegexMatcher) Match(m *Metadata) bool {
+	return m.Host != "" && d.re.MatchString(normalizeDomain(m.Host))
+}
+
+type ipCIDRMatcher struct {
+	ipNet *net.IPNet
+	src   bool // 为 true 时匹配来源地址，否则匹配目标地址
+}
+
+func (c *ipCIDRMatcher) Match(m *Metadata) bool {
+	ip := m.DstIP
+	if c.src {
+		ip = m.SrcIP
+	}
+	return ip != nil && c.ipNet.Contains(ip)
+}
+
+// PortRange 是闭区间 [From, To] 内的端口
+type PortRange struct {
+	From, To int
+}
+
+// Contains 判断端口是否在范围内
+func (p PortRange) Contains(port int) bool {
+	return port >= p.From && port <= p.To
+}
+
+// ParsePortRange 解析单个端口或 "起始-结束" 范围
+func ParsePortRange(value string) (PortRange, error) {
+	fromStr, toStr, isRange := strings.Cut(value, "-")
+	from, err := strconv.Atoi(strings.TrimSpace(fromStr))
+	if err != nil || from < 0 || from > 65535 {
+		return PortRange{}, fmt.Errorf("无效的端口 %q", value)
+	}
+	to := from
+	if isRange {
+		to, err = strconv.Atoi(strings.TrimSpace(toStr))
+		if err != nil || to < from || to > 65535 {
+			return PortRange{}, fmt.Errorf("无效的端口范围 %q", value)
+		}
+	}
+	return PortRange{From: from, To: to}, nil
+}
+
+type portRangeMatcher struct {
+	PortRange
+}
+
+func (p portRangeMatcher) Match(m *Metadata) bool {
+	return p.Contains(m.DstPort)
+}
+
+type finalMatcher struct{}
+
+func (finalMatcher) Match(*Metadata) bool {
+	return true
+}
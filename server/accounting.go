@@ -0,0 +1,208 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TrafficRecord 是一个客户端身份在一天内的流量统计
+type TrafficRecord struct {
+	Date     string `json:"date"`     // 日期，例如 2024-05-01，按本地时区划分
+	Identity string `json:"identity"` // 客户端身份：用户名，未配置用户时为来源 IP
+	Up       int64  `json:"up"`       // 上行（本地端到目标）字节数
+	Down     int64  `json:"down"`     // 下行（目标到本地端）字节数
+	Conns    int64  `json:"conns"`    // 成功建立的连接数
+}
+
+type trafficKey struct {
+	date, identity string
+}
+
+// Accounting 按天统计每个客户端身份的流量与连接数。统计在内存中累计，调用 Flush 时以 JSON Lines
+// 格式追加到文件，每行是一段时间内的增量，同一天同一身份可能有多行，查询时合并
+type Accounting struct {
+	path string
+
+	mu      sync.Mutex
+	pending map[trafficKey]*TrafficRecord
+	now     func() time.Time
+}
+
+// NewAccounting 创建流量统计，path 是持久化文件。打开时将文件中的增量合并为每天每个身份一行，
+// 并去掉写入中途退出留下的不完整行，避免之后追加的记录接在其后无法解析
+func NewAccounting(path string) (*Accounting, error) {
+	a := &Accounting{path: path, pending: make(map[trafficKey]*TrafficRecord), now: time.Now}
+	records, partial, err := readTraffic(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) > 0 || partial {
+		if err := writeFileAtomic(path, encodeTraffic(records)); err != nil {
+			return nil, fmt.Errorf("整理流量统计失败: %w", err)
+		}
+	}
+	return a, nil
+}
+
+// AddConn 为 identity 计入一次连接
+func (a *Accounting) AddConn(identity string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.record(identity).Conns++
+}
+
+// add 为 identity 累计上行或下行字节数
+func (a *Accounting) add(identity string, up bool, n int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	record := a.record(identity)
+	if up {
+		record.Up += n
+	} else {
+		record.Down += n
+	}
+}
+
+// record 返回 identity 当天尚未写入文件的统计，调用时需持有 mu
+func (a *Accounting) record(identity string) *TrafficRecord {
+	key := trafficKey{date: a.now().Format(time.DateOnly), identity: identity}
+	record, ok := a.pending[key]
+	if !ok {
+		record = &TrafficRecord{Date: key.date, Identity: identity}
+		a.pending[key] = record
+	}
+	return record
+}
+
+// Flush 将尚未写入的统计追加到文件
+func (a *Accounting) Flush() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.pending) == 0 {
+		return nil
+	}
+	records := make([]TrafficRecord, 0, len(a.pending))
+	for _, record := range a.pending {
+		records = append(records, *record)
+	}
+	sortTraffic(records)
+
+	file, err := os.OpenFile(a.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("打开流量统计文件失败: %w", err)
+	}
+	if _, err := file.Write(encodeTraffic(records)); err != nil {
+		file.Close()
+		return fmt.Errorf("写入流量统计失败: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("写入流量统计失败: %w", err)
+	}
+	a.pending = make(map[trafficKey]*TrafficRecord)
+	return nil
+}
+
+// counter 返回为 identity 累计上行或下行字节数的 Throttle
+func (a *Accounting) counter(identity string, up bool) trafficCounter {
+	return trafficCounter{accounting: a, identity: identity, up: up}
+}
+
+type trafficCounter struct {
+	accounting *Accounting
+	identity   string
+	up         bool
+}
+
+func (c trafficCounter) Wait(n int) error {
+	c.accounting.add(c.identity, c.up, int64(n))
+	return nil
+}
+
+// TrafficFilter 定义了流量统计的查询条件，字段为空表示不限制
+type TrafficFilter struct {
+	From     string // 起始日期（含），例如 2024-05-01
+	To       string // 结束日期（含）
+	Identity string // 客户端身份
+}
+
+// QueryTraffic 从流量统计文件中查询符合条件的记录，每天每个身份一条，按日期与身份排序
+func QueryTraffic(path string, filter TrafficFilter) ([]TrafficRecord, error) {
+	records, _, err := readTraffic(path)
+	if err != nil {
+		return nil, err
+	}
+	matched := records[:0]
+	for _, record := range records {
+		if (filter.From != "" && record.Date < filter.From) ||
+			(filter.To != "" && record.Date > filter.To) ||
+			(filter.Identity != "" && record.Identity != filter.Identity) {
+			continue
+		}
+		matched = append(matched, record)
+	}
+	return matched, nil
+}
+
+// readTraffic 读取并合并文件中的记录。最后一行没有换行符时说明写入中途退出，忽略该行并返回 partial 为 true
+func readTraffic(path string) (records []TrafficRecord, partial bool, err error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("读取流量统计失败: %w", err)
+	}
+
+	lines := bytes.Split(data, []byte("\n"))
+	partial = len(lines[len(lines)-1]) > 0
+	lines = lines[:len(lines)-1]
+	merged := make(map[trafficKey]*TrafficRecord)
+	for i, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var record TrafficRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return nil, false, fmt.Errorf("解析流量统计 %s 第 %d 行失败: %w", path, i+1, err)
+		}
+		key := trafficKey{date: record.Date, identity: record.Identity}
+		if existing, ok := merged[key]; ok {
+			existing.Up += record.Up
+			existing.Down += record.Down
+			existing.Conns += record.Conns
+		} else {
+			merged[key] = &record
+		}
+	}
+
+	records = make([]TrafficRecord, 0, len(merged))
+	for _, record := range merged {
+		records = append(records, *record)
+	}
+	sortTraffic(records)
+	return records, partial, nil
+}
+
+func encodeTraffic(records []TrafficRecord) []byte {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, record := range records {
+		encoder.Encode(record)
+	}
+	return buf.Bytes()
+}
+
+func sortTraffic(records []TrafficRecord) {
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Date != records[j].Date {
+			return records[i].Date < records[j].Date
+		}
+		return records[i].Identity < records[j].Identity
+	})
+}
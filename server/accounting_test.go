@@ -0,0 +1,79 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccounting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traffic.jsonl")
+	a, err := NewAccounting(path)
+	require.NoError(t, err)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local)
+	a.now = func() time.Time { return now }
+
+	a.AddConn("alice")
+	a.counter("alice", true).Wait(100)
+	a.counter("alice", false).Wait(1000)
+	a.AddConn("bob")
+	require.NoError(t, a.Flush())
+
+	a.AddConn("alice")
+	a.counter("alice", true).Wait(50)
+	now = now.Add(24 * time.Hour)
+	a.counter("alice", false).Wait(7)
+	require.NoError(t, a.Flush())
+	require.NoError(t, a.Flush(), "没有新的统计时不写入")
+
+	records, err := QueryTraffic(path, TrafficFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, []TrafficRecord{
+		{Date: "2024-05-01", Identity: "alice", Up: 150, Down: 1000, Conns: 2},
+		{Date: "2024-05-01", Identity: "bob", Conns: 1},
+		{Date: "2024-05-02", Identity: "alice", Down: 7},
+	}, records)
+
+	records, err = QueryTraffic(path, TrafficFilter{From: "2024-05-02"})
+	require.NoError(t, err)
+	assert.Len(t, records, 1)
+	records, err = QueryTraffic(path, TrafficFilter{To: "2024-05-01", Identity: "bob"})
+	require.NoError(t, err)
+	assert.Equal(t, []TrafficRecord{{Date: "2024-05-01", Identity: "bob", Conns: 1}}, records)
+
+	// 重新打开时合并增量，忽略写入中途退出留下的不完整行
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	file.WriteString(`{"date":"2024-05-02","identity":"alice","up":`)
+	file.Close()
+	_, err = NewAccounting(path)
+	require.NoError(t, err)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 3, strings.Count(string(data), "\n"))
+	reopened, err := QueryTraffic(path, TrafficFilter{})
+	require.NoError(t, err)
+	assert.Len(t, reopened, 3)
+}
+
+func TestAccounting_PartialOnly(t *testing.T) {
+	// 文件中只有一条不完整的记录时也要去掉，否则之后追加的记录会接在其后
+	path := filepath.Join(t.TempDir(), "traffic.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte(`{"date":"2024-05-01","identity":"alice","up":`), 0644))
+	a, err := NewAccounting(path)
+	require.NoError(t, err)
+	a.now = func() time.Time { return time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local) }
+	a.AddConn("alice")
+	require.NoError(t, a.Flush())
+
+	_, err = NewAccounting(path)
+	require.NoError(t, err)
+	records, err := QueryTraffic(path, TrafficFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, []TrafficRecord{{Date: "2024-05-01", Identity: "alice", Conns: 1}}, records)
+}
@@ -0,0 +1,296 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/beijian128/minisocks/core"
+	"github.com/sirupsen/logrus"
+)
+
+// AdminOptions 定义了服务端的管理接口
+type AdminOptions struct {
+	// Token 不为空时请求需要携带 Authorization: Bearer <Token>，为空时只能监听本机回环地址
+	Token string
+	// OnUsersChange 在通过管理接口增删、停用或启用用户后调用，用于持久化用户列表
+	OnUsersChange func(users []*User) error
+}
+
+// Admin 是服务端的 HTTP 管理接口，请求与响应均为 JSON
+//
+//	GET    /api/stats                     汇总统计
+//	GET    /api/conns                     正在转发的连接
+//	DELETE /api/conns/{id}                中断连接
+//	GET    /api/users                     用户列表
+//	POST   /api/users                     添加用户，请求体 {"name": "...", "password": "..."}，密码为空时自动生成
+//	DELETE /api/users/{name}              删除用户并中断其连接
+//	POST   /api/users/{name}/disable      停用用户并中断其连接
+//	POST   /api/users/{name}/enable       启用用户
+//	GET    /api/quotas                    流量配额用量
+//	POST   /api/quotas/{identity}/reset   清零配额用量
+type Admin struct {
+	server *LsServer
+	opts   AdminOptions
+	mux    *http.ServeMux
+	mu     sync.Mutex // 串行化用户变更与 OnUsersChange
+	logger *logrus.Entry
+}
+
+// NewAdmin 创建 s 的管理接口
+func NewAdmin(s *LsServer, opts AdminOptions) *Admin {
+	a := &Admin{
+		server: s,
+		opts:   opts,
+		mux:    http.NewServeMux(),
+		logger: logrus.WithField("component", "Admin"),
+	}
+	a.mux.HandleFunc("GET /api/stats", a.stats)
+	a.mux.HandleFunc("GET /api/conns", a.listConns)
+	a.mux.HandleFunc("DELETE /api/conns/{id}", a.closeConn)
+	a.mux.HandleFunc("GET /api/users", a.listUsers)
+	a.mux.HandleFunc("POST /api/users", a.addUser)
+	a.mux.HandleFunc("DELETE /api/users/{name}", a.removeUser)
+	a.mux.HandleFunc("POST /api/users/{name}/disable", a.disableUser)
+	a.mux.HandleFunc("POST /api/users/{name}/enable", a.enableUser)
+	a.mux.HandleFunc("GET /api/quotas", a.listQuotas)
+	a.mux.HandleFunc("POST /api/quotas/{identity}/reset", a.resetQuota)
+	return a
+}
+
+// ListenAndServe 在 addr 上提供管理接口。没有配置 Token 时 addr 必须是本机回环地址
+func (a *Admin) ListenAndServe(addr string) error {
+	if a.opts.Token == "" && !core.IsLoopbackAddr(addr) {
+		return fmt.Errorf("管理接口监听 %s 时必须配置 token", addr)
+	}
+	a.logger.WithField("address", addr).Info("管理接口启动")
+	srv := &http.Server{Addr: addr, Handler: a, ReadHeaderTimeout: core.TIMEOUT}
+	return srv.ListenAndServe()
+}
+
+// ServeHTTP 校验 token 后分发请求
+func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if a.opts.Token != "" {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.opts.Token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "缺少或错误的 token")
+			return
+		}
+	}
+	a.logger.WithFields(logrus.Fields{
+		"remoteAddr": r.RemoteAddr,
+		"method":     r.Method,
+		"path":       r.URL.Path,
+	}).Debug("处理管理请求")
+	a.mux.ServeHTTP(w, r)
+}
+
+// UserStats 是一个用户正在转发的连接的汇总
+type UserStats struct {
+	Name        string `json:"name"`
+	Disabled    bool   `json:"disabled"`
+	ActiveConns int    `json:"activeConns"`
+	Up          int64  `json:"up"`   // 正在转发的连接的上行字节数
+	Down        int64  `json:"down"` // 正在转发的连接的下行字节数
+}
+
+// AdminStats 是管理接口返回的汇总统计
+type AdminStats struct {
+	core.ConnStats
+	HandshakeFailures int64       `json:"handshakeFailures"`
+	Users             []UserStats `json:"users,omitempty"`
+}
+
+func (a *Admin) stats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, AdminStats{
+		ConnStats:         a.server.conns.Stats(),
+		HandshakeFailures: a.server.failures.Load(),
+		Users:             a.userStats(),
+	})
+}
+
+// userStats 按用户汇总正在转发的连接，未配置用户时返回 nil
+func (a *Admin) userStats() []UserStats {
+	if a.server.Users == nil {
+		return nil
+	}
+	users := a.server.Users.List()
+	stats := make([]UserStats, len(users))
+	index := make(map[string]int, len(users))
+	for i, user := range users {
+		stats[i] = UserStats{Name: user.Name, Disabled: user.Disabled()}
+		index[user.Name] = i
+	}
+	for _, conn := range a.server.conns.List() {
+		if i, ok := index[conn.User]; ok {
+			stats[i].ActiveConns++
+			stats[i].Up += conn.Up
+			stats[i].Down += conn.Down
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+func (a *Admin) listConns(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.server.conns.List())
+}
+
+func (a *Admin) closeConn(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !a.server.conns.Close(id) {
+		writeError(w, http.StatusNotFound, "连接不存在")
+		return
+	}
+	a.logger.WithField("connID", id).Info("通过管理接口中断连接")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *Admin) listUsers(w http.ResponseWriter, r *http.Request) {
+	if a.server.Users == nil {
+		writeError(w, http.StatusConflict, "服务端没有启用多用户")
+		return
+	}
+	writeJSON(w, http.StatusOK, a.userStats())
+}
+
+// userRequest 是添加用户的请求体，也是响应体
+type userRequest struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+func (a *Admin) addUser(w http.ResponseWriter, r *http.Request) {
+	if a.server.Users == nil {
+		writeError(w, http.StatusConflict, "服务端没有启用多用户")
+		return
+	}
+	var req userRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "无效的请求体: "+err.Error())
+		return
+	}
+	if req.Password == "" {
+		req.Password = core.GenerateCipherTable()
+	}
+	user, err := NewUser(req.Name, req.Password)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.server.Users.Add(user); err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	a.logger.WithField("user", user.Name).Info("通过管理接口添加用户")
+	if !a.usersChanged(w) {
+		return
+	}
+	writeJSON(w, http.StatusCreated, req)
+}
+
+func (a *Admin) removeUser(w http.ResponseWriter, r *http.Request) {
+	a.changeUser(w, r.PathValue("name"), "删除", func(users *Users, user *User) {
+		users.Remove(user.Name)
+	})
+}
+
+func (a *Admin) disableUser(w http.ResponseWriter, r *http.Request) {
+	a.changeUser(w, r.PathValue("name"), "停用", func(_ *Users, user *User) {
+		user.SetDisabled(true)
+	})
+}
+
+func (a *Admin) enableUser(w http.ResponseWriter, r *http.Request) {
+	a.changeUser(w, r.PathValue("name"), "启用", func(_ *Users, user *User) {
+		user.SetDisabled(false)
+	})
+}
+
+// changeUser 对用户 name 执行 change，删除或停用后用户不能再建立连接，同时中断其正在转发的连接
+func (a *Admin) changeUser(w http.ResponseWriter, name, action string, change func(*Users, *User)) {
+	users := a.server.Users
+	if users == nil {
+		writeError(w, http.StatusConflict, "服务端没有启用多用户")
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	user := users.Get(name)
+	if user == nil {
+		writeError(w, http.StatusNotFound, "用户不存在")
+		return
+	}
+	change(users, user)
+	closed := 0
+	if users.Get(name) == nil || user.Disabled() {
+		closed = a.server.conns.CloseWhere(func(conn core.ConnInfo) bool { return conn.User == name })
+	}
+	a.logger.WithFields(logrus.Fields{
+		"user":        name,
+		"closedConns": closed,
+	}).Info("通过管理接口" + action + "用户")
+	if !a.usersChanged(w) {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"closedConns": closed})
+}
+
+// usersChanged 调用 OnUsersChange，失败时写入错误响应并返回 false。调用时需持有 mu
+func (a *Admin) usersChanged(w http.ResponseWriter) bool {
+	if a.opts.OnUsersChange == nil {
+		return true
+	}
+	if err := a.opts.OnUsersChange(a.server.Users.List()); err != nil {
+		a.logger.WithError(err).Error("保存用户列表失败")
+		writeError(w, http.StatusInternalServerError, "用户已在运行中生效，但保存失败: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func (a *Admin) listQuotas(w http.ResponseWriter, r *http.Request) {
+	if a.server.Quotas == nil {
+		writeError(w, http.StatusConflict, "服务端没有启用流量配额")
+		return
+	}
+	writeJSON(w, http.StatusOK, a.server.Quotas.List())
+}
+
+func (a *Admin) resetQuota(w http.ResponseWriter, r *http.Request) {
+	quotas := a.server.Quotas
+	if quotas == nil {
+		writeError(w, http.StatusConflict, "服务端没有启用流量配额")
+		return
+	}
+	identity := r.PathValue("identity")
+	if !quotas.Reset(identity) {
+		writeError(w, http.StatusNotFound, "该客户端没有用量记录")
+		return
+	}
+	a.logger.WithField("identity", identity).Info("通过管理接口重置流量配额")
+	if err := quotas.Save(); err != nil {
+		a.logger.WithError(err).Error("保存流量用量失败")
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/beijian128/minisocks/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// adminRequest 向管理接口发送请求，返回状态码并将响应体解析到 v
+func adminRequest(t *testing.T, handler http.Handler, method, path, body string, v any) int {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if v != nil {
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), v), rec.Body.String())
+	}
+	return rec.Code
+}
+
+func TestAdmin(t *testing.T) {
+	alice, err := NewUser("alice", core.GenerateCipherTable())
+	require.NoError(t, err)
+	users, err := NewUsers(alice)
+	require.NoError(t, err)
+	s := New(core.GenerateCipherTable(), &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	s.Users = users
+	var saved []*User
+	admin := NewAdmin(s, AdminOptions{
+		Token:         "secret",
+		OnUsersChange: func(users []*User) error { saved = users; return nil },
+	})
+
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/stats", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	closed := false
+	conn := s.conns.Add(core.ConnInfo{ID: "c1", User: "alice", Source: "127.0.0.1:5000", Target: "example.com:80"}, func() { closed = true })
+	require.NoError(t, conn.Up().Wait(42))
+
+	var conns []core.ConnInfo
+	assert.Equal(t, http.StatusOK, adminRequest(t, admin, http.MethodGet, "/api/conns", "", &conns))
+	require.Len(t, conns, 1)
+	assert.Equal(t, "alice", conns[0].User)
+	assert.Equal(t, int64(42), conns[0].Up)
+
+	var stats AdminStats
+	assert.Equal(t, http.StatusOK, adminRequest(t, admin, http.MethodGet, "/api/stats", "", &stats))
+	assert.Equal(t, 1, stats.ActiveConns)
+	require.Len(t, stats.Users, 1)
+	assert.Equal(t, UserStats{Name: "alice", ActiveConns: 1, Up: 42}, stats.Users[0])
+
+	assert.Equal(t, http.StatusNotFound, adminRequest(t, admin, http.MethodDelete, "/api/conns/missing", "", nil))
+	assert.Equal(t, http.StatusNoContent, adminRequest(t, admin, http.MethodDelete, "/api/conns/c1", "", nil))
+	assert.True(t, closed)
+
+	// 添加用户，未指定密码时自动生成
+	var added userRequest
+	assert.Equal(t, http.StatusCreated, adminRequest(t, admin, http.MethodPost, "/api/users", `{"name": "bob"}`, &added))
+	assert.Equal(t, "bob", added.Name)
+	assert.NotEmpty(t, added.Password)
+	assert.Len(t, saved, 2)
+	assert.Equal(t, http.StatusConflict, adminRequest(t, admin, http.MethodPost, "/api/users", `{"name": "bob"}`, nil))
+	assert.Equal(t, http.StatusBadRequest, adminRequest(t, admin, http.MethodPost, "/api/users", `{"name": ""}`, nil))
+
+	// 停用用户时中断其连接
+	closed = false
+	var result map[string]int
+	assert.Equal(t, http.StatusOK, adminRequest(t, admin, http.MethodPost, "/api/users/alice/disable", "", &result))
+	assert.Equal(t, 1, result["closedConns"])
+	assert.True(t, closed)
+	assert.True(t, alice.Disabled())
+	assert.Equal(t, http.StatusOK, adminRequest(t, admin, http.MethodPost, "/api/users/alice/enable", "", &result))
+	assert.Equal(t, 0, result["closedConns"])
+	assert.False(t, alice.Disabled())
+
+	assert.Equal(t, http.StatusOK, adminRequest(t, admin, http.MethodDelete, "/api/users/bob", "", nil))
+	assert.Nil(t, users.Get("bob"))
+	assert.Len(t, saved, 1)
+	assert.Equal(t, http.StatusNotFound, adminRequest(t, admin, http.MethodDelete, "/api/users/bob", "", nil))
+
+	// 没有启用流量配额
+	assert.Equal(t, http.StatusConflict, adminRequest(t, admin, http.MethodGet, "/api/quotas", "", nil))
+}
+
+func TestAdmin_Quotas(t *testing.T) {
+	s := New(core.GenerateCipherTable(), &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	s.Quotas, _ = NewQuotas(QuotaOptions{Daily: 100})
+	require.ErrorIs(t, s.Quotas.Add("1.2.3.4", 100), ErrQuotaExceeded)
+	admin := NewAdmin(s, AdminOptions{Token: "secret"})
+
+	// 没有启用多用户
+	assert.Equal(t, http.StatusConflict, adminRequest(t, admin, http.MethodGet, "/api/users", "", nil))
+
+	var usage []QuotaUsage
+	assert.Equal(t, http.StatusOK, adminRequest(t, admin, http.MethodGet, "/api/quotas", "", &usage))
+	require.Len(t, usage, 1)
+	assert.Equal(t, int64(100), usage[0].DayBytes)
+
+	assert.Equal(t, http.StatusNotFound, adminRequest(t, admin, http.MethodPost, "/api/quotas/5.6.7.8/reset", "", nil))
+	assert.Equal(t, http.StatusNoContent, adminRequest(t, admin, http.MethodPost, "/api/quotas/1.2.3.4/reset", "", nil))
+	assert.False(t, s.Quotas.Exceeded("1.2.3.4"))
+}
+
+func TestAdmin_ListenAndServe(t *testing.T) {
+	s := New(core.GenerateCipherTable(), &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.Error(t, NewAdmin(s, AdminOptions{}).ListenAndServe(":0"), "监听所有地址时必须配置 token")
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// ErrConnLimit 表示并发连接数达到上限
+var ErrConnLimit = errors.New("并发连接数达到上限")
+
+// ConnLimitOptions 定义了并发连接数上限，为 0 表示不限制
+type ConnLimitOptions struct {
+	Global  int // 服务端同时处理的连接数
+	PerUser int // 每个用户同时建立的连接数，只在配置了用户时生效
+	PerIP   int // 每个来源 IP 同时建立的连接数
+}
+
+// ConnLimits 统计正在处理的连接数，超出上限的连接被拒绝
+type ConnLimits struct {
+	opts ConnLimitOptions
+
+	mu     sync.Mutex
+	global int
+	users  map[string]int
+	ips    map[string]int
+}
+
+// NewConnLimits 创建并发连接数限制
+func NewConnLimits(opts ConnLimitOptions) *ConnLimits {
+	return &ConnLimits{opts: opts, users: make(map[string]int), ips: make(map[string]int)}
+}
+
+// Acquire 为来自 ip 的新连接占用全局与来源 IP 的名额，ip 为 nil 时不检查来源 IP。
+// 成功时返回连接结束时需要调用的 release
+func (c *ConnLimits) Acquire(ip net.IP) (release func(), err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.opts.Global > 0 && c.global >= c.opts.Global {
+		return nil, fmt.Errorf("%w: 全局 %d", ErrConnLimit, c.opts.Global)
+	}
+	key := ""
+	if ip != nil {
+		key = ip.String()
+		if c.opts.PerIP > 0 && c.ips[key] >= c.opts.PerIP {
+			return nil, fmt.Errorf("%w: 来源 IP %s %d", ErrConnLimit, key, c.opts.PerIP)
+		}
+		c.ips[key]++
+	}
+	c.global++
+
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.global--
+		if key != "" {
+			decrement(c.ips, key)
+		}
+	}, nil
+}
+
+// AcquireUser 为用户 name 的新连接占用名额，成功时返回连接结束时需要调用的 release
+func (c *ConnLimits) AcquireUser(name string) (release func(), err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.opts.PerUser > 0 && c.users[name] >= c.opts.PerUser {
+		return nil, fmt.Errorf("%w: 用户 %s %d", ErrConnLimit, name, c.opts.PerUser)
+	}
+	c.users[name]++
+
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		decrement(c.users, name)
+	}, nil
+}
+
+// decrement 减少 key 的计数，归零时删除，避免内存随来源数量增长
+func decrement(counts map[string]int, key string) {
+	if counts[key]--; counts[key] <= 0 {
+		delete(counts, key)
+	}
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnLimits(t *testing.T) {
+	c := NewConnLimits(ConnLimitOptions{Global: 3, PerIP: 2, PerUser: 1})
+	a, b := net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2")
+
+	releaseA1, err := c.Acquire(a)
+	require.NoError(t, err)
+	_, err = c.Acquire(a)
+	require.NoError(t, err)
+	_, err = c.Acquire(a)
+	assert.ErrorIs(t, err, ErrConnLimit, "来源 IP 达到上限")
+
+	_, err = c.Acquire(nil)
+	require.NoError(t, err, "非 IP 来源只计入全局")
+	_, err = c.Acquire(b)
+	assert.ErrorIs(t, err, ErrConnLimit, "全局达到上限")
+
+	releaseA1()
+	_, err = c.Acquire(b)
+	assert.NoError(t, err)
+
+	releaseUser, err := c.AcquireUser("alice")
+	require.NoError(t, err)
+	_, err = c.AcquireUser("alice")
+	assert.ErrorIs(t, err, ErrConnLimit, "用户达到上限")
+	_, err = c.AcquireUser("bob")
+	assert.NoError(t, err)
+	releaseUser()
+	assert.NotContains(t, c.users, "alice", "计数归零时删除")
+}
+
+func TestAcceptBackoff(t *testing.T) {
+	delay := acceptBackoff(0)
+	assert.Equal(t, 5*time.Millisecond, delay)
+	for range 20 {
+		delay = acceptBackoff(delay)
+	}
+	assert.Equal(t, time.Second, delay)
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/beijian128/minisocks/dns"
+)
+
+// IPStrategy 决定连接目标时使用的地址族
+type IPStrategy string
+
+const (
+	IPv4Only   IPStrategy = "ipv4-only" // 只连接 IPv4 地址
+	IPv6Only   IPStrategy = "ipv6-only" // 只连接 IPv6 地址
+	PreferIPv4 IPStrategy = "prefer-v4" // 两种地址交替尝试，IPv4 优先
+	PreferIPv6 IPStrategy = "prefer-v6" // 两种地址交替尝试，IPv6 优先，RFC 8305 的默认行为
+)
+
+// DefaultAttemptDelay 是 RFC 8305 推荐的相邻两次连接尝试之间的间隔
+const DefaultAttemptDelay = 250 * time.Millisecond
+
+// ParseIPStrategy 解析配置中的地址族策略，为空时使用 PreferIPv6
+func ParseIPStrategy(s string) (IPStrategy, error) {
+	switch strategy := IPStrategy(s); strategy {
+	case "":
+		return PreferIPv6, nil
+	case IPv4Only, IPv6Only, PreferIPv4, PreferIPv6:
+		return strategy, nil
+	default:
+		return "", fmt.Errorf("无效的地址族策略 %q，可选值为 ipv4-only、ipv6-only、prefer-v4、prefer-v6", s)
+	}
+}
+
+// Dialer 解析目标域名的全部地址，并按 RFC 8305（Happy Eyeballs v2）交替尝试 IPv6 与 IPv4，
+// 采用最先建立成功的连接
+type Dialer struct {
+	Resolver     dns.Resolver
+	Strategy     IPStrategy
+	AttemptDelay time.Duration // 为 0 时使用 DefaultAttemptDelay
+	Policy       *EgressPolicy // 出站策略，为 nil 时不做限制
+
+	// dial 建立单个连接，为 nil 时使用 net.Dialer，测试时可以替换
+	dial func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// DialContext 连接 host:port，host 可以是域名或 IP
+func (d *Dialer) DialContext(ctx context.Context, host string, port int) (net.Conn, error) {
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		if d.Policy != nil {
+			if err := d.Policy.CheckDomain(host); err != nil {
+				return nil, err
+			}
+		}
+		var err error
+		if ips, err = d.Resolver.LookupIP(ctx, host); err != nil {
+			return nil, fmt.Errorf("解析域名 %s 失败: %w", host, err)
+		}
+	}
+
+	ips = sortAddrs(ips, d.Strategy)
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("%s 没有符合地址族策略 %s 的地址", host, d.Strategy)
+	}
+	if d.Policy != nil {
+		var err error
+		if ips, err = d.filter(ips, port); err != nil {
+			return nil, err
+		}
+	}
+	return d.race(ctx, ips, port)
+}
+
+// filter 去掉被出站策略禁止的地址，全部被禁止时返回第一个地址的拒绝原因
+func (d *Dialer) filter(ips []net.IP, port int) ([]net.IP, error) {
+	allowed := ips[:0:0]
+	var denied error
+	for _, ip := range ips {
+		if err := d.Policy.CheckAddr(ip, port); err != nil {
+			if denied == nil {
+				denied = err
+			}
+			continue
+		}
+		allowed = append(allowed, ip)
+	}
+	if len(allowed) == 0 {
+		return nil, denied
+	}
+	return allowed, nil
+}
+
+// race 依次发起连接，每次间隔 AttemptDelay，上一次尝试失败时立即开始下一次，返回最先成功的连接
+func (d *Dialer) race(ctx context.Context, ips []net.IP, port int) (net.Conn, error) {
+	dial := d.dial
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	delay := d.AttemptDelay
+	if delay <= 0 {
+		delay = DefaultAttemptDelay
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, len(ips))
+	next, pending := 0, 0
+	start := func() {
+		addr := net.JoinHostPort(ips[next].String(), strconv.Itoa(port))
+		next++
+		pending++
+		go func() {
+			conn, err := dial(ctx, "tcp", addr)
+			results <- result{conn, err}
+		}()
+	}
+
+	start()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var errs []error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if next < len(ips) {
+				start()
+				timer.Reset(delay)
+			}
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				// 关闭其余仍在进行中、随后才成功的连接
+				go func(n int) {
+					for i := 0; i < n; i++ {
+						if r := <-results; r.conn != nil {
+							r.conn.Close()
+						}
+					}
+				}(pending)
+				return res.conn, nil
+			}
+			errs = append(errs, res.err)
+			if next < len(ips) {
+				start()
+				timer.Reset(delay)
+			}
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// sortAddrs 按策略过滤地址，并把两种地址族交替排列，首选的地址族排在最前
+func sortAddrs(ips []net.IP, strategy IPStrategy) []net.IP {
+	var v4, v6 []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+
+	switch strategy {
+	case IPv4Only:
+		return v4
+	case IPv6Only:
+		return v6
+	case PreferIPv4:
+		return interleave(v4, v6)
+	default:
+		return interleave(v6, v4)
+	}
+}
+
+func interleave(first, second []net.IP) []net.IP {
+	out := make([]net.IP, 0, len(first)+len(second))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			out = append(out, first[i])
+		}
+		if i < len(second) {
+			out = append(out, second[i])
+		}
+	}
+	return out
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type staticResolver []net.IP
+
+func (r staticResolver) LookupIP(context.Context, string) ([]net.IP, error) {
+	return r, nil
+}
+
+func parseIPs(addrs ...string) []net.IP {
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = net.ParseIP(addr)
+	}
+	return ips
+}
+
+func TestSortAddrs(t *testing.T) {
+	ips := parseIPs("1.1.1.1", "2.2.2.2", "3.3.3.3", "::1", "::2")
+	tests := []struct {
+		strategy IPStrategy
+		want     []net.IP
+	}{
+		{PreferIPv6, parseIPs("::1", "1.1.1.1", "::2", "2.2.2.2", "3.3.3.3")},
+		{PreferIPv4, parseIPs("1.1.1.1", "::1", "2.2.2.2", "::2", "3.3.3.3")},
+		{IPv4Only, parseIPs("1.1.1.1", "2.2.2.2", "3.3.3.3")},
+		{IPv6Only, parseIPs("::1", "::2")},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, sortAddrs(ips, tt.strategy), tt.strategy)
+	}
+
+	_, err := ParseIPStrategy("ipv5-only")
+	assert.Error(t, err)
+	strategy, err := ParseIPStrategy("")
+	require.NoError(t, err)
+	assert.Equal(t, PreferIPv6, strategy)
+}
+
+// fakeDial 根据地址模拟连接结果：hang 中的地址一直阻塞到取消，fail 中的地址立即失败，其余地址立即成功
+type fakeDial struct {
+	mu       sync.Mutex
+	hang     map[string]bool
+	fail     map[string]bool
+	attempts []string
+}
+
+func (f *fakeDial) dial(ctx context.Context, _, addr string) (net.Conn, error) {
+	f.mu.Lock()
+	f.attempts = append(f.attempts, addr)
+	f.mu.Unlock()
+
+	switch {
+	case f.hang[addr]:
+		<-ctx.Done()
+		return nil, ctx.Err()
+	case f.fail[addr]:
+		return nil, errors.New("connection refused")
+	}
+	conn, peer := net.Pipe()
+	peer.Close()
+	return conn, nil
+}
+
+func TestDialer_HappyEyeballs(t *testing.T) {
+	resolver := staticResolver(parseIPs("1.2.3.4", "2001:db8::1"))
+
+	// IPv6 没有响应时，经过 AttemptDelay 后改用 IPv4
+	f := &fakeDial{hang: map[string]bool{"[2001:db8::1]:80": true}}
+	d := &Dialer{Resolver: resolver, Strategy: PreferIPv6, AttemptDelay: 50 * time.Millisecond, dial: f.dial}
+	start := time.Now()
+	conn, err := d.DialContext(context.Background(), "example.com", 80)
+	require.NoError(t, err)
+	conn.Close()
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	assert.Equal(t, []string{"[2001:db8::1]:80", "1.2.3.4:80"}, f.attempts)
+
+	// 首选地址立即失败时不等待 AttemptDelay
+	f = &fakeDial{fail: map[string]bool{"1.2.3.4:80": true}}
+	d = &Dialer{Resolver: resolver, Strategy: PreferIPv4, AttemptDelay: time.Hour, dial: f.dial}
+	conn, err = d.DialContext(context.Background(), "example.com", 80)
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, []string{"1.2.3.4:80", "[2001:db8::1]:80"}, f.attempts)
+
+	// 全部失败时返回所有错误
+	f = &fakeDial{fail: map[string]bool{"1.2.3.4:80": true, "[2001:db8::1]:80": true}}
+	d.dial = f.dial
+	_, err = d.DialContext(context.Background(), "example.com", 80)
+	assert.ErrorContains(t, err, "connection refused")
+	assert.Len(t, f.attempts, 2)
+
+	// 地址族策略过滤掉全部地址
+	d = &Dialer{Resolver: resolver, Strategy: IPv6Only, dial: f.dial}
+	_, err = d.DialContext(context.Background(), "10.0.0.1", 80)
+	assert.Error(t, err)
+}
+
+func TestDialer_Real(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	d := &Dialer{Resolver: staticResolver(parseIPs("::1", "127.0.0.1")), Strategy: PreferIPv6}
+	conn, err := d.DialContext(context.Background(), "localhost", port)
+	require.NoError(t, err, "::1 上没有监听时应回落到 127.0.0.1")
+	defer conn.Close()
+	assert.Equal(t, ln.Addr().String(), conn.RemoteAddr().String())
+	assert.IsType(t, &net.TCPConn{}, conn)
+}
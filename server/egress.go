@@ -0,0 +1,130 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/beijian128/minisocks/route"
+)
+
+// ErrEgressDenied 表示目标被出站策略禁止，服务端会向本地端回复 SOCKS5 0x02
+var ErrEgressDenied = errors.New("目标被出站策略禁止")
+
+// EgressOptions 定义了出站策略
+type EgressOptions struct {
+	Allow        []string // 放行的网段或 IP，可以覆盖默认禁止的地址段
+	Deny         []string // 禁止的网段或 IP，优先于 Allow
+	AllowPorts   []string // 允许的目标端口或端口范围，为空表示不限制
+	DenyPorts    []string // 禁止的目标端口或端口范围，优先于 AllowPorts
+	BlockDomains []string // 禁止的域名，格式同域名列表文件，无前缀时按后缀匹配
+}
+
+// EgressPolicy 限制服务端可以连接的目标，防止服务端被用来访问自身或所在的内网
+type EgressPolicy struct {
+	allow        []*net.IPNet
+	deny         []*net.IPNet
+	defaults     []*net.IPNet
+	allowPorts   []route.PortRange
+	denyPorts    []route.PortRange
+	blockDomains *route.DomainSet
+}
+
+// NewEgressPolicy 根据配置创建出站策略，零值配置只禁止 route.PrivateNets 中的地址段
+func NewEgressPolicy(opts EgressOptions) (*EgressPolicy, error) {
+	p := &EgressPolicy{defaults: route.PrivateNets}
+	var err error
+	if p.allow, err = parseCIDRs(opts.Allow); err != nil {
+		return nil, err
+	}
+	if p.deny, err = parseCIDRs(opts.Deny); err != nil {
+		return nil, err
+	}
+	if p.allowPorts, err = parsePortRanges(opts.AllowPorts); err != nil {
+		return nil, err
+	}
+	if p.denyPorts, err = parsePortRanges(opts.DenyPorts); err != nil {
+		return nil, err
+	}
+	if p.blockDomains, err = route.NewDomainSet(opts.BlockDomains); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// CheckDomain 检查域名是否在禁止列表中，在解析域名之前调用
+func (p *EgressPolicy) CheckDomain(domain string) error {
+	if p.blockDomains.Match(domain) {
+		return fmt.Errorf("%w: 域名 %s 在禁止列表中", ErrEgressDenied, domain)
+	}
+	return nil
+}
+
+// CheckAddr 检查目标地址与端口。域名解析之后对实际要连接的每个 IP 调用，
+// 确保检查与连接使用同一个地址，避免 DNS 重绑定绕过策略
+func (p *EgressPolicy) CheckAddr(ip net.IP, port int) error {
+	for _, r := range p.denyPorts {
+		if r.Contains(port) {
+			return fmt.Errorf("%w: 端口 %d 被禁止", ErrEgressDenied, port)
+		}
+	}
+	if len(p.allowPorts) > 0 && !containsPort(p.allowPorts, port) {
+		return fmt.Errorf("%w: 端口 %d 不在允许列表中", ErrEgressDenied, port)
+	}
+
+	if containsIP(p.deny, ip) {
+		return fmt.Errorf("%w: 地址 %s 被禁止", ErrEgressDenied, ip)
+	}
+	if containsIP(p.allow, ip) {
+		return nil
+	}
+	if containsIP(p.defaults, ip) {
+		return fmt.Errorf("%w: 地址 %s 属于内网或保留地址段", ErrEgressDenied, ip)
+	}
+	return nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsPort(ranges []route.PortRange, port int) bool {
+	for _, r := range ranges {
+		if r.Contains(port) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCIDRs 解析网段列表，单个 IP 视为 /32 或 /128
+func parseCIDRs(values []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		ipNet, err := route.ParseCIDR(strings.TrimSpace(value))
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// parsePortRanges 解析端口列表，每项为单个端口或 "起始-结束" 范围
+func parsePortRanges(values []string) ([]route.PortRange, error) {
+	ranges := make([]route.PortRange, 0, len(values))
+	for _, value := range values {
+		r, err := route.ParsePortRange(value)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
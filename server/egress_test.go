@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEgressPolicy_Default(t *testing.T) {
+	p, err := NewEgressPolicy(EgressOptions{})
+	require.NoError(t, err)
+
+	for _, addr := range []string{"127.0.0.1", "10.1.2.3", "172.20.0.1", "192.168.1.1", "169.254.169.254", "100.100.100.200", "0.0.0.0", "::1", "fe80::1", "fd00:ec2::254", "::ffff:127.0.0.1"} {
+		assert.ErrorIs(t, p.CheckAddr(net.ParseIP(addr), 80), ErrEgressDenied, addr)
+	}
+	for _, addr := range []string{"1.1.1.1", "8.8.8.8", "2606:4700::1111"} {
+		assert.NoError(t, p.CheckAddr(net.ParseIP(addr), 443), addr)
+	}
+}
+
+func TestEgressPolicy_Options(t *testing.T) {
+	p, err := NewEgressPolicy(EgressOptions{
+		Allow:        []string{"10.1.0.0/16", "1.1.1.1"},
+		Deny:         []string{"10.1.2.0/24", "1.0.0.0/8"},
+		AllowPorts:   []string{"80", "443", "8000-9000"},
+		DenyPorts:    []string{"8080"},
+		BlockDomains: []string{"example.com", "full:ads.example.org", "keyword:tracker"},
+	})
+	require.NoError(t, err)
+
+	assert.NoError(t, p.CheckAddr(net.ParseIP("10.1.0.1"), 80), "allow 覆盖默认禁止的网段")
+	assert.ErrorIs(t, p.CheckAddr(net.ParseIP("10.1.2.1"), 80), ErrEgressDenied, "deny 优先于 allow")
+	assert.ErrorIs(t, p.CheckAddr(net.ParseIP("1.1.1.1"), 80), ErrEgressDenied, "deny 优先于 allow")
+	assert.ErrorIs(t, p.CheckAddr(net.ParseIP("10.2.0.1"), 80), ErrEgressDenied)
+	assert.NoError(t, p.CheckAddr(net.ParseIP("8.8.8.8"), 8500))
+	assert.ErrorIs(t, p.CheckAddr(net.ParseIP("8.8.8.8"), 22), ErrEgressDenied)
+	assert.ErrorIs(t, p.CheckAddr(net.ParseIP("8.8.8.8"), 8080), ErrEgressDenied)
+
+	for _, domain := range []string{"example.com", "www.example.com.", "ads.example.org", "a.tracker.net"} {
+		assert.ErrorIs(t, p.CheckDomain(domain), ErrEgressDenied, domain)
+	}
+	for _, domain := range []string{"notexample.com", "www.ads.example.org"} {
+		assert.NoError(t, p.CheckDomain(domain), domain)
+	}
+
+	_, err = NewEgressPolicy(EgressOptions{Allow: []string{"10.0.0.0/33"}})
+	assert.Error(t, err)
+	_, err = NewEgressPolicy(EgressOptions{DenyPorts: []string{"9000-80"}})
+	assert.Error(t, err)
+	_, err = NewEgressPolicy(EgressOptions{BlockDomains: []string{"regexp:("}})
+	assert.Error(t, err)
+}
+
+func TestDialer_Egress(t *testing.T) {
+	policy, err := NewEgressPolicy(EgressOptions{BlockDomains: []string{"blocked.com"}})
+	require.NoError(t, err)
+
+	// 域名解析到内网地址（DNS 重绑定）时拒绝连接
+	f := &fakeDial{}
+	d := &Dialer{Resolver: staticResolver(parseIPs("127.0.0.1", "::1")), Policy: policy, dial: f.dial}
+	_, err = d.DialContext(context.Background(), "rebind.example", 80)
+	assert.ErrorIs(t, err, ErrEgressDenied)
+	assert.Empty(t, f.attempts)
+
+	// 只连接通过检查的地址
+	d.Resolver = staticResolver(parseIPs("10.0.0.1", "1.2.3.4"))
+	conn, err := d.DialContext(context.Background(), "mixed.example", 80)
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, []string{"1.2.3.4:80"}, f.attempts)
+
+	_, err = d.DialContext(context.Background(), "www.blocked.com", 80)
+	assert.ErrorIs(t, err, ErrEgressDenied)
+	_, err = d.DialContext(context.Background(), "169.254.169.254", 80)
+	assert.ErrorIs(t, err, ErrEgressDenied)
+}
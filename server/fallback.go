@@ -0,0 +1,122 @@
+package server
+
+import (
+	"crypto/tls"
+	"io"
+	"math/rand/v2"
+	"net"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultMaxHold 是保持模式下握手失败的连接最长保留时间
+const DefaultMaxHold = 60 * time.Second
+
+// spliceLinger 是转发给诱饵服务时，一个方向结束后等待另一个方向结束的最长时间
+const spliceLinger = 30 * time.Second
+
+// Fallback 决定握手失败的连接如何处理。直接关闭连接是明显的特征，
+// 转交给诱饵服务或随机保持一段时间可以让端口在主动探测下表现得像普通服务
+type Fallback struct {
+	// Addr 是诱饵服务地址，例如本机的 nginx 127.0.0.1:80。握手阶段已读取的数据与后续数据
+	// 原样转发给它，响应原样返回给对端。为空时进入保持模式
+	Addr string
+	// MaxHold 是保持模式下保留连接的最长时间，实际时间在 (0, MaxHold] 内随机选取，
+	// 期间读取并丢弃对端发送的数据，为 0 时使用 DefaultMaxHold
+	MaxHold time.Duration
+}
+
+// Handle 接管握手失败的连接，consumed 是握手阶段已经读取的原始数据，返回时连接尚未关闭
+func (f *Fallback) Handle(logger *logrus.Entry, conn net.Conn, consumed []byte) {
+	// 恢复正常的关闭流程，避免以 RST 结束连接
+	raw := conn
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		raw = tlsConn.NetConn()
+	}
+	if tcpConn, ok := raw.(*net.TCPConn); ok {
+		tcpConn.SetLinger(-1)
+	}
+	conn.SetDeadline(time.Time{})
+
+	if f.Addr == "" {
+		f.hold(conn)
+		logger.Debug("保持模式结束")
+		return
+	}
+
+	decoy, err := net.DialTimeout("tcp", f.Addr, 5*time.Second)
+	if err != nil {
+		logger.WithError(err).Warn("连接诱饵服务失败，改为保持连接")
+		f.hold(conn)
+		return
+	}
+	defer decoy.Close()
+
+	logger.WithField("decoy", f.Addr).Debug("转交诱饵服务")
+	if _, err := decoy.Write(consumed); err != nil {
+		logger.WithError(err).Debug("向诱饵服务写入数据失败")
+		return
+	}
+	splice(conn, decoy)
+}
+
+// hold 在随机时长内读取并丢弃对端数据，对端先关闭时提前返回
+func (f *Fallback) hold(conn net.Conn) {
+	maxHold := f.MaxHold
+	if maxHold <= 0 {
+		maxHold = DefaultMaxHold
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Duration(rand.Int64N(int64(maxHold))) + time.Millisecond))
+	io.Copy(io.Discard, conn)
+}
+
+// splice 在两个连接之间双向转发数据，一个方向结束时关闭对端的写方向，
+// 另一个方向最多再等待 spliceLinger，两个方向都结束后返回
+func splice(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	pipe := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		closeWrite(dst)
+		done <- struct{}{}
+	}
+	go pipe(b, a)
+	go pipe(a, b)
+
+	<-done
+	deadline := time.Now().Add(spliceLinger)
+	a.SetReadDeadline(deadline)
+	b.SetReadDeadline(deadline)
+	<-done
+}
+
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+		return
+	}
+	conn.Close()
+}
+
+// recordConn 记录从连接读取的原始数据，直到调用 stop
+type recordConn struct {
+	net.Conn
+	data    []byte
+	stopped bool
+}
+
+func (c *recordConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if !c.stopped {
+		c.data = append(c.data, b[:n]...)
+	}
+	return n, err
+}
+
+// stop 停止记录并返回已记录的数据
+func (c *recordConn) stop() []byte {
+	c.stopped = true
+	data := c.data
+	c.data = nil
+	return data
+}
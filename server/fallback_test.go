@@ -0,0 +1,155 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/beijian128/minisocks/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startServer 在随机端口启动服务端并返回监听地址
+func startServer(t *testing.T, s *LsServer) string {
+	t.Helper()
+	addrCh := make(chan net.Addr, 1)
+	s.AfterListen = func(addr net.Addr) { addrCh <- addr }
+	go s.Listen()
+	select {
+	case addr := <-addrCh:
+		return addr.String()
+	case <-time.After(5 * time.Second):
+		t.Fatal("服务端启动超时")
+		return ""
+	}
+}
+
+func TestFallback_Decoy(t *testing.T) {
+	decoy, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer decoy.Close()
+	go http.Serve(decoy, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "nginx")
+		io.WriteString(w, "welcome "+r.URL.Path)
+	}))
+
+	s := New(core.GenerateCipherTable(), &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	s.Fallback = &Fallback{Addr: decoy.Addr().String()}
+	addr := startServer(t, s)
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET /index.html HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	require.NoError(t, err)
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "nginx", resp.Header.Get("Server"))
+	assert.Equal(t, "welcome /index.html", string(body))
+}
+
+func TestFallback_HTTPObfs(t *testing.T) {
+	decoy, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer decoy.Close()
+	go http.Serve(decoy, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "welcome "+r.Host)
+	}))
+
+	s := New(core.GenerateCipherTable(), &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	s.HTTPObfs = &core.HTTPObfsOptions{}
+	s.Fallback = &Fallback{Addr: decoy.Addr().String()}
+	addr := startServer(t, s)
+
+	// HTTP 伪装解析出的普通请求仍然原样交给诱饵服务
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	require.NoError(t, err)
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "welcome example.com", string(body))
+}
+
+func TestFallback_Hold(t *testing.T) {
+	s := New(core.GenerateCipherTable(), &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	s.Fallback = &Fallback{MaxHold: 200 * time.Millisecond}
+	addr := startServer(t, s)
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	// 单字节的探测数据不足以构成握手
+	_, err = conn.Write([]byte{0x16})
+	require.NoError(t, err)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(make([]byte, 16))
+	assert.Zero(t, n)
+	assert.ErrorIs(t, err, io.EOF, "保持结束后应正常关闭连接而不是 RST")
+}
+
+func TestFallback_ShortProbe(t *testing.T) {
+	// 回显诱饵：原样返回收到的数据
+	decoy, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer decoy.Close()
+	go func() {
+		for {
+			conn, err := decoy.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	alice, err := NewUser("alice", core.GenerateCipherTable())
+	require.NoError(t, err)
+	tests := map[string]func(s *LsServer){
+		"kex": func(s *LsServer) { s.KeyExchange = core.NewKeyExchange("secret") },
+		"users": func(s *LsServer) {
+			s.Users, err = NewUsers(alice)
+			require.NoError(t, err)
+		},
+		"kex+users": func(s *LsServer) {
+			s.KeyExchange = core.NewKeyExchange("secret")
+			s.Users, err = NewUsers(alice)
+			require.NoError(t, err)
+		},
+	}
+	for name, setup := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := New(core.GenerateCipherTable(), &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			s.Fallback = &Fallback{Addr: decoy.Addr().String()}
+			setup(s)
+			addr := startServer(t, s)
+
+			conn, err := net.Dial("tcp", addr)
+			require.NoError(t, err)
+			defer conn.Close()
+			// 不足一个握手消息的探测数据应当很快交给诱饵服务，而不是等待完整的消息直到超时
+			start := time.Now()
+			_, err = conn.Write([]byte{0x16, 0x03})
+			require.NoError(t, err)
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			reply := make([]byte, 2)
+			_, err = io.ReadFull(conn, reply)
+			require.NoError(t, err)
+			assert.Equal(t, []byte{0x16, 0x03}, reply)
+			assert.Less(t, time.Since(start), 2*time.Second)
+		})
+	}
+}
@@ -0,0 +1,280 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ClientACL 按来源 IP 过滤接入的连接
+type ClientACL struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// NewClientACL 创建来源 IP 过滤器。allow 非空时只接受其中的地址，deny 优先于 allow
+func NewClientACL(allow, deny []string) (*ClientACL, error) {
+	a := &ClientACL{}
+	var err error
+	if a.allow, err = parseCIDRs(allow); err != nil {
+		return nil, err
+	}
+	if a.deny, err = parseCIDRs(deny); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Permit 判断是否接受来自 ip 的连接
+func (a *ClientACL) Permit(ip net.IP) bool {
+	if containsIP(a.deny, ip) {
+		return false
+	}
+	return len(a.allow) == 0 || containsIP(a.allow, ip)
+}
+
+// 封禁策略的默认值
+const (
+	DefaultMaxFailures = 5
+	DefaultBanWindow   = 10 * time.Minute
+	DefaultBanDuration = time.Hour
+)
+
+// BanOptions 定义了握手失败的封禁策略
+type BanOptions struct {
+	MaxFailures int           // Window 内允许的握手失败次数，达到后封禁，默认 5
+	Window      time.Duration // 统计失败次数的时间窗口，默认 10 分钟
+	Duration    time.Duration // 封禁时长，默认 1 小时
+	Path        string        // 封禁列表的持久化文件，为空时只保存在内存中
+}
+
+// Ban 是一条封禁记录
+type Ban struct {
+	IP    string    `json:"ip"`
+	Until time.Time `json:"until"`
+}
+
+// BanList 记录来源 IP 的握手失败次数，在时间窗口内失败过多时临时封禁该 IP，类似 fail2ban
+type BanList struct {
+	opts BanOptions
+
+	mu        sync.Mutex
+	failures  map[string][]time.Time
+	bans      map[string]time.Time
+	saved     map[string]time.Time // 上次读取或写入持久化文件时的封禁记录
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewBanList 创建封禁列表，配置了 Path 时从文件中恢复未过期的封禁记录
+func NewBanList(opts BanOptions) (*BanList, error) {
+	if opts.MaxFailures <= 0 {
+		opts.MaxFailures = DefaultMaxFailures
+	}
+	if opts.Window <= 0 {
+		opts.Window = DefaultBanWindow
+	}
+	if opts.Duration <= 0 {
+		opts.Duration = DefaultBanDuration
+	}
+	b := &BanList{
+		opts:     opts,
+		failures: make(map[string][]time.Time),
+		bans:     make(map[string]time.Time),
+		now:      time.Now,
+	}
+	if err := b.Reload(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Reload 从持久化文件重新读取封禁记录，用于让 bans 子命令的修改在运行中的服务端生效
+func (b *BanList) Reload() error {
+	if b.opts.Path == "" {
+		return nil
+	}
+	onDisk, err := b.readFile()
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	b.bans = make(map[string]time.Time, len(onDisk))
+	for ip, until := range onDisk {
+		if until.After(now) {
+			b.bans[ip] = until
+		}
+	}
+	b.saved = onDisk
+	return nil
+}
+
+// readFile 读取持久化文件中的封禁记录，文件不存在时返回空集合
+func (b *BanList) readFile() (map[string]time.Time, error) {
+	data, err := os.ReadFile(b.opts.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取封禁列表失败: %w", err)
+	}
+	var list []Ban
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("解析封禁列表 %s 失败: %w", b.opts.Path, err)
+	}
+	bans := make(map[string]time.Time, len(list))
+	for _, ban := range list {
+		bans[ban.IP] = ban.Until
+	}
+	return bans, nil
+}
+
+// Banned 判断 ip 当前是否处于封禁状态
+func (b *BanList) Banned(ip net.IP) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	until, ok := b.bans[ip.String()]
+	return ok && b.now().Before(until)
+}
+
+// Fail 记录一次握手失败，本次失败触发封禁时返回 true
+func (b *BanList) Fail(ip net.IP) (bool, error) {
+	key := ip.String()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	b.sweep(now)
+	if until, ok := b.bans[key]; ok && now.Before(until) {
+		return false, nil
+	}
+
+	recent := pruneBefore(b.failures[key], now.Add(-b.opts.Window))
+	recent = append(recent, now)
+	if len(recent) < b.opts.MaxFailures {
+		b.failures[key] = recent
+		return false, nil
+	}
+
+	delete(b.failures, key)
+	b.bans[key] = now.Add(b.opts.Duration)
+	return true, b.save()
+}
+
+// Unban 解除对 ip 的封禁，ip 不在封禁列表中时返回 false
+func (b *BanList) Unban(ip string) (bool, error) {
+	if parsed := net.ParseIP(ip); parsed != nil {
+		ip = parsed.String()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.failures, ip)
+	if _, ok := b.bans[ip]; !ok {
+		return false, nil
+	}
+	delete(b.bans, ip)
+	return true, b.save()
+}
+
+// List 返回未过期的封禁记录，按 IP 排序
+func (b *BanList) List() []Ban {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.list(b.now())
+}
+
+func (b *BanList) list(now time.Time) []Ban {
+	list := make([]Ban, 0, len(b.bans))
+	for ip, until := range b.bans {
+		if now.Before(until) {
+			list = append(list, Ban{IP: ip, Until: until})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].IP < list[j].IP })
+	return list
+}
+
+// sweep 每个时间窗口清理一次过期的失败记录与封禁记录，避免内存无限增长
+func (b *BanList) sweep(now time.Time) {
+	if now.Sub(b.lastSweep) < b.opts.Window {
+		return
+	}
+	b.lastSweep = now
+	since := now.Add(-b.opts.Window)
+	for ip, times := range b.failures {
+		if recent := pruneBefore(times, since); len(recent) > 0 {
+			b.failures[ip] = recent
+		} else {
+			delete(b.failures, ip)
+		}
+	}
+	for ip, until := range b.bans {
+		if !now.Before(until) {
+			delete(b.bans, ip)
+		}
+	}
+}
+
+// save 将封禁记录写入持久化文件，调用时需持有 mu。上次读写之后从文件中消失的记录
+// 是被 bans 子命令解除的，先合并这些删除再写入，避免在收到 SIGHUP 之前覆盖解除操作
+func (b *BanList) save() error {
+	if b.opts.Path == "" {
+		return nil
+	}
+	onDisk, err := b.readFile()
+	if err != nil {
+		return err
+	}
+	for ip, until := range b.saved {
+		if _, ok := onDisk[ip]; !ok && b.bans[ip].Equal(until) {
+			delete(b.bans, ip)
+		}
+	}
+
+	list := b.list(b.now())
+	data, err := json.MarshalIndent(list, "", "    ")
+	if err != nil {
+		return fmt.Errorf("序列化封禁列表失败: %w", err)
+	}
+	if err := writeFileAtomic(b.opts.Path, data); err != nil {
+		return fmt.Errorf("保存封禁列表失败: %w", err)
+	}
+	b.saved = make(map[string]time.Time, len(list))
+	for _, ban := range list {
+		b.saved[ban.IP] = ban.Until
+	}
+	return nil
+}
+
+// writeFileAtomic 先写临时文件再重命名，避免写入中途退出导致文件损坏
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// pruneBefore 去掉 since 之前的时间点，times 按时间递增排列
+func pruneBefore(times []time.Time, since time.Time) []time.Time {
+	i := sort.Search(len(times), func(i int) bool { return times[i].After(since) })
+	return times[i:]
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/beijian128/minisocks/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientACL(t *testing.T) {
+	acl, err := NewClientACL([]string{"10.0.0.0/8", "2001:db8::/32"}, []string{"10.0.0.1"})
+	require.NoError(t, err)
+	assert.True(t, acl.Permit(net.ParseIP("10.1.2.3")))
+	assert.True(t, acl.Permit(net.ParseIP("2001:db8::1")))
+	assert.False(t, acl.Permit(net.ParseIP("10.0.0.1")), "deny 优先于 allow")
+	assert.False(t, acl.Permit(net.ParseIP("8.8.8.8")))
+
+	acl, err = NewClientACL(nil, []string{"8.8.8.0/24"})
+	require.NoError(t, err)
+	assert.True(t, acl.Permit(net.ParseIP("1.1.1.1")), "allow 为空时不限制")
+	assert.False(t, acl.Permit(net.ParseIP("8.8.8.8")))
+
+	_, err = NewClientACL([]string{"bad"}, nil)
+	assert.Error(t, err)
+}
+
+func TestBanList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bans.json")
+	opts := BanOptions{MaxFailures: 3, Window: time.Minute, Duration: time.Hour, Path: path}
+	b, err := NewBanList(opts)
+	require.NoError(t, err)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	b.now = func() time.Time { return now }
+
+	ip := net.ParseIP("203.0.113.1")
+	fail := func() bool {
+		banned, err := b.Fail(ip)
+		require.NoError(t, err)
+		return banned
+	}
+
+	// 窗口外的失败不计入
+	assert.False(t, fail())
+	assert.False(t, fail())
+	now = now.Add(2 * time.Minute)
+	assert.False(t, fail())
+	assert.False(t, fail())
+	assert.False(t, b.Banned(ip))
+
+	assert.True(t, fail(), "窗口内第 3 次失败触发封禁")
+	assert.True(t, b.Banned(ip))
+	assert.False(t, b.Banned(net.ParseIP("203.0.113.2")))
+	require.Len(t, b.List(), 1)
+	assert.Equal(t, now.Add(time.Hour), b.List()[0].Until)
+
+	// 重启后从文件恢复
+	restored, err := NewBanList(opts)
+	require.NoError(t, err)
+	restored.now = b.now
+	require.NoError(t, restored.Reload())
+	assert.True(t, restored.Banned(ip))
+
+	// 另一个实例解除封禁后，Reload 使其生效
+	ok, err := restored.Unban("203.0.113.1")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	require.NoError(t, b.Reload())
+	assert.False(t, b.Banned(ip))
+	ok, err = restored.Unban("203.0.113.1")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// 封禁到期后自动解除
+	fail()
+	fail()
+	assert.True(t, fail())
+	now = now.Add(time.Hour)
+	assert.False(t, b.Banned(ip))
+	assert.Empty(t, b.List())
+}
+
+func TestBanList_SaveKeepsExternalUnban(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bans.json")
+	opts := BanOptions{MaxFailures: 1, Window: time.Minute, Duration: time.Hour, Path: path}
+	b, err := NewBanList(opts)
+	require.NoError(t, err)
+	first, second := net.ParseIP("203.0.113.1"), net.ParseIP("203.0.113.2")
+	banned, err := b.Fail(first)
+	require.NoError(t, err)
+	require.True(t, banned)
+
+	// bans 子命令解除封禁后、服务端收到 SIGHUP 之前又封禁了其他 IP，写入时不能恢复已解除的封禁
+	cli, err := NewBanList(opts)
+	require.NoError(t, err)
+	ok, err := cli.Unban(first.String())
+	require.NoError(t, err)
+	require.True(t, ok)
+	banned, err = b.Fail(second)
+	require.NoError(t, err)
+	require.True(t, banned)
+
+	assert.False(t, b.Banned(first))
+	restored, err := NewBanList(opts)
+	require.NoError(t, err)
+	require.Len(t, restored.List(), 1)
+	assert.Equal(t, second.String(), restored.List()[0].IP)
+}
+
+func TestServer_BanOnlyBadHandshakes(t *testing.T) {
+	secret := core.GenerateCipherTable()
+	s := New(secret, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	bans, err := NewBanList(BanOptions{MaxFailures: 2, Window: time.Minute, Duration: time.Hour})
+	require.NoError(t, err)
+	s.Bans = bans
+	addr := startServer(t, s)
+	ip := net.ParseIP("127.0.0.1")
+
+	// 连接后立即断开不计入封禁
+	for i := range 3 {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		conn.Close()
+		require.Eventually(t, func() bool { return s.failures.Load() == int64(i+1) }, 5*time.Second, 10*time.Millisecond)
+	}
+	assert.False(t, bans.Banned(ip))
+
+	// 发送无效的握手数据计入封禁
+	ci, err := core.NewSimple(secret)
+	require.NoError(t, err)
+	greeting, err := ci.Encrypt([]byte{0x04, 0x01, 0x00})
+	require.NoError(t, err)
+	for range 2 {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		conn.Write(greeting)
+		conn.Read(make([]byte, 1))
+		conn.Close()
+	}
+	assert.Eventually(t, func() bool { return bans.Banned(ip) }, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"github.com/beijian128/minisocks/core"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// permitListener 在交给 HTTP 服务之前按来源 IP 过滤连接
+type permitListener struct {
+	net.Listener
+	server *LsServer
+}
+
+func (l *permitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if !l.server.permit(conn.RemoteAddr()) {
+			conn.Close()
+			continue
+		}
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			tcpConn.SetLinger(0)
+		}
+		return conn, nil
+	}
+}
+
+// servesHTTP 判断服务端是否以 HTTP 服务的形式监听
+func (s *LsServer) servesHTTP() bool {
+	return s.WebSocket != nil || s.HTTP2 != nil
+}
+
+// serveHTTP 以 HTTP 服务的形式处理连接，WebSocket 与 HTTP/2 隧道之外的请求交给诱饵服务或返回 404
+func (s *LsServer) serveHTTP(listener net.Listener) error {
+	srv := &http.Server{
+		Handler:           s.httpHandler(),
+		ReadHeaderTimeout: core.TIMEOUT,
+	}
+	var ln net.Listener = &permitListener{Listener: listener, server: s}
+	if s.TLSConfig != nil {
+		config := s.TLSConfig
+		if s.HTTP2 != nil {
+			srv.TLSConfig = config.Clone()
+			if err := http2.ConfigureServer(srv, &http2.Server{}); err != nil {
+				return err
+			}
+			config = srv.TLSConfig
+		}
+		ln = tls.NewListener(ln, config)
+	} else if s.HTTP2 != nil {
+		// 明文监听时接受 h2c
+		srv.Handler = h2c.NewHandler(srv.Handler, &http2.Server{})
+	}
+	return srv.Serve(ln)
+}
+
+func (s *LsServer) httpHandler() http.Handler {
+	var ws, h2 http.Handler
+	if s.WebSocket != nil {
+		ws = s.webSocketHandler()
+	}
+	if s.HTTP2 != nil {
+		h2 = s.http2Handler()
+	}
+
+	fallback := http.NotFoundHandler()
+	if s.Fallback != nil && s.Fallback.Addr != "" {
+		fallback = httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: s.Fallback.Addr})
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var tunnel http.Handler
+		switch {
+		case ws != nil && r.URL.Path == handlerPath(s.WebSocket.Path) &&
+			strings.EqualFold(r.Header.Get("Upgrade"), "websocket"):
+			tunnel = ws
+		case h2 != nil && r.URL.Path == handlerPath(s.HTTP2.Path) &&
+			r.ProtoMajor == 2 && r.Method == http.MethodPost:
+			tunnel = h2
+		default:
+			fallback.ServeHTTP(w, r)
+			return
+		}
+		if s.realIPHeader() != "" && !s.permit(s.realAddr(r)) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		tunnel.ServeHTTP(w, r)
+	})
+}
+
+// handlerPath 返回隧道的 HTTP 路径，默认 "/"
+func handlerPath(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+// realIPHeader 返回传递客户端真实 IP 的请求头，未配置时返回空字符串
+func (s *LsServer) realIPHeader() string {
+	if s.WebSocket != nil && s.WebSocket.RealIPHeader != "" {
+		return s.WebSocket.RealIPHeader
+	}
+	if s.HTTP2 != nil {
+		return s.HTTP2.RealIPHeader
+	}
+	return ""
+}
+
+// realAddr 返回客户端地址，配置了真实 IP 请求头时取请求头中的第一个地址
+func (s *LsServer) realAddr(r *http.Request) *net.TCPAddr {
+	if header := s.realIPHeader(); header != "" {
+		first, _, _ := strings.Cut(r.Header.Get(header), ",")
+		if ip := net.ParseIP(strings.TrimSpace(first)); ip != nil {
+			return &net.TCPAddr{IP: ip}
+		}
+	}
+	addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	if err != nil {
+		return &net.TCPAddr{IP: net.IPv4zero}
+	}
+	return addr
+}
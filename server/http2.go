@@ -0,0 +1,85 @@
+package server
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// HTTP2Options 定义了服务端的 HTTP/2 传输，每条代理连接是一个 POST 流，
+// 请求体承载本地端发来的数据，响应体承载返回给本地端的数据
+type HTTP2Options struct {
+	Path string // 接受隧道请求的 HTTP 路径，默认 "/"
+	// RealIPHeader 是反向代理传递客户端真实 IP 的请求头，含义与 WebSocketOptions.RealIPHeader 相同
+	RealIPHeader string
+}
+
+// http2Conn 把一个 HTTP/2 请求包装为 net.Conn，写入的数据立即刷新到响应体
+type http2Conn struct {
+	body       io.ReadCloser
+	w          http.ResponseWriter
+	rc         *http.ResponseController
+	localAddr  net.Addr
+	remoteAddr net.Addr
+}
+
+func (c *http2Conn) Read(b []byte) (int, error) {
+	return c.body.Read(b)
+}
+
+func (c *http2Conn) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	if err != nil {
+		return n, err
+	}
+	return n, c.rc.Flush()
+}
+
+// Close 关闭请求体，处理函数返回后流随之结束
+func (c *http2Conn) Close() error {
+	return c.body.Close()
+}
+
+func (c *http2Conn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+func (c *http2Conn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+func (c *http2Conn) SetDeadline(t time.Time) error {
+	if err := c.rc.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.rc.SetWriteDeadline(t)
+}
+
+func (c *http2Conn) SetReadDeadline(t time.Time) error {
+	return c.rc.SetReadDeadline(t)
+}
+
+func (c *http2Conn) SetWriteDeadline(t time.Time) error {
+	return c.rc.SetWriteDeadline(t)
+}
+
+func (s *LsServer) http2Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 先发送响应头，本地端收到后才开始收发数据
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.WriteHeader(http.StatusOK)
+		rc := http.NewResponseController(w)
+		if err := rc.Flush(); err != nil {
+			return
+		}
+		localAddr, _ := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+		s.handleConn(&http2Conn{
+			body:       r.Body,
+			w:          w,
+			rc:         rc,
+			localAddr:  localAddr,
+			remoteAddr: s.realAddr(r),
+		})
+	})
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/beijian128/minisocks/core"
+	"github.com/beijian128/minisocks/dns"
+	"github.com/beijian128/minisocks/metrics"
+)
+
+// serverMetrics 是服务端导出的 Prometheus 指标
+type serverMetrics struct {
+	registry          *metrics.Registry
+	accepted          *metrics.Counter
+	active            *metrics.Gauge
+	handshakeFailures *metrics.Counter
+	dialDuration      *metrics.Histogram
+	dnsDuration       *metrics.Histogram
+	bytes             *metrics.Counter
+	cipherErrors      *metrics.Counter
+}
+
+func newServerMetrics() *serverMetrics {
+	r := metrics.NewRegistry()
+	return &serverMetrics{
+		registry:          r,
+		accepted:          r.Counter("minisocks_server_connections_accepted_total", "接受的连接数"),
+		active:            r.Gauge("minisocks_server_connections_active", "正在处理的连接数"),
+		handshakeFailures: r.Counter("minisocks_server_handshake_failures_total", "握手失败的连接数", "reason"),
+		dialDuration:      r.Histogram("minisocks_server_dial_duration_seconds", "连接目标的耗时，包括域名解析", nil, "result"),
+		dnsDuration:       r.Histogram("minisocks_server_dns_duration_seconds", "解析目标域名的耗时", nil, "result"),
+		bytes:             r.Counter("minisocks_server_bytes_total", "转发的字节数，direction 为 up（本地端到目标）或 down", "direction", "user"),
+		cipherErrors:      r.Counter("minisocks_server_cipher_errors_total", "加解密失败次数"),
+	}
+}
+
+// Metrics 返回服务端的指标，可以通过 ListenAndServe 提供给 Prometheus 抓取
+func (s *LsServer) Metrics() *metrics.Registry {
+	return s.metrics.registry
+}
+
+// failureReason 返回握手失败原因的指标标签
+func failureReason(err error) string {
+	var cipherErr *core.CipherError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrUnknownUser):
+		return "unknown_user"
+	case errors.Is(err, core.ErrKexAuth):
+		return "kex_auth"
+	case errors.Is(err, core.ErrKexReplay):
+		return "kex_replay"
+	case errors.As(err, &cipherErr):
+		return "cipher"
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.As(err, &netErr):
+		return "io"
+	default:
+		return "protocol"
+	}
+}
+
+// cipherError 在 err 是加解密错误时计数
+func (m *serverMetrics) cipherError(err error) {
+	var cipherErr *core.CipherError
+	if errors.As(err, &cipherErr) {
+		m.cipherErrors.Inc()
+	}
+}
+
+// byteCounters 返回统计 user 上行与下行字节数的 Throttle
+func (m *serverMetrics) byteCounters(user string) (up, down core.Throttle) {
+	return counterThrottle(m.bytes.With("up", user)), counterThrottle(m.bytes.With("down", user))
+}
+
+func counterThrottle(v *metrics.Value) core.Throttle {
+	return core.ThrottleFunc(func(n int) error {
+		v.Add(float64(n))
+		return nil
+	})
+}
+
+// result 返回耗时指标的 result 标签，被出站策略禁止时为 denied
+func result(err error) string {
+	switch {
+	case err == nil:
+		return "success"
+	case errors.Is(err, ErrEgressDenied):
+		return "denied"
+	default:
+		return "error"
+	}
+}
+
+// timedResolver 记录每次解析的耗时
+type timedResolver struct {
+	dns.Resolver
+	duration *metrics.Histogram
+}
+
+func (r timedResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	start := time.Now()
+	ips, err := r.Resolver.LookupIP(ctx, host)
+	r.duration.Observe(time.Since(start).Seconds(), result(err))
+	return ips, err
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beijian128/minisocks/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailureReason(t *testing.T) {
+	assert.Equal(t, "unknown_user", failureReason(ErrUnknownUser))
+	assert.Equal(t, "kex_auth", failureReason(fmt.Errorf("密钥交换失败: %w", core.ErrKexAuth)))
+	assert.Equal(t, "kex_replay", failureReason(core.ErrKexReplay))
+	assert.Equal(t, "cipher", failureReason(&core.CipherError{Op: "解密握手数据", Err: errors.New("认证失败")}))
+	assert.Equal(t, "io", failureReason(fmt.Errorf("读取握手数据失败: %w", io.EOF)))
+	assert.Equal(t, "io", failureReason(fmt.Errorf("读取协商数据失败: %w", os.ErrDeadlineExceeded)))
+	assert.Equal(t, "protocol", failureReason(errors.New("不支持的协议版本，仅支持 Socks5")))
+}
+
+func TestResult(t *testing.T) {
+	assert.Equal(t, "success", result(nil))
+	assert.Equal(t, "denied", result(fmt.Errorf("%w: 地址 127.0.0.1 属于内网或保留地址段", ErrEgressDenied)))
+	assert.Equal(t, "error", result(errors.New("连接被拒绝")))
+}
+
+func TestMetrics_SessionCipherError(t *testing.T) {
+	s := New(core.GenerateCipherTable(), &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	s.KeyExchange = core.NewKeyExchange("secret")
+	addr := startServer(t, s)
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = core.NewKeyExchange("secret").Client(conn)
+	require.NoError(t, err)
+	// 密钥交换完成后绕过会话加密直接写入无法通过认证的帧
+	_, err = conn.Write(make([]byte, 64))
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		var out strings.Builder
+		s.Metrics().WriteTo(&out)
+		return strings.Contains(out.String(), `minisocks_server_handshake_failures_total{reason="cipher"} 1`+"\n") &&
+			strings.Contains(out.String(), "minisocks_server_cipher_errors_total 1\n")
+	}, 5*time.Second, 10*time.Millisecond)
+}
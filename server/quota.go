@@ -0,0 +1,172 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrQuotaExceeded 表示客户端身份在当前周期内的流量配额已经用尽
+var ErrQuotaExceeded = errors.New("流量配额已用尽")
+
+// QuotaOptions 定义了每个客户端身份的流量配额，上下行合计，为 0 表示不限制
+type QuotaOptions struct {
+	Daily   int64  // 每个自然日的字节数上限，按本地时区在零点重置
+	Monthly int64  // 每个自然月的字节数上限，在每月 1 日零点重置
+	Path    string // 用量的持久化文件，为空时只保存在内存中
+}
+
+// QuotaUsage 是一个客户端身份在当前周期内的用量
+type QuotaUsage struct {
+	Identity   string `json:"identity"`
+	Day        string `json:"day"`        // 日用量所属的日期，例如 2024-05-01
+	DayBytes   int64  `json:"dayBytes"`   // 当日已用字节数
+	Month      string `json:"month"`      // 月用量所属的月份，例如 2024-05
+	MonthBytes int64  `json:"monthBytes"` // 当月已用字节数
+}
+
+// Quotas 统计每个客户端身份的流量并在配额用尽时拒绝服务。用量在内存中累计，
+// 调用 Save 时写入持久化文件
+type Quotas struct {
+	opts QuotaOptions
+
+	mu    sync.Mutex
+	usage map[string]*QuotaUsage
+	dirty bool
+	now   func() time.Time
+}
+
+// NewQuotas 创建流量配额，配置了 Path 时从文件中恢复当前周期的用量
+func NewQuotas(opts QuotaOptions) (*Quotas, error) {
+	q := &Quotas{opts: opts, usage: make(map[string]*QuotaUsage), now: time.Now}
+	if opts.Path == "" {
+		return q, nil
+	}
+	data, err := os.ReadFile(opts.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取流量用量失败: %w", err)
+	}
+	var list []QuotaUsage
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("解析流量用量 %s 失败: %w", opts.Path, err)
+	}
+	for _, usage := range list {
+		q.usage[usage.Identity] = &usage
+	}
+	return q, nil
+}
+
+// Exceeded 判断 identity 在当前周期内的配额是否已经用尽
+func (q *Quotas) Exceeded(identity string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	usage, ok := q.usage[identity]
+	return ok && q.exceeded(q.rollover(usage))
+}
+
+// Add 为 identity 累计 n 字节，累计后配额用尽时返回 ErrQuotaExceeded
+func (q *Quotas) Add(identity string, n int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	usage, ok := q.usage[identity]
+	if !ok {
+		usage = &QuotaUsage{Identity: identity}
+		q.usage[identity] = usage
+	}
+	q.rollover(usage)
+	usage.DayBytes += n
+	usage.MonthBytes += n
+	q.dirty = true
+	if q.exceeded(usage) {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// Reset 清零 identity 当前周期的用量，identity 没有用量记录时返回 false
+func (q *Quotas) Reset(identity string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	usage, ok := q.usage[identity]
+	if !ok {
+		return false
+	}
+	usage.DayBytes, usage.MonthBytes = 0, 0
+	q.dirty = true
+	return true
+}
+
+// List 返回各客户端身份当前周期的用量，按身份排序
+func (q *Quotas) List() []QuotaUsage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	list := make([]QuotaUsage, 0, len(q.usage))
+	for _, usage := range q.usage {
+		list = append(list, *q.rollover(usage))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Identity < list[j].Identity })
+	return list
+}
+
+// Save 在用量发生变化后将其写入持久化文件
+func (q *Quotas) Save() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.opts.Path == "" || !q.dirty {
+		return nil
+	}
+	list := make([]QuotaUsage, 0, len(q.usage))
+	for _, usage := range q.usage {
+		list = append(list, *usage)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Identity < list[j].Identity })
+	data, err := json.MarshalIndent(list, "", "    ")
+	if err != nil {
+		return fmt.Errorf("序列化流量用量失败: %w", err)
+	}
+	if err := writeFileAtomic(q.opts.Path, data); err != nil {
+		return fmt.Errorf("保存流量用量失败: %w", err)
+	}
+	q.dirty = false
+	return nil
+}
+
+// rollover 进入新的日期或月份时清零对应的用量
+func (q *Quotas) rollover(usage *QuotaUsage) *QuotaUsage {
+	now := q.now()
+	if day := now.Format(time.DateOnly); usage.Day != day {
+		usage.Day, usage.DayBytes = day, 0
+		q.dirty = true
+	}
+	if month := now.Format("2006-01"); usage.Month != month {
+		usage.Month, usage.MonthBytes = month, 0
+		q.dirty = true
+	}
+	return usage
+}
+
+func (q *Quotas) exceeded(usage *QuotaUsage) bool {
+	return (q.opts.Daily > 0 && usage.DayBytes >= q.opts.Daily) ||
+		(q.opts.Monthly > 0 && usage.MonthBytes >= q.opts.Monthly)
+}
+
+// counter 返回累计 identity 流量的 Throttle，配额用尽时停止转发
+func (q *Quotas) counter(identity string) quotaCounter {
+	return quotaCounter{quotas: q, identity: identity}
+}
+
+type quotaCounter struct {
+	quotas   *Quotas
+	identity string
+}
+
+func (c quotaCounter) Wait(n int) error {
+	return c.quotas.Add(c.identity, int64(n))
+}
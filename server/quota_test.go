@@ -0,0 +1,55 @@
+package server
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuotas(t *testing.T) {
+	now := time.Date(2024, 5, 31, 23, 0, 0, 0, time.Local)
+	q, err := NewQuotas(QuotaOptions{Daily: 100, Monthly: 250})
+	require.NoError(t, err)
+	q.now = func() time.Time { return now }
+
+	assert.NoError(t, q.Add("alice", 60))
+	assert.False(t, q.Exceeded("alice"))
+	assert.ErrorIs(t, q.Add("alice", 40), ErrQuotaExceeded)
+	assert.True(t, q.Exceeded("alice"))
+	assert.False(t, q.Exceeded("bob"), "配额按身份统计")
+
+	// 次日零点重置日配额，月配额跨月重置
+	now = now.Add(2 * time.Hour)
+	assert.False(t, q.Exceeded("alice"))
+	require.NoError(t, q.Add("alice", 90))
+	assert.Equal(t, []QuotaUsage{{Identity: "alice", Day: "2024-06-01", DayBytes: 90, Month: "2024-06", MonthBytes: 90}}, q.List())
+
+	now = now.Add(24 * time.Hour)
+	require.NoError(t, q.Add("alice", 90))
+	now = now.Add(24 * time.Hour)
+	assert.ErrorIs(t, q.Add("alice", 90), ErrQuotaExceeded, "月配额用尽")
+	now = now.Add(24 * time.Hour)
+	assert.True(t, q.Exceeded("alice"), "月配额用尽后次日仍然拒绝")
+
+	assert.True(t, q.Reset("alice"))
+	assert.False(t, q.Exceeded("alice"), "手动重置配额")
+	assert.False(t, q.Reset("bob"))
+}
+
+func TestQuotas_Persist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.json")
+	q, err := NewQuotas(QuotaOptions{Daily: 100, Path: path})
+	require.NoError(t, err)
+	assert.ErrorIs(t, q.Add("alice", 100), ErrQuotaExceeded)
+	require.NoError(t, q.Add("bob", 10))
+	require.NoError(t, q.Save())
+
+	q, err = NewQuotas(QuotaOptions{Daily: 100, Path: path})
+	require.NoError(t, err)
+	assert.True(t, q.Exceeded("alice"), "重启后恢复用量")
+	assert.False(t, q.Exceeded("bob"))
+	assert.Len(t, q.List(), 2)
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/beijian128/minisocks/core"
+)
+
+// Bandwidth 定义了一组令牌桶限速，单位为字节每秒，为 0 表示不限制
+type Bandwidth struct {
+	Up    int64 // 上行（本地端到目标）速率
+	Down  int64 // 下行（目标到本地端）速率
+	Burst int64 // 令牌桶容量，为 0 时等于一秒的速率
+}
+
+// RateLimitOptions 定义了服务端的限速策略
+type RateLimitOptions struct {
+	Client Bandwidth // 同一客户端身份（用户名，未配置用户时为来源 IP）的所有连接共享的速率
+	Conn   Bandwidth // 每条连接各自的速率
+}
+
+// RateLimits 为每个客户端身份与每条连接分配限速器
+type RateLimits struct {
+	opts RateLimitOptions
+
+	mu      sync.Mutex
+	clients map[string]*clientLimiter
+}
+
+// clientLimiter 是一个客户端身份共享的限速器，refs 是使用它的连接数，没有连接时释放
+type clientLimiter struct {
+	up, down *core.Limiter
+	refs     int
+}
+
+// NewRateLimits 创建限速策略
+func NewRateLimits(opts RateLimitOptions) *RateLimits {
+	return &RateLimits{opts: opts, clients: make(map[string]*clientLimiter)}
+}
+
+// acquire 返回 identity 的一条新连接的上行与下行限速器，连接结束时需要调用 release
+func (r *RateLimits) acquire(identity string) (up, down []core.Throttle, release func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	client, ok := r.clients[identity]
+	if !ok {
+		client = &clientLimiter{
+			up:   newLimiter(r.opts.Client.Up, r.opts.Client.Burst),
+			down: newLimiter(r.opts.Client.Down, r.opts.Client.Burst),
+		}
+		r.clients[identity] = client
+	}
+	client.refs++
+
+	up = appendLimiter(up, client.up)
+	up = appendLimiter(up, newLimiter(r.opts.Conn.Up, r.opts.Conn.Burst))
+	down = appendLimiter(down, client.down)
+	down = appendLimiter(down, newLimiter(r.opts.Conn.Down, r.opts.Conn.Burst))
+
+	release = func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if client.refs--; client.refs == 0 {
+			delete(r.clients, identity)
+		}
+	}
+	return up, down, release
+}
+
+// newLimiter 创建限速器，rate 不大于 0 时返回 nil
+func newLimiter(rate, burst int64) *core.Limiter {
+	if rate <= 0 {
+		return nil
+	}
+	return core.NewLimiter(rate, burst)
+}
+
+func appendLimiter(throttles []core.Throttle, l *core.Limiter) []core.Throttle {
+	if l == nil {
+		return throttles
+	}
+	return append(throttles, l)
+}
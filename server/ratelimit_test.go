@@ -0,0 +1,35 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimits(t *testing.T) {
+	r := NewRateLimits(RateLimitOptions{
+		Client: Bandwidth{Up: 1000, Down: 2000},
+		Conn:   Bandwidth{Down: 500},
+	})
+
+	up1, down1, release1 := r.acquire("alice")
+	assert.Len(t, up1, 1, "没有配置单连接上行限速")
+	assert.Len(t, down1, 2)
+	up2, down2, release2 := r.acquire("alice")
+	assert.Same(t, up1[0], up2[0], "同一身份共享限速器")
+	assert.NotSame(t, down1[1], down2[1], "每条连接使用独立的限速器")
+
+	up3, _, release3 := r.acquire("bob")
+	assert.NotSame(t, up1[0], up3[0])
+	release3()
+
+	release1()
+	assert.Contains(t, r.clients, "alice")
+	release2()
+	assert.Empty(t, r.clients, "没有连接时释放限速器")
+
+	up, down, release := NewRateLimits(RateLimitOptions{}).acquire("alice")
+	defer release()
+	assert.Empty(t, up)
+	assert.Empty(t, down)
+}
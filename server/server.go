@@ -1,13 +1,18 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"net"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/beijian128/minisocks/core"
+	"github.com/beijian128/minisocks/dns"
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 )
@@ -17,22 +22,57 @@ type LsServer struct {
 	*core.SecureSocket      // 嵌入 SecureSocket 结构体，用于数据的加密和解密
 	running            bool // 标识服务端是否正在运行
 	logger             *logrus.Entry
+	conns              *core.ConnTable // 正在转发的连接
+	failures           atomic.Int64    // 握手失败的连接数
+	metrics            *serverMetrics  // Prometheus 指标
 	// AfterListen 是一个回调函数，在服务端开始监听后被调用，传入监听地址
 	AfterListen func(listenAddr net.Addr)
+	// Resolver 用于解析请求中的目标域名，默认使用系统解析器
+	Resolver dns.Resolver
+	// IPStrategy 决定连接目标时使用的地址族，默认 PreferIPv6
+	IPStrategy IPStrategy
+	// Egress 限制可以连接的目标，默认禁止内网与保留地址段，为 nil 时不做限制
+	Egress *EgressPolicy
+	// Clients 按来源 IP 过滤接入的连接，为 nil 时接受所有来源
+	Clients *ClientACL
+	// Bans 在握手失败过多时临时封禁来源 IP，为 nil 时不封禁
+	Bans *BanList
+	// Fallback 接管握手失败的连接，为 nil 时直接关闭
+	Fallback *Fallback
+	// WebSocket 不为空时以 HTTP 服务的形式监听，在指定路径上接受 WebSocket 连接
+	WebSocket *WebSocketOptions
+	// HTTP2 不为空时以 HTTP 服务的形式监听，在指定路径上接受 HTTP/2 隧道请求，可以与 WebSocket 同时配置
+	HTTP2 *HTTP2Options
+	// Users 不为空时只接受其中用户的连接，每个用户使用自己的密码，SecureSocket 的密码不再使用
+	Users *Users
+	// RateLimits 限制每个客户端身份与每条连接的转发速率，为 nil 时不限速
+	RateLimits *RateLimits
+	// Quotas 统计每个客户端身份的流量，配额用尽后拒绝新连接并中断转发，为 nil 时不限制
+	Quotas *Quotas
+	// ConnLimits 限制全局、每个用户与每个来源 IP 的并发连接数，超出时直接关闭连接，为 nil 时不限制
+	ConnLimits *ConnLimits
+	// Accounting 按天统计每个客户端身份的流量与连接数，为 nil 时不统计
+	Accounting *Accounting
 }
 
 // New 新建一个服务端实例
-func New(secret string, localAddr *net.TCPAddr) *LsServer {
+func New(secret string, localAddr net.Addr) *LsServer {
 	logger := logrus.WithFields(logrus.Fields{
 		"component":  "LsServer",
-		"listenAddr": localAddr.String(),
+		"listenAddr": localAddr,
 	})
 	logger.Debug("创建新的服务端实例")
 
 	ci, _ := core.NewSimple(secret)
+	egress, _ := NewEgressPolicy(EgressOptions{})
 	return &LsServer{
 		SecureSocket: core.NewSecureSocket(ci, localAddr, nil),
 		logger:       logger,
+		conns:        core.NewConnTable(),
+		metrics:      newServerMetrics(),
+		Resolver:     dns.SystemResolver{},
+		IPStrategy:   PreferIPv6,
+		Egress:       egress,
 	}
 }
 
@@ -40,7 +80,14 @@ func New(secret string, localAddr *net.TCPAddr) *LsServer {
 func (s *LsServer) Listen() error {
 	s.logger.Info("开始监听")
 
-	listener, err := net.ListenTCP("tcp", s.LocalAddr)
+	transport := s.Transport
+	if transport == nil {
+		if s.LocalAddr == nil {
+			return errors.New("未配置监听地址")
+		}
+		transport = core.NewTCPTransport(s.LocalAddr)
+	}
+	listener, err := transport.Listen()
 	if err != nil {
 		s.logger.WithError(err).Error("监听失败")
 		return fmt.Errorf("监听失败: %w", err)
@@ -54,22 +101,79 @@ func (s *LsServer) Listen() error {
 		s.AfterListen(listener.Addr())
 	}
 
+	if s.servesHTTP() {
+		return s.serveHTTP(listener)
+	}
+
+	var delay time.Duration
 	for s.running {
 		s.logger.Debug("等待新连接")
-		localConn, err := listener.AcceptTCP()
+		localConn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
 		if err != nil {
-			s.logger.WithError(err).Error("接受连接失败")
+			// 文件描述符耗尽（EMFILE）等错误会立即重复出现，等待一段时间再重试
+			delay = acceptBackoff(delay)
+			s.logger.WithError(err).WithField("delay", delay).Error("接受连接失败")
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+
+		if !s.permit(localConn.RemoteAddr()) {
+			localConn.Close()
 			continue
 		}
 
 		s.logger.WithField("remoteAddr", localConn.RemoteAddr()).Debug("接受新连接")
-		localConn.SetLinger(0)
-		go s.handleConn(localConn)
+		if tcpConn, ok := localConn.(*net.TCPConn); ok {
+			tcpConn.SetLinger(0)
+		}
+		if s.TLSConfig != nil {
+			go s.handleConn(tls.Server(localConn, s.TLSConfig))
+		} else {
+			go s.handleConn(localConn)
+		}
 	}
 
 	return nil
 }
 
+// acceptBackoff 返回接受连接失败后下一次重试前的等待时长，从 5ms 开始倍增，最长 1s
+func acceptBackoff(delay time.Duration) time.Duration {
+	if delay == 0 {
+		return 5 * time.Millisecond
+	}
+	return min(2*delay, time.Second)
+}
+
+// permit 根据来源 IP 过滤列表与封禁列表判断是否处理来自 addr 的连接，
+// 非 IP 地址（例如内存管道）不做过滤
+func (s *LsServer) permit(addr net.Addr) bool {
+	ip := addrIP(addr)
+	if ip == nil {
+		return true
+	}
+	if s.Clients != nil && !s.Clients.Permit(ip) {
+		s.logger.WithField("remoteAddr", addr).Debug("来源 IP 不在允许范围内，拒绝连接")
+		return false
+	}
+	if s.Bans != nil && s.Bans.Banned(ip) {
+		s.logger.WithField("remoteAddr", addr).Debug("来源 IP 已被封禁，拒绝连接")
+		return false
+	}
+	return true
+}
+
+// addrIP 返回地址中的 IP，非 TCP 地址返回 nil
+func addrIP(addr net.Addr) net.IP {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP
+	}
+	return nil
+}
+
 // Close 停止运行当前服务端并释放对应资源
 func (s *LsServer) Close() {
 	s.logger.Info("关闭服务端")
@@ -78,7 +182,7 @@ func (s *LsServer) Close() {
 }
 
 // handleConn 处理来自本地端的连接，实现 socks5 协议
-func (s *LsServer) handleConn(localConn *net.TCPConn) {
+func (s *LsServer) handleConn(localConn net.Conn) {
 	connID := uuid.New().String()
 	logger := s.logger.WithFields(logrus.Fields{
 		"connID":     connID,
@@ -86,121 +190,271 @@ func (s *LsServer) handleConn(localConn *net.TCPConn) {
 	})
 	logger.Debug("开始处理连接")
 	defer localConn.Close()
+	s.metrics.accepted.Inc()
+	s.metrics.active.Inc()
+	defer s.metrics.active.Dec()
+
+	if s.ConnLimits != nil {
+		release, err := s.ConnLimits.Acquire(addrIP(localConn.RemoteAddr()))
+		if err != nil {
+			logger.WithError(err).Warn("拒绝连接")
+			return
+		}
+		defer release()
+	}
 
 	buf := make([]byte, 256)
 
+	// 启用混淆、HTTP 伪装、密钥交换或多用户时由 recorder 记录握手阶段读取的原始数据，握手失败时交给 Fallback
+	conn := localConn
+	var recorder *recordConn
+	if s.HTTPObfs != nil || s.KeyExchange != nil || s.Obfs != nil || s.Users != nil {
+		recorder = &recordConn{Conn: localConn}
+		conn = recorder
+	}
+	if s.HTTPObfs != nil {
+		conn = core.NewHTTPObfsServer(conn)
+	}
+	ss, identity, userName := s.SecureSocket, clientIdentity(localConn.RemoteAddr()), ""
+	if s.Users != nil {
+		identified, user, err := s.identify(conn)
+		if err != nil {
+			logger.WithError(err).Error("识别用户失败")
+			s.reject(logger, localConn, recorder.stop(), err)
+			return
+		}
+		conn, ss, identity, userName = identified, user.socket, user.Name, user.Name
+		logger = logger.WithField("user", user.Name)
+		logger.Debug("识别用户成功")
+		if user.Disabled() {
+			logger.Warn("用户已停用，拒绝连接")
+			return
+		}
+		if s.ConnLimits != nil {
+			release, err := s.ConnLimits.AcquireUser(user.Name)
+			if err != nil {
+				logger.WithError(err).Warn("拒绝连接")
+				return
+			}
+			defer release()
+		}
+	} else if s.KeyExchange != nil {
+		session, err := s.KeyExchange.Server(conn)
+		if err != nil {
+			logger.WithError(err).Error("密钥交换失败")
+			s.reject(logger, localConn, recorder.stop(), err)
+			return
+		}
+		conn = session
+	}
+	if s.Obfs != nil {
+		conn = core.NewObfsConn(core.NewCipherConn(conn, ss.Cipher), s.Obfs)
+		defer conn.Close()
+	}
+
+	if s.Quotas != nil && s.Quotas.Exceeded(identity) {
+		logger.WithField("identity", identity).Warn("流量配额已用尽，拒绝连接")
+		return
+	}
+
 	// 处理 SOCKS5 握手
-	if err := s.handleHandshake(logger, localConn, buf); err != nil {
+	if n, err := s.handleHandshake(logger, ss, conn, buf); err != nil {
 		logger.WithError(err).Error("握手失败")
+		consumed := buf[:n]
+		if recorder != nil {
+			consumed = recorder.stop()
+		}
+		s.reject(logger, localConn, consumed, err)
 		return
 	}
+	if recorder != nil {
+		recorder.stop()
+	}
 
 	// 处理 SOCKS5 请求
-	dstServer, err := s.handleRequest(logger, localConn, buf)
+	dstServer, target, err := s.handleRequest(logger, ss, conn, buf)
 	if err != nil {
 		logger.WithError(err).Error("请求处理失败")
+		s.metrics.cipherError(err)
 		return
 	}
 	defer dstServer.Close()
 
+	// 登记到连接表，管理接口可以据此中断连接
+	tracked := s.conns.Add(core.ConnInfo{
+		ID:     connID,
+		User:   userName,
+		Source: localConn.RemoteAddr().String(),
+		Target: target,
+	}, func() {
+		localConn.Close()
+		dstServer.Close()
+	})
+	defer tracked.Remove()
+
 	// 开始转发数据
-	s.startForwarding(logger, localConn, dstServer)
+	up, down, release := s.throttles(identity)
+	defer release()
+	bytesUp, bytesDown := s.metrics.byteCounters(userName)
+	up, down = append(up, tracked.Up(), bytesUp), append(down, tracked.Down(), bytesDown)
+	s.startForwarding(logger, ss, conn, dstServer, up, down)
+}
+
+// clientIdentity 返回未配置用户时的客户端身份，即来源 IP，非 IP 地址使用地址本身
+func clientIdentity(addr net.Addr) string {
+	if ip := addrIP(addr); ip != nil {
+		return ip.String()
+	}
+	return addr.String()
+}
+
+// throttles 为 identity 计入一次连接，返回该连接的上行与下行 Throttle，连接结束时需要调用 release
+func (s *LsServer) throttles(identity string) (up, down []core.Throttle, release func()) {
+	release = func() {}
+	if s.Accounting != nil {
+		s.Accounting.AddConn(identity)
+		up, down = append(up, s.Accounting.counter(identity, true)), append(down, s.Accounting.counter(identity, false))
+	}
+	if s.Quotas != nil {
+		counter := s.Quotas.counter(identity)
+		up, down = append(up, counter), append(down, counter)
+	}
+	if s.RateLimits != nil {
+		limitUp, limitDown, releaseLimits := s.RateLimits.acquire(identity)
+		up, down, release = append(up, limitUp...), append(down, limitDown...), releaseLimits
+	}
+	return up, down, release
+}
+
+// reject 处理握手失败的连接：按失败原因记录次数，需要时交给 Fallback，consumed 是已经读取的原始数据
+func (s *LsServer) reject(logger *logrus.Entry, localConn net.Conn, consumed []byte, err error) {
+	s.failures.Add(1)
+	s.metrics.handshakeFailures.Inc(failureReason(err))
+	s.metrics.cipherError(err)
+	if ip := addrIP(localConn.RemoteAddr()); s.Bans != nil && ip != nil && badHandshake(consumed, err) {
+		banned, err := s.Bans.Fail(ip)
+		if err != nil {
+			logger.WithError(err).Error("保存封禁列表失败")
+		}
+		if banned {
+			logger.Warn("握手失败次数过多，封禁来源 IP")
+		}
+	}
+	// WebSocket 与 HTTP/2 传输下诱饵服务由 HTTP 层处理
+	if s.Fallback != nil && !s.servesHTTP() && len(consumed) > 0 {
+		s.Fallback.Handle(logger, localConn, consumed)
+	}
 }
 
-func (s *LsServer) handleHandshake(logger *logrus.Entry, conn *net.TCPConn, buf []byte) error {
+// badHandshake 判断握手失败是否由客户端发送了无效数据导致，只有这类失败计入封禁。
+// 连接后未发送数据即断开、读取超时等网络错误常见于 NAT 后的用户、健康检查与不稳定的移动网络，不计入
+func badHandshake(consumed []byte, err error) bool {
+	return len(consumed) > 0 && failureReason(err) != "io"
+}
+
+// handleHandshake 处理 SOCKS5 握手，返回从连接中读取的字节数
+func (s *LsServer) handleHandshake(logger *logrus.Entry, ss *core.SecureSocket, conn net.Conn, buf []byte) (int, error) {
 	logger.Debug("开始握手")
 
 	n, err := conn.Read(buf)
 	if err != nil {
-		return fmt.Errorf("读取握手数据失败: %w", err)
+		return n, fmt.Errorf("读取握手数据失败: %w", err)
 	}
 
-	data, err := s.Cipher.Decrypt(buf[:n])
-	if err != nil || data[0] != 0x05 {
-		if err != nil {
-			return fmt.Errorf("解密握手数据失败: %w", err)
-		}
-		return errors.New("不支持的协议版本，仅支持 Socks5")
+	// 在副本上解密，保留 buf[:n] 中的原始数据，握手失败时交给 Fallback
+	data, err := ss.Cipher.Decrypt(append([]byte(nil), buf[:n]...))
+	if err != nil {
+		return n, &core.CipherError{Op: "解密握手数据", Err: err}
+	}
+	if len(data) < 2 || data[0] != 0x05 {
+		return n, errors.New("不支持的协议版本，仅支持 Socks5")
 	}
 
 	if data[1] != 0x01 {
-		return fmt.Errorf("不支持的请求类型: 0x%x，仅支持 CONNECT(0x01)", data[1])
+		return n, fmt.Errorf("不支持的请求类型: 0x%x，仅支持 CONNECT(0x01)", data[1])
 	}
 
 	// 发送验证通过响应
-	response, _ := s.Cipher.Encrypt([]byte{0x05, 0x00})
+	response, _ := ss.Cipher.Encrypt([]byte{0x05, 0x00})
 	if _, err := conn.Write(response); err != nil {
-		return fmt.Errorf("发送验证响应失败: %w", err)
+		return n, fmt.Errorf("发送验证响应失败: %w", err)
 	}
 
 	logger.Debug("握手成功")
-	return nil
+	return n, nil
 }
 
-func (s *LsServer) handleRequest(logger *logrus.Entry, conn *net.TCPConn, buf []byte) (*net.TCPConn, error) {
+// handleRequest 处理 SOCKS5 请求并连接目标，返回目标连接与请求的目标地址
+func (s *LsServer) handleRequest(logger *logrus.Entry, ss *core.SecureSocket, conn net.Conn, buf []byte) (net.Conn, string, error) {
 	logger.Debug("处理请求")
 
 	n, err := conn.Read(buf)
 	if err != nil {
-		return nil, fmt.Errorf("读取请求数据失败: %w", err)
+		return nil, "", fmt.Errorf("读取请求数据失败: %w", err)
 	}
 
-	data, err := s.Cipher.Decrypt(buf[:n])
+	data, err := ss.Cipher.Decrypt(buf[:n])
 	if err != nil || len(data) < 7 {
 		if err != nil {
-			return nil, fmt.Errorf("解密请求数据失败: %w", err)
+			return nil, "", &core.CipherError{Op: "解密请求数据", Err: err}
 		}
-		return nil, fmt.Errorf("请求数据长度不足，期望至少 7 字节，实际 %d 字节", len(data))
+		return nil, "", fmt.Errorf("请求数据长度不足，期望至少 7 字节，实际 %d 字节", len(data))
 	}
 
-	var dIP []byte
+	var host string
 	switch data[3] {
 	case 0x01:
-		dIP = data[4 : 4+net.IPv4len]
+		host = net.IP(data[4 : 4+net.IPv4len]).String()
 	case 0x03:
-		domain := string(data[5 : len(data)-2])
-		ipAddr, err := net.ResolveIPAddr("ip", domain)
-		if err != nil {
-			return nil, fmt.Errorf("解析域名 %s 失败: %w", domain, err)
-		}
-		dIP = ipAddr.IP
+		host = string(data[5 : len(data)-2])
 	case 0x04:
-		dIP = data[4 : 4+net.IPv6len]
+		host = net.IP(data[4 : 4+net.IPv6len]).String()
 	default:
-		return nil, fmt.Errorf("不支持的目标地址类型: 0x%x", data[3])
+		return nil, "", fmt.Errorf("不支持的目标地址类型: 0x%x", data[3])
 	}
-
-	dPort := data[len(data)-2:]
-	dstAddr := &net.TCPAddr{
-		IP:   dIP,
-		Port: int(binary.BigEndian.Uint16(dPort)),
+	port := int(binary.BigEndian.Uint16(data[len(data)-2:]))
+
+	target := net.JoinHostPort(host, strconv.Itoa(port))
+	logger.WithField("targetAddr", target).Debug("连接目标服务器")
+	resolver := timedResolver{Resolver: s.Resolver, duration: s.metrics.dnsDuration}
+	dialer := &Dialer{Resolver: resolver, Strategy: s.IPStrategy, Policy: s.Egress}
+	ctx, cancel := context.WithTimeout(context.Background(), core.TIMEOUT)
+	start := time.Now()
+	dstServer, err := dialer.DialContext(ctx, host, port)
+	cancel()
+	s.metrics.dialDuration.Observe(time.Since(start).Seconds(), result(err))
+	if errors.Is(err, ErrEgressDenied) {
+		// 回复 0x02（规则不允许的连接）
+		deniedResp, _ := ss.Cipher.Encrypt([]byte{0x05, 0x02, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+		conn.Write(deniedResp)
+		return nil, "", err
 	}
-
-	logger.WithField("targetAddr", dstAddr.String()).Debug("连接目标服务器")
-	dstServer, err := net.DialTCP("tcp", nil, dstAddr)
 	if err != nil {
-		return nil, fmt.Errorf("连接目标服务器失败: %w", err)
+		return nil, "", fmt.Errorf("连接目标服务器失败: %w", err)
 	}
 
 	// 发送成功响应
-	successResp, _ := s.Cipher.Encrypt([]byte{0x05, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	successResp, _ := ss.Cipher.Encrypt([]byte{0x05, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
 	if _, err := conn.Write(successResp); err != nil {
 		dstServer.Close()
-		return nil, fmt.Errorf("发送成功响应失败: %w", err)
+		return nil, "", fmt.Errorf("发送成功响应失败: %w", err)
 	}
 
-	if err := dstServer.SetLinger(0); err != nil {
-		logger.WithError(err).Warn("设置 Linger 失败")
+	if tcpConn, ok := dstServer.(*net.TCPConn); ok {
+		if err := tcpConn.SetLinger(0); err != nil {
+			logger.WithError(err).Warn("设置 Linger 失败")
+		}
 	}
 	if err := dstServer.SetDeadline(time.Now().Add(core.TIMEOUT)); err != nil {
 		logger.WithError(err).Warn("设置 Deadline 失败")
 	}
 
 	logger.Debug("请求处理成功")
-	return dstServer, nil
+	return dstServer, target, nil
 }
 
-func (s *LsServer) startForwarding(logger *logrus.Entry, localConn, dstServer *net.TCPConn) {
+// startForwarding 在本地端与目标之间双向转发数据，up 与 down 分别作用于上行与下行
+func (s *LsServer) startForwarding(logger *logrus.Entry, ss *core.SecureSocket, localConn net.Conn, dstServer net.Conn, up, down []core.Throttle) {
 	logger.WithFields(logrus.Fields{
 		"localAddr":  localConn.RemoteAddr(),
 		"targetAddr": dstServer.RemoteAddr(),
@@ -208,14 +462,24 @@ func (s *LsServer) startForwarding(logger *logrus.Entry, localConn, dstServer *n
 
 	// 启动解密转发协程
 	go func() {
-		if err := s.DecodeCopy(dstServer, localConn); err != nil {
+		err := ss.DecodeCopy(dstServer, localConn, up...)
+		if errors.Is(err, ErrQuotaExceeded) {
+			logger.Warn("流量配额已用尽，中断转发")
+		} else if err != nil {
 			logger.WithError(err).Debug("解密转发结束")
+			s.metrics.cipherError(err)
 		}
+		// 本地端不会半关闭连接，上行结束说明本地端已经断开，关闭目标连接使下行转发随之结束并释放连接名额
+		dstServer.Close()
 	}()
 
 	// 执行加密转发
-	if err := s.EncodeCopy(localConn, dstServer); err != nil {
+	err := ss.EncodeCopy(localConn, dstServer, down...)
+	if errors.Is(err, ErrQuotaExceeded) {
+		logger.Warn("流量配额已用尽，中断转发")
+	} else if err != nil {
 		logger.WithError(err).Debug("加密转发结束")
+		s.metrics.cipherError(err)
 	}
 
 	logger.Debug("数据转发完成")
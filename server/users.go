@@ -0,0 +1,223 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/beijian128/minisocks/core"
+)
+
+// ErrUnknownUser 表示连接的首个数据包无法用任何用户的密码解开
+var ErrUnknownUser = errors.New("无法识别用户")
+
+// socksGreeting 是本地端发送的 SOCKS5 协商数据，服务端据此识别用户
+var socksGreeting = []byte{0x05, 0x01, 0x00}
+
+// User 是多用户服务端中的一个用户，每个用户使用独立的密码
+type User struct {
+	Name     string
+	password string
+	disabled atomic.Bool
+	socket   *core.SecureSocket
+	kex      *core.KeyExchange
+}
+
+// NewUser 创建用户，password 与单用户模式的密码格式相同
+func NewUser(name, password string) (*User, error) {
+	if name == "" {
+		return nil, errors.New("用户名不能为空")
+	}
+	if err := checkCipherTable(password); err != nil {
+		return nil, fmt.Errorf("用户 %s 的密码无效: %w", name, err)
+	}
+	ci, _ := core.NewSimple(password)
+	return &User{
+		Name:     name,
+		password: password,
+		socket:   core.NewSecureSocket(ci, nil, nil),
+		kex:      core.NewKeyExchange(password),
+	}, nil
+}
+
+// Password 返回用户的密码
+func (u *User) Password() string {
+	return u.password
+}
+
+// Disabled 判断用户是否已停用，停用的用户仍然可以被识别，但连接会被拒绝
+func (u *User) Disabled() bool {
+	return u.disabled.Load()
+}
+
+// SetDisabled 停用或启用用户，只影响之后建立的连接
+func (u *User) SetDisabled(disabled bool) {
+	u.disabled.Store(disabled)
+}
+
+// checkCipherTable 校验密码是 256 字节置换表的十六进制编码
+func checkCipherTable(password string) error {
+	table, err := hex.DecodeString(password)
+	if err != nil {
+		return fmt.Errorf("不是十六进制编码: %w", err)
+	}
+	if len(table) != 256 {
+		return fmt.Errorf("长度应为 256 字节，实际 %d 字节", len(table))
+	}
+	var seen [256]bool
+	for _, b := range table {
+		if seen[b] {
+			return fmt.Errorf("字节 0x%02x 重复出现", b)
+		}
+		seen[b] = true
+	}
+	return nil
+}
+
+// greeting 返回该用户的本地端发送的加密协商数据
+func (u *User) greeting() []byte {
+	data, _ := u.socket.Cipher.Encrypt(append([]byte(nil), socksGreeting...))
+	return data
+}
+
+// greets 判断 head 是否是该用户的本地端发送的协商数据。启用混淆时 head 以加密的记录头开始，
+// 记录的载荷恰好是协商数据，与记录头一同再加密一次
+func (u *User) greets(head []byte, obfs bool) bool {
+	data, _ := u.socket.Cipher.Decrypt(append([]byte(nil), head...))
+	if obfs {
+		if int(binary.BigEndian.Uint16(data[0:2])) != len(socksGreeting) ||
+			int(binary.BigEndian.Uint16(data[2:4])) > core.MaxPaddingSize {
+			return false
+		}
+		data, _ = u.socket.Cipher.Decrypt(data[4:])
+	}
+	return bytes.Equal(data, socksGreeting)
+}
+
+// Users 是多用户服务端的用户表，可以在运行时增删用户
+type Users struct {
+	mu    sync.RWMutex
+	users []*User // 按添加顺序排列，识别用户时依次尝试
+}
+
+// NewUsers 创建用户表
+func NewUsers(users ...*User) (*Users, error) {
+	u := &Users{}
+	for _, user := range users {
+		if err := u.Add(user); err != nil {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+// Add 添加用户。用户名不能重复，不同用户的密码也必须能够区分
+func (u *Users) Add(user *User) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, existing := range u.users {
+		if existing.Name == user.Name {
+			return fmt.Errorf("用户 %s 已存在", user.Name)
+		}
+		// 协商数据相同的两个用户无法区分
+		if bytes.Equal(existing.greeting(), user.greeting()) {
+			return fmt.Errorf("用户 %s 的密码与用户 %s 无法区分", user.Name, existing.Name)
+		}
+	}
+	u.users = append(u.users, user)
+	return nil
+}
+
+// Remove 删除用户，用户不存在时返回 false。已经建立的连接不受影响
+func (u *Users) Remove(name string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for i, user := range u.users {
+		if user.Name == name {
+			u.users = append(u.users[:i:i], u.users[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Get 按用户名查找用户，不存在时返回 nil
+func (u *Users) Get(name string) *User {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	for _, user := range u.users {
+		if user.Name == name {
+			return user
+		}
+	}
+	return nil
+}
+
+// List 返回当前所有用户
+func (u *Users) List() []*User {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return append([]*User(nil), u.users...)
+}
+
+// Len 返回用户数量
+func (u *Users) Len() int {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return len(u.users)
+}
+
+// identify 从连接开始的数据识别用户，返回之后应当使用的连接。启用密钥交换时依次用每个用户的密码认证
+// 客户端 Hello，否则依次用每个用户的密码解密协商数据，读取的数据会在返回的连接上重新读出
+func (s *LsServer) identify(conn net.Conn) (net.Conn, *User, error) {
+	users := s.Users.List()
+	if s.KeyExchange != nil {
+		keys := make([]*core.KeyExchange, len(users))
+		for i, user := range users {
+			keys[i] = user.kex
+		}
+		session, i, err := core.AcceptKeyExchange(conn, keys)
+		if err != nil {
+			return nil, nil, fmt.Errorf("密钥交换失败: %w", err)
+		}
+		return session, users[i], nil
+	}
+
+	head := make([]byte, len(socksGreeting))
+	if s.Obfs != nil {
+		// 2 字节载荷长度与 2 字节填充长度组成的记录头
+		head = make([]byte, 4+len(socksGreeting))
+	}
+	err := core.ReadHello(conn, head)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("读取协商数据失败: %w", err)
+	}
+	for _, user := range users {
+		if user.greets(head, s.Obfs != nil) {
+			return &prefixConn{Conn: conn, prefix: head}, user, nil
+		}
+	}
+	return nil, nil, ErrUnknownUser
+}
+
+// prefixConn 先读出 prefix，再从连接中读取
+type prefixConn struct {
+	net.Conn
+	prefix []byte
+}
+
+func (c *prefixConn) Read(b []byte) (int, error) {
+	if len(c.prefix) > 0 {
+		n := copy(b, c.prefix)
+		c.prefix = c.prefix[n:]
+		return n, nil
+	}
+	return c.Conn.Read(b)
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/beijian128/minisocks/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewUser(t *testing.T) {
+	_, err := NewUser("alice", core.GenerateCipherTable())
+	assert.NoError(t, err)
+
+	_, err = NewUser("", core.GenerateCipherTable())
+	assert.Error(t, err, "用户名为空")
+	_, err = NewUser("alice", "not hex")
+	assert.Error(t, err)
+	_, err = NewUser("alice", strings.Repeat("00", 256))
+	assert.Error(t, err, "不是置换表")
+}
+
+func TestUsers(t *testing.T) {
+	alice, err := NewUser("alice", core.GenerateCipherTable())
+	require.NoError(t, err)
+	bob, err := NewUser("bob", core.GenerateCipherTable())
+	require.NoError(t, err)
+	users, err := NewUsers(alice, bob)
+	require.NoError(t, err)
+	assert.Equal(t, 2, users.Len())
+	assert.Same(t, bob, users.Get("bob"))
+
+	duplicate, _ := NewUser("alice", core.GenerateCipherTable())
+	assert.Error(t, users.Add(duplicate), "用户名重复")
+	sameSecret, _ := NewUser("carol", alice.Password())
+	assert.Error(t, users.Add(sameSecret), "密码无法区分")
+
+	assert.True(t, users.Remove("alice"))
+	assert.False(t, users.Remove("alice"))
+	assert.Nil(t, users.Get("alice"))
+	assert.Equal(t, []*User{bob}, users.List())
+}
+
+func TestUser_Greets(t *testing.T) {
+	alice, _ := NewUser("alice", core.GenerateCipherTable())
+	bob, _ := NewUser("bob", core.GenerateCipherTable())
+
+	head := alice.greeting()
+	assert.True(t, alice.greets(head, false))
+	assert.False(t, bob.greets(head, false))
+
+	// 混淆记录头：载荷 3 字节，填充 100 字节，载荷是加密后的协商数据
+	record := binary.BigEndian.AppendUint16(nil, 3)
+	record = binary.BigEndian.AppendUint16(record, 100)
+	record, _ = alice.socket.Cipher.Encrypt(append(record, head...))
+	assert.True(t, alice.greets(record, true))
+	assert.False(t, bob.greets(record, true))
+	assert.False(t, alice.greets(record[:3], false))
+}
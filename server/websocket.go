@@ -0,0 +1,38 @@
+package server
+
+import (
+	"net"
+	"net/http"
+
+	"golang.org/x/net/websocket"
+)
+
+// WebSocketOptions 定义了服务端的 WebSocket 传输，服务端以 HTTP 服务的形式监听，
+// 可以部署在 nginx、CDN 等反向代理之后
+type WebSocketOptions struct {
+	Path string // 升级为 WebSocket 的 HTTP 路径，默认 "/"
+	// RealIPHeader 是反向代理传递客户端真实 IP 的请求头，例如 X-Forwarded-For 或 CF-Connecting-IP，
+	// 为空时使用 TCP 对端地址。该请求头可以被伪造，只应在服务端仅能经由反向代理访问时配置
+	RealIPHeader string
+}
+
+// wsConn 以客户端的真实地址作为 RemoteAddr，websocket.Conn 在服务端返回的是 Origin
+type wsConn struct {
+	*websocket.Conn
+	remoteAddr net.Addr
+}
+
+func (c *wsConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+func (s *LsServer) webSocketHandler() http.Handler {
+	return websocket.Server{
+		// 本地端不是浏览器，不校验 Origin
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(conn *websocket.Conn) {
+			conn.PayloadType = websocket.BinaryFrame
+			s.handleConn(&wsConn{Conn: conn, remoteAddr: s.realAddr(conn.Request())})
+		},
+	}
+}
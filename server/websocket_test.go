@@ -0,0 +1,59 @@
+package server
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/beijian128/minisocks/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebSocketHandler_Fallback(t *testing.T) {
+	decoy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "decoy "+r.URL.Path)
+	}))
+	defer decoy.Close()
+
+	s := New(core.GenerateCipherTable(), &net.TCPAddr{})
+	s.WebSocket = &WebSocketOptions{Path: "/tunnel"}
+	srv := httptest.NewServer(s.httpHandler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/tunnel")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "没有配置诱饵服务时返回 404")
+
+	s.Fallback = &Fallback{Addr: decoy.Listener.Addr().String()}
+	srv.Config.Handler = s.httpHandler()
+	resp, err = http.Get(srv.URL + "/tunnel")
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "decoy /tunnel", string(body), "非 WebSocket 请求交给诱饵服务")
+}
+
+func TestWebSocketHandler_RealIP(t *testing.T) {
+	s := New(core.GenerateCipherTable(), &net.TCPAddr{})
+	s.WebSocket = &WebSocketOptions{Path: "/", RealIPHeader: "X-Forwarded-For"}
+	var err error
+	s.Clients, err = NewClientACL(nil, []string{"203.0.113.0/24"})
+	require.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.1:4000"
+	r.Header.Set("X-Forwarded-For", "203.0.113.9, 10.0.0.1")
+	assert.Equal(t, "203.0.113.9:0", s.realAddr(r).String())
+
+	r.Header.Set("Upgrade", "websocket")
+	w := httptest.NewRecorder()
+	s.httpHandler().ServeHTTP(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code, "真实 IP 被拒绝")
+
+	r.Header.Del("X-Forwarded-For")
+	assert.Equal(t, "10.0.0.1:4000", s.realAddr(r).String())
+}